@@ -1,13 +1,17 @@
 package bncvision
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"math"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -82,7 +86,7 @@ func VerifyOneDirAggTradesContinuity(dir string, maxCpus int) error {
 		wg.Go(func() error {
 			filePath := filepath.Join(dir, file)
 			slog.Info("Reading CSV To Structs", "file", file)
-			aggTrades, err := ReadCSVToStructs(filePath, AggTradeRawToStruct)
+			aggTrades, err := ReadLinesToStructs(filePath, AggTradeLineToStruct)
 			if err != nil {
 				slog.Error("Read CSV To Structs", "file", file, "error", err)
 				return err
@@ -118,138 +122,98 @@ func VerifyOneDirAggTradesContinuity(dir string, maxCpus int) error {
 	return nil
 }
 
-type MissingAggTrades struct {
-	StartId   int64
-	EndId     int64
-	StartTime int64
-	EndTime   int64
-}
+// MissingAggTrades is a gap of agg trade ids.
+type MissingAggTrades = MissingRange
 
+// OneDirAggTradesMissings finds the gaps of agg trade ids in the csv files of dir, see OneDirMissingRanges.
 func OneDirAggTradesMissings(dir string, maxCpus int, startTime time.Time) ([]MissingAggTrades, error) {
-	if maxCpus <= 0 {
-		maxCpus = 1
-	}
+	return OneDirMissingRanges(dir, AggTradesTidyKind("", ""), maxCpus, startTime)
+}
 
-	var validFiles []string
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, err
-	}
-	st := startTime.Format("2006-01-02")
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".csv") {
-			continue
-		}
-		name := strings.TrimSuffix(file.Name(), ".csv")
-		names := strings.Split(name, "-aggTrades-")
-		if len(names) != 2 {
-			continue
-		}
-		if names[1] < st {
-			continue
-		}
-		validFiles = append(validFiles, file.Name())
-	}
+// AggTradesTypeCmFutures is the agg trades type of COIN-M futures, which is not supported by bnc.QueryAggTrades.
+const AggTradesTypeCmFutures bnc.AggTradesType = "cm_futures"
 
-	sort.Slice(validFiles, func(i, j int) bool {
-		return validFiles[i] < validFiles[j]
-	})
+// CM_FUTURES_AGG_TRADES_URL is the COIN-M futures api of agg trades.
+const CM_FUTURES_AGG_TRADES_URL = "https://dapi.binance.com/dapi/v1/aggTrades"
 
-	wg := errgroup.Group{}
-	wg.SetLimit(maxCpus)
-
-	lastIds := make([][2]bnc.AggTrades, len(validFiles))
+// FUTURES_AGG_TRADES_CSV_HEADER is the header of the futures agg trades files of data.binance.vision.
+const FUTURES_AGG_TRADES_CSV_HEADER = "agg_trade_id,price,quantity,first_trade_id,last_trade_id,transact_time,is_buyer_maker"
 
-	var missings []MissingAggTrades
-	mu := sync.Mutex{}
+// IsFuturesAggTradesType returns true if agg trades of tradesType are saved in the futures layout.
+func IsFuturesAggTradesType(tradesType bnc.AggTradesType) bool {
+	return tradesType == bnc.AggTradesTypeUmFutures || tradesType == AggTradesTypeCmFutures
+}
 
-	for i, file := range validFiles {
-		i, file := i, file
-		wg.Go(func() error {
-			filePath := filepath.Join(dir, file)
-			slog.Info("Reading CSV To Structs", "file", file)
-			aggTrades, err := ReadCSVToStructs(filePath, AggTradeRawToStruct)
-			if err != nil {
-				slog.Error("Read CSV To Structs", "file", file, "error", err)
-				return err
-			}
-			slog.Info("Read CSV To Structs", "file", file, "len", len(aggTrades))
-			if len(aggTrades) == 0 {
-				slog.Info("ReadCSVToStructs Skip", "file", file, "len", len(aggTrades))
-				return nil
-			}
-			slog.Info("Verifying Agg Trades Continuity", "file", file)
-			for j, aggTrade := range aggTrades[1:] {
-				lastId := aggTrades[j].Id
-				if aggTrade.Id != lastId+1 {
-					slog.Warn("Missing Agg Trade IDs", "file", file, "from", lastId+1, "to", aggTrade.Id-1)
-					mu.Lock()
-					missings = append(missings, MissingAggTrades{
-						StartId:   lastId + 1,
-						EndId:     aggTrade.Id - 1,
-						StartTime: aggTrades[j].Time,
-						EndTime:   aggTrade.Time,
-					})
-					mu.Unlock()
-				}
-			}
-			slog.Info("Verified Agg Trades Continuity", "file", file)
-			lastIds[i] = [2]bnc.AggTrades{aggTrades[0], aggTrades[len(aggTrades)-1]}
-			return nil
-		})
-	}
+// FuturesAggTradeCSVRow serializes an agg trade in the futures layout, which has no is_best_match column.
+func FuturesAggTradeCSVRow(aggTrade bnc.AggTrades) string {
+	row := aggTrade.CSVRow()
+	return row[:strings.LastIndexByte(row, ',')]
+}
 
-	err = wg.Wait()
+// queryAggTrades queries agg trades from params.FromId with the agg trades api of apiURL,
+// such as CM_FUTURES_AGG_TRADES_URL.
+func (c *Client) queryAggTrades(apiURL string, params bnc.AggTradesParams) ([]bnc.AggTrades, error) {
+	query := url.Values{}
+	query.Set("symbol", params.Symbol)
+	query.Set("fromId", strconv.FormatInt(params.FromId, 10))
+	query.Set("limit", strconv.Itoa(params.Limit))
+	reqURL := apiURL + "?" + query.Encode()
+	resp, err := c.get(reqURL)
 	if err != nil {
 		return nil, err
 	}
-
-	for i, ids := range lastIds[:len(lastIds)-1] {
-		if ids[1].Id+1 != lastIds[i+1][0].Id {
-			slog.Warn("Missing Agg Trade IDs", "file", validFiles[i], "from", ids[1].Id+1, "to", lastIds[i+1][0].Id-1)
-			missings = append(missings, MissingAggTrades{
-				StartId:   ids[1].Id + 1,
-				EndId:     lastIds[i+1][0].Id - 1,
-				StartTime: ids[1].Time,
-				EndTime:   lastIds[i+1][0].Time,
-			})
-		}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPStatusError(reqURL, resp)
 	}
+	var aggTrades []bnc.AggTrades
+	if err := json.NewDecoder(resp.Body).Decode(&aggTrades); err != nil {
+		return nil, err
+	}
+	return aggTrades, nil
+}
 
-	sort.Slice(missings, func(i, j int) bool {
-		return missings[i].StartId < missings[j].StartId
-	})
+// MISSING_AGG_TRADES_TRY_COUNT is the number of attempts of every page queried by DownloadMissingAggTrades,
+// DownloadMissingSpotTrades and DownloadMissingKlines.
+const MISSING_AGG_TRADES_TRY_COUNT = 5
 
-	return missings, nil
+// DownloadMissingAggTrades downloads the agg trades from missing.StartId to missing.EndId, sorted by id,
+// from the spot, USDⓈ-M futures or COIN-M futures api of tradesType, see DownloadMissingAggTradesFrom.
+func DownloadMissingAggTrades(symbol string, tradesType bnc.AggTradesType, missing MissingAggTrades) (trades []bnc.AggTrades, err error) {
+	return DownloadMissingAggTradesFrom(RESTTradeSource{TradesType: tradesType}, symbol, missing, DefaultClient.Retry, MISSING_AGG_TRADES_TRY_COUNT)
 }
 
-func DownloadMissingAggTrades(symbol string, tradesType bnc.AggTradesType, missing MissingAggTrades) (trades []bnc.AggTrades, err error) {
+// DownloadMissingAggTradesFrom downloads the agg trades from missing.StartId to missing.EndId from source, sorted by id.
+// Every page is attempted at most tryCount times with retry between attempts, permanent errors are never retried.
+// Downloading stops at the first empty page, so the result may end before missing.EndId.
+func DownloadMissingAggTradesFrom(source TradeSource, symbol string, missing MissingAggTrades, retry RetryPolicy, tryCount int) (trades []bnc.AggTrades, err error) {
 	fromId := missing.StartId
-	for {
-		if fromId > missing.EndId {
-			break
-		}
+	for fromId <= missing.EndId {
 		var ts []bnc.AggTrades
 		// Binance aggTrades timestamp may be out of order.
 		// So we can't use StartTime and EndTime to query.
-		ts, err = bnc.QueryAggTrades(bnc.AggTradesParams{
+		params := bnc.AggTradesParams{
 			Symbol: symbol,
 			FromId: fromId,
 			Limit:  1000,
-			// StartTime: missing.StartTime,
-			// EndTime:   missing.EndTime,
-		}, tradesType)
+		}
+		ts, err = retryQuery(retry, tryCount, func() ([]bnc.AggTrades, error) {
+			return source.AggTrades(params)
+		}, "Query agg trades attempt failed, retrying", "symbol", symbol, "fromId", fromId)
 		if err != nil {
 			return
 		}
-		if len(ts) == 0 {
+		if len(ts) == 0 || ts[len(ts)-1].Id < fromId {
+			gLogger.Warn("Empty Agg Trades Page", "symbol", symbol, "fromId", fromId, "to", missing.EndId)
 			break
 		}
 		for _, t := range ts {
 			if t.Id > missing.EndId {
 				break
 			}
-			trades = append(trades, t)
+			if t.Id >= fromId {
+				trades = append(trades, t)
+			}
 		}
 		fromId = ts[len(ts)-1].Id + 1
 	}
@@ -260,143 +224,167 @@ func DownloadMissingAggTrades(symbol string, tradesType bnc.AggTradesType, missi
 }
 
 func DownloadMissingAggTradesAndSave(dir, symbol string, tradesType bnc.AggTradesType, missing MissingAggTrades) (trades []bnc.AggTrades, err error) {
-	trades, err = DownloadMissingAggTrades(symbol, tradesType, missing)
-	if err != nil {
-		return
-	}
-	var csvRows []string
-	for _, trade := range trades {
-		csvRows = append(csvRows, trade.CSVRow())
-	}
-	fileName := symbol + "-aggTrades-" + time.UnixMilli(missing.StartTime).Format("2006-01-02") + ".csv"
-	filePath := filepath.Join(dir, fileName)
-	err = os.WriteFile(filePath, []byte(strings.Join(csvRows, "\n")), 0666)
-	if err != nil {
-		return
-	}
-	return
+	return DownloadMissingAndSave(dir, AggTradesTidyKind(symbol, tradesType), missing)
 }
 
 func ScanOneDirAggTradesMissingsAndDownload(aggTradesDir, saveDir, symbol string, tradesType bnc.AggTradesType, maxCpus int, startTime time.Time) error {
-	err := os.MkdirAll(saveDir, 0777)
-	if err != nil {
-		return err
-	}
-	missings, err := OneDirAggTradesMissings(aggTradesDir, maxCpus, startTime)
-	if err != nil {
-		return err
-	}
-	for _, missing := range missings {
-		slog.Info("Downloading Missing Agg Trades", "symbol", symbol, "start", time.UnixMilli(missing.StartTime).Format(time.RFC3339Nano), "end", time.UnixMilli(missing.EndTime).Format(time.RFC3339Nano), "fromId", missing.StartId, "toId", missing.EndId)
-		_, err = DownloadMissingAggTradesAndSave(saveDir, symbol, tradesType, missing)
-		if err != nil {
-			return err
-		}
-		slog.Info("Downloaded Missing Agg Trades", "symbol", symbol, "start", time.UnixMilli(missing.StartTime).Format(time.RFC3339Nano), "end", time.UnixMilli(missing.EndTime).Format(time.RFC3339Nano), "fromId", missing.StartId, "toId", missing.EndId)
-	}
-	return nil
+	return ScanOneDirMissingsAndDownload(aggTradesDir, saveDir, AggTradesTidyKind(symbol, tradesType), maxCpus, startTime)
 }
 
 type TidyOneDirAggTradesParams struct {
-	RawDir              string
-	MissingDir          string
-	TidyDir             string
-	Symbol              string
+	RawDir     string
+	MissingDir string
+	TidyDir    string
+	Symbol     string
+	// TradesType decides the layout of tidy files, the spot layout if empty.
+	TradesType          bnc.AggTradesType
 	MaxCpus             int
 	CheckTidyFileExists bool
 }
 
 func TidyOneDirAggTrades(p TidyOneDirAggTradesParams) error {
-	err := os.MkdirAll(tidyDir, 0777)
-	if err != nil {
-		return err
-	}
-	files, err := os.ReadDir(p.RawDir)
-	if err != nil {
-		return err
-	}
+	return TidyOneDir(AggTradesTidyKind(p.Symbol, p.TradesType), TidyOneDirParams{
+		RawDir:              p.RawDir,
+		MissingDir:          p.MissingDir,
+		TidyDir:             p.TidyDir,
+		MaxCpus:             p.MaxCpus,
+		CheckTidyFileExists: p.CheckTidyFileExists,
+	})
+}
 
-	if p.MaxCpus <= 0 {
-		p.MaxCpus = 1
+// AggTradesKline is a kline rebuilt from agg trades, with the statistics which official klines do not have.
+// The embedded Kline has the same columns as official klines, TradesNumber included,
+// so it can still be compared with official klines and saved by BncKlineToCSVRaw.
+type AggTradesKline struct {
+	bnc.Kline
+	// Vwap is the volume weighted average price, QuoteAssetVolume / Volume, 0 if the kline has no volume.
+	Vwap float64
+	// AggTradesNumber is the number of agg trades.
+	AggTradesNumber int64
+	// BuyTradesNumber is the number of trades whose taker is the buyer.
+	BuyTradesNumber int64
+	// SellTradesNumber is the number of trades whose taker is the seller.
+	SellTradesNumber int64
+}
+
+// AggTradesKlineToCSVRaw serializes a kline in the columns of BncKlineToCSVRaw,
+// followed by vwap, agg trades number, buy trades number and sell trades number.
+func AggTradesKlineToCSVRaw(kline AggTradesKline) string {
+	cells := []string{
+		BncKlineToCSVRaw(kline.Kline),
+		mathy.BN(kline.Vwap).Round(8).String(),
+		strconv.FormatInt(kline.AggTradesNumber, 10),
+		strconv.FormatInt(kline.BuyTradesNumber, 10),
+		strconv.FormatInt(kline.SellTradesNumber, 10),
+	}
+	return strings.Join(cells, ",")
+}
+
+// aggTradesKlineMerger merges agg trades into klines one at a time,
+// so agg trades can be streamed instead of loaded into memory as a whole.
+// Klines without any agg trade are filled with the previous close price.
+type aggTradesKlineMerger struct {
+	interval time.Duration
+	// bucketOf returns the open and close times of the kline of a trade time.
+	// If it's nil, klines are every interval from the UTC midnight of the first agg trade.
+	bucketOf klineBucketFunc
+	kline    *AggTradesKline
+	klines   []*AggTradesKline
+}
+
+func newAggTradesKlineMerger(interval time.Duration) (*aggTradesKlineMerger, error) {
+	if interval == 0 {
+		return nil, fmt.Errorf("interval is 0")
 	}
+	return &aggTradesKlineMerger{interval: interval}, nil
+}
 
-	wg := errgroup.Group{}
-	wg.SetLimit(p.MaxCpus)
+// newKline returns a kline of the bucket [start, end) without any trade.
+func (m *aggTradesKlineMerger) newKline(start, end int64, price float64) *AggTradesKline {
+	return &AggTradesKline{Kline: bnc.Kline{
+		OpenTime:   start,
+		CloseTime:  end - 1,
+		OpenPrice:  price,
+		ClosePrice: price,
+		HighPrice:  price,
+		LowPrice:   price,
+	}}
+}
 
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".csv") {
-			continue
+func (m *aggTradesKlineMerger) add(aggTrade bnc.AggTrades) {
+	// newer spot agg trades are in microseconds
+	aggTrade.Time = rowTimeToMilli(aggTrade.Time)
+
+	if m.kline == nil {
+		if m.bucketOf == nil {
+			anchor := truncateDay(time.UnixMilli(aggTrade.Time)).UnixMilli()
+			m.bucketOf = anchoredKlineBuckets(m.interval.Milliseconds(), anchor)
 		}
-		file := file
-		wg.Go(func() error {
-			tidyFilePath := filepath.Join(p.TidyDir, file.Name())
-			if p.CheckTidyFileExists {
-				tidyFileExists, err := FileExists(tidyFilePath)
-				if err != nil {
-					return err
-				}
-				if tidyFileExists {
-					return nil
-				}
-			}
-			missingFilePath := filepath.Join(p.MissingDir, file.Name())
-			missingFileExists, err := FileExists(missingFilePath)
-			if err != nil {
-				return err
-			}
-			rawFilePath := filepath.Join(p.RawDir, file.Name())
-			if !missingFileExists {
-				src, err := os.Open(rawFilePath)
-				if err != nil {
-					src.Close()
-					return err
-				}
-				dst, err := os.Create(tidyFilePath)
-				if err != nil {
-					src.Close()
-					return err
-				}
-				slog.Info("Copying Raw Agg Trades", "file", file.Name())
-				_, err = io.Copy(dst, src)
-				if err != nil {
-					dst.Close()
-					src.Close()
-					return err
-				}
-				slog.Info("Copied Raw Agg Trades", "file", file.Name())
-				dst.Close()
-				src.Close()
-				return nil
-			}
-			slog.Info("Merging Raw And Missing Agg Trades", "file", file.Name())
-			rawAggTrades, err := ReadCSVToStructs(rawFilePath, AggTradeRawToStruct)
-			if err != nil {
-				return err
-			}
-			missingAggTrades, err := ReadCSVToStructs(missingFilePath, AggTradeRawToStruct)
-			if err != nil {
-				return err
-			}
-			aggTrades := append(rawAggTrades, missingAggTrades...)
-			sort.Slice(aggTrades, func(i, j int) bool {
-				return aggTrades[i].Id < aggTrades[j].Id
-			})
-			slog.Info("Merged Raw And Missing Agg Trades", "file", file.Name(), "len", len(aggTrades))
-			var csvRows []string
-			for _, aggTrade := range aggTrades {
-				csvRows = append(csvRows, aggTrade.CSVRow())
-			}
-			slog.Info("Writing Tidy Agg Trades", "file", file.Name())
-			err = os.WriteFile(tidyFilePath, []byte(strings.Join(csvRows, "\n")), 0666)
-			if err != nil {
-				return err
+		start, end := m.bucketOf(aggTrade.Time)
+		m.kline = m.newKline(start, end, aggTrade.Price)
+	}
+
+	kline := m.kline
+
+	if aggTrade.Time > kline.CloseTime {
+		m.klines = append(m.klines, kline)
+
+		for {
+			start, end := m.bucketOf(kline.CloseTime + 1)
+			if aggTrade.Time < end {
+				break
 			}
-			slog.Info("Saved Tidy Agg Trades", "file", file.Name())
-			return nil
-		})
+			kline = m.newKline(start, end, kline.ClosePrice)
+			m.klines = append(m.klines, kline)
+		}
+
+		start, end := m.bucketOf(aggTrade.Time)
+		kline = m.newKline(start, end, aggTrade.Price)
+		m.kline = kline
+	}
+
+	kline.HighPrice = math.Max(kline.HighPrice, aggTrade.Price)
+	kline.LowPrice = math.Min(kline.LowPrice, aggTrade.Price)
+	kline.ClosePrice = aggTrade.Price
+	kline.Volume = mathy.BN(kline.Volume).Add(mathy.BN(aggTrade.Qty)).Round(8).Float64()
+	kline.QuoteAssetVolume = mathy.BN(kline.QuoteAssetVolume).Add(mathy.BN(aggTrade.Qty * aggTrade.Price)).Round(8).Float64()
+	tradesNumber := aggTrade.LastTradeId - aggTrade.FirstTradeId + 1
+	kline.TradesNumber += tradesNumber
+	kline.AggTradesNumber++
+	if !aggTrade.IsBuyerMaker {
+		kline.TakerBuyBaseAssetVolume = mathy.BN(kline.TakerBuyBaseAssetVolume).Add(mathy.BN(aggTrade.Qty)).Round(8).Float64()
+		kline.TakerBuyQuoteAssetVolume = mathy.BN(kline.TakerBuyQuoteAssetVolume).Add(mathy.BN(aggTrade.Qty * aggTrade.Price)).Round(8).Float64()
+		kline.BuyTradesNumber += tradesNumber
+	} else {
+		kline.SellTradesNumber += tradesNumber
 	}
+}
 
-	return wg.Wait()
+// merged returns the merged klines with their vwaps.
+func (m *aggTradesKlineMerger) merged() []*AggTradesKline {
+	if m.kline == nil {
+		return nil
+	}
+	klines := append(m.klines, m.kline)
+	for _, kline := range klines {
+		if kline.Volume != 0 {
+			kline.Vwap = mathy.BN(kline.QuoteAssetVolume).Div(mathy.BN(kline.Volume)).Round(8).Float64()
+		}
+	}
+	return klines
+}
+
+// mergedKlines returns the merged klines without the statistics of AggTradesKline.
+func (m *aggTradesKlineMerger) mergedKlines() []*bnc.Kline {
+	merged := m.merged()
+	if merged == nil {
+		return nil
+	}
+	klines := make([]*bnc.Kline, len(merged))
+	for i, kline := range merged {
+		klines[i] = &kline.Kline
+	}
+	return klines
 }
 
 func AggTradesToKlines(aggTrades []bnc.AggTrades, interval time.Duration) ([]*bnc.Kline, error) {
@@ -404,79 +392,40 @@ func AggTradesToKlines(aggTrades []bnc.AggTrades, interval time.Duration) ([]*bn
 		return nil, nil
 	}
 
-	if interval == 0 {
-		return nil, fmt.Errorf("interval is 0")
+	merger, err := newAggTradesKlineMerger(interval)
+	if err != nil {
+		return nil, err
 	}
 
-	firstAggTrade := aggTrades[0]
-
-	startTime := time.UnixMilli(firstAggTrade.Time)
+	for _, aggTrade := range aggTrades {
+		merger.add(aggTrade)
+	}
 
-	openTime := time.Date(startTime.Year(), startTime.Month(), startTime.Day(), 0, 0, 0, 0, time.UTC)
+	return merger.mergedKlines(), nil
+}
 
-	if interval < time.Hour*24 {
-		openTime = openTime.Add(startTime.Sub(openTime) / interval * interval)
+// AggTradesToAggTradesKlines is the same as AggTradesToKlines,
+// and returns the klines with vwaps and the numbers of agg trades, buy trades and sell trades.
+func AggTradesToAggTradesKlines(aggTrades []bnc.AggTrades, interval time.Duration) ([]*AggTradesKline, error) {
+	if len(aggTrades) == 0 {
+		return nil, nil
 	}
 
-	var klines []*bnc.Kline
-
-	kline := &bnc.Kline{
-		OpenTime:   openTime.UnixMilli(),
-		CloseTime:  openTime.Add(interval).UnixMilli() - 1,
-		OpenPrice:  firstAggTrade.Price,
-		ClosePrice: firstAggTrade.Price,
-		HighPrice:  firstAggTrade.Price,
-		LowPrice:   firstAggTrade.Price,
+	merger, err := newAggTradesKlineMerger(interval)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, aggTrade := range aggTrades {
-		if aggTrade.Time > kline.CloseTime {
-			klines = append(klines, kline)
-
-			i := (aggTrade.Time-kline.OpenTime)/int64(interval) - 1
-			for ; i > 0; i-- {
-				openTime = openTime.Add(interval)
-				kline = &bnc.Kline{
-					OpenTime:   openTime.UnixMilli(),
-					CloseTime:  openTime.Add(interval).UnixMilli() - 1,
-					OpenPrice:  kline.ClosePrice,
-					ClosePrice: kline.ClosePrice,
-					HighPrice:  kline.ClosePrice,
-					LowPrice:   kline.ClosePrice,
-				}
-				klines = append(klines, kline)
-			}
-
-			openTime = openTime.Add(interval)
-
-			kline = &bnc.Kline{
-				OpenTime:  openTime.UnixMilli(),
-				CloseTime: openTime.Add(interval).UnixMilli() - 1,
-				OpenPrice: aggTrade.Price,
-				HighPrice: aggTrade.Price,
-				LowPrice:  aggTrade.Price,
-			}
-		}
-
-		kline.HighPrice = math.Max(kline.HighPrice, aggTrade.Price)
-		kline.LowPrice = math.Min(kline.LowPrice, aggTrade.Price)
-		kline.ClosePrice = aggTrade.Price
-		kline.Volume = mathy.BN(kline.Volume).Add(mathy.BN(aggTrade.Qty)).Round(8).Float64()
-		kline.QuoteAssetVolume = mathy.BN(kline.QuoteAssetVolume).Add(mathy.BN(aggTrade.Qty * aggTrade.Price)).Round(8).Float64()
-		if !aggTrade.IsBuyerMaker {
-			kline.TakerBuyBaseAssetVolume = mathy.BN(kline.TakerBuyBaseAssetVolume).Add(mathy.BN(aggTrade.Qty)).Round(8).Float64()
-			kline.TakerBuyQuoteAssetVolume = mathy.BN(kline.TakerBuyQuoteAssetVolume).Add(mathy.BN(aggTrade.Qty * aggTrade.Price)).Round(8).Float64()
-		}
+		merger.add(aggTrade)
 	}
 
-	klines = append(klines, kline)
-
-	return klines, nil
+	return merger.merged(), nil
 }
 
 func OneDirAggTradesToInnerDayKlines(dir string, interval time.Duration, maxCpus int) ([]*bnc.Kline, error) {
 	if interval.Hours() >= 24 {
-		return nil, fmt.Errorf("interval must be less than one day")
+		return nil, fmt.Errorf("interval must be less than one day, use OneDirAggTradesToSessionKlines instead")
 	}
 
 	if maxCpus <= 0 {
@@ -503,7 +452,7 @@ func OneDirAggTradesToInnerDayKlines(dir string, interval time.Duration, maxCpus
 		return validFiles[i] < validFiles[j]
 	})
 
-	wg := errgroup.Group{}
+	wg, ctx := errgroup.WithContext(context.Background())
 	wg.SetLimit(maxCpus)
 	mu := sync.Mutex{}
 	klines := []*bnc.Kline{}
@@ -511,20 +460,33 @@ func OneDirAggTradesToInnerDayKlines(dir string, interval time.Duration, maxCpus
 	for _, file := range validFiles {
 		file := file
 		wg.Go(func() error {
-			slog.Info("Reading CSV To Structs", "file", file)
-			aggTrades, err := ReadCSVToStructsWithFilter(filepath.Join(dir, file), AggTradeRawToStruct, AggTradesReadFilter)
+			slog.Info("Streaming Lines To Structs", "file", file)
+			stream, err := StreamLinesToStructs(ctx, filepath.Join(dir, file), AggTradeLineToStruct)
 			if err != nil {
-				slog.Error("Read CSV To Structs", "file", file, "error", err)
+				slog.Error("Stream Lines To Structs", "file", file, "error", err)
 				return err
 			}
-			slog.Info("Read CSV To Structs", "file", file, "len", len(aggTrades))
-			slog.Info("Merging Agg Trades To Klines", "file", file, "len", len(aggTrades))
-			kl, err := AggTradesToKlines(aggTrades, interval)
+			defer stream.Close()
+			merger, err := newAggTradesKlineMerger(interval)
 			if err != nil {
+				return err
+			}
+			slog.Info("Merging Agg Trades To Klines", "file", file)
+			var n int
+			for stream.Next() {
+				aggTrade := stream.Struct()
+				if !AggTradesReadFilter(aggTrade) {
+					continue
+				}
+				merger.add(aggTrade)
+				n++
+			}
+			if err := stream.Err(); err != nil {
 				slog.Error("Merging Agg Trades To Klines", "file", file, "error", err)
 				return err
 			}
-			slog.Info("Merged Agg Trades To Klines", "file", file, "len", len(kl))
+			kl := merger.mergedKlines()
+			slog.Info("Merged Agg Trades To Klines", "file", file, "aggTrades", n, "len", len(kl))
 			mu.Lock()
 			klines = append(klines, kl...)
 			mu.Unlock()
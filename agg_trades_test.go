@@ -2,6 +2,9 @@ package bncvision
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -238,3 +241,85 @@ func TestDownloadMissingAggTrades(t *testing.T) {
 		fmt.Println(ti)
 	}
 }
+
+func TestOneDirAggTradesMissings(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]bnc.AggTrades{
+		"BTCUSDT-aggTrades-2021-01-01.csv": {
+			{Id: 1, Price: 100, Qty: 1, FirstTradeId: 1, LastTradeId: 1, Time: 1609459200000},
+			{Id: 2, Price: 100, Qty: 1, FirstTradeId: 2, LastTradeId: 2, Time: 1609459200001},
+			{Id: 5, Price: 100, Qty: 1, FirstTradeId: 5, LastTradeId: 5, Time: 1609459200002},
+		},
+		"BTCUSDT-aggTrades-2021-01-02.csv": {
+			{Id: 8, Price: 100, Qty: 1, FirstTradeId: 8, LastTradeId: 8, Time: 1609545600000},
+			{Id: 9, Price: 100, Qty: 1, FirstTradeId: 9, LastTradeId: 9, Time: 1609545600001},
+		},
+	}
+	for name, aggTrades := range files {
+		var rows []string
+		for _, aggTrade := range aggTrades {
+			rows = append(rows, aggTrade.CSVRow())
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(rows, "\n")), 0644); err != nil {
+			t.Fatalf("Failed to write csv file: %v", err)
+		}
+	}
+
+	missings, err := OneDirAggTradesMissings(dir, 2, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("OneDirAggTradesMissings failed: %v", err)
+	}
+	expected := []MissingAggTrades{
+		{StartId: 3, EndId: 4, StartTime: 1609459200001, EndTime: 1609459200002},
+		{StartId: 6, EndId: 7, StartTime: 1609459200002, EndTime: 1609545600000},
+	}
+	if len(missings) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, missings)
+	}
+	for i := range expected {
+		if missings[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], missings[i])
+		}
+	}
+}
+
+func TestAggTradesToAggTradesKlines(t *testing.T) {
+	aggTrades := []bnc.AggTrades{
+		{Id: 1, Time: 1609459200000, Price: 100, Qty: 1, FirstTradeId: 10, LastTradeId: 12, IsBuyerMaker: false},
+		{Id: 2, Time: 1609459210000, Price: 110, Qty: 3, FirstTradeId: 13, LastTradeId: 13, IsBuyerMaker: true},
+		{Id: 3, Time: 1609459330000, Price: 120, Qty: 2, FirstTradeId: 14, LastTradeId: 15, IsBuyerMaker: false},
+	}
+	klines, err := AggTradesToAggTradesKlines(aggTrades, time.Minute)
+	if err != nil {
+		t.Fatalf("AggTradesToAggTradesKlines failed: %v", err)
+	}
+	if len(klines) != 3 {
+		t.Fatalf("Expected 3 klines, got %d", len(klines))
+	}
+	first := klines[0]
+	if first.TradesNumber != 4 || first.AggTradesNumber != 2 || first.BuyTradesNumber != 3 || first.SellTradesNumber != 1 {
+		t.Errorf("Unexpected numbers %+v", *first)
+	}
+	if first.Vwap != 107.5 {
+		t.Errorf("Expected vwap 107.5, got %v", first.Vwap)
+	}
+	if empty := klines[1]; empty.TradesNumber != 0 || empty.Vwap != 0 || empty.ClosePrice != 110 {
+		t.Errorf("Unexpected empty kline %+v", *empty)
+	}
+	if last := klines[2]; last.TradesNumber != 2 || last.BuyTradesNumber != 2 || last.Vwap != 120 {
+		t.Errorf("Unexpected last kline %+v", *last)
+	}
+
+	row := AggTradesKlineToCSVRaw(*first)
+	if !strings.HasPrefix(row, BncKlineToCSVRaw(first.Kline)+",") || !strings.HasSuffix(row, ",107.5,2,3,1") {
+		t.Errorf("Unexpected csv row %s", row)
+	}
+
+	plain, err := AggTradesToKlines(aggTrades, time.Minute)
+	if err != nil {
+		t.Fatalf("AggTradesToKlines failed: %v", err)
+	}
+	if len(plain) != 3 || *plain[0] != first.Kline {
+		t.Errorf("Expected the same klines as AggTradesToAggTradesKlines, got %+v", plain)
+	}
+}
@@ -0,0 +1,375 @@
+package bncvision
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+// BarTrade is a trade seen by bar builders, converted from agg trades or trades,
+// so bars can be built from either of them.
+type BarTrade struct {
+	// Time is the time of the trade in milliseconds.
+	Time  int64
+	Price float64
+	Qty   float64
+	// TradesNumber is the number of trades, which is more than 1 for agg trades.
+	TradesNumber int64
+	IsBuyerMaker bool
+}
+
+// AggTradeToBarTrade converts an agg trade to a BarTrade.
+func AggTradeToBarTrade(aggTrade bnc.AggTrades) BarTrade {
+	return BarTrade{
+		Time:         rowTimeToMilli(aggTrade.Time),
+		Price:        aggTrade.Price,
+		Qty:          aggTrade.Qty,
+		TradesNumber: aggTrade.LastTradeId - aggTrade.FirstTradeId + 1,
+		IsBuyerMaker: aggTrade.IsBuyerMaker,
+	}
+}
+
+// SpotTradeToBarTrade converts a spot trade to a BarTrade.
+func SpotTradeToBarTrade(trade bnc.SpotTrade) BarTrade {
+	return BarTrade{
+		Time:         rowTimeToMilli(trade.Time),
+		Price:        trade.Price,
+		Qty:          trade.Qty,
+		TradesNumber: 1,
+		IsBuyerMaker: trade.IsBuyerMaker,
+	}
+}
+
+// sign returns 1 if the taker is the buyer, -1 otherwise.
+// The side of the taker is known, so the tick rule is not needed.
+func (t BarTrade) sign() float64 {
+	if t.IsBuyerMaker {
+		return -1
+	}
+	return 1
+}
+
+// Bar is an information-driven bar, whose open and close times are the times of its first and last trades.
+type Bar struct {
+	OpenTime            int64
+	CloseTime           int64
+	OpenPrice           float64
+	HighPrice           float64
+	LowPrice            float64
+	ClosePrice          float64
+	Volume              float64
+	QuoteVolume         float64
+	TakerBuyVolume      float64
+	TakerBuyQuoteVolume float64
+	// Vwap is QuoteVolume / Volume.
+	Vwap float64
+	// TicksNumber is the number of BarTrades, such as agg trades.
+	TicksNumber int64
+	// TradesNumber is the number of original trades.
+	TradesNumber int64
+}
+
+func (b *Bar) add(trade BarTrade) {
+	quote := trade.Price * trade.Qty
+	if b.TicksNumber == 0 {
+		*b = Bar{OpenTime: trade.Time, OpenPrice: trade.Price, HighPrice: trade.Price, LowPrice: trade.Price}
+	}
+	b.CloseTime = trade.Time
+	b.HighPrice = math.Max(b.HighPrice, trade.Price)
+	b.LowPrice = math.Min(b.LowPrice, trade.Price)
+	b.ClosePrice = trade.Price
+	b.Volume += trade.Qty
+	b.QuoteVolume += quote
+	if !trade.IsBuyerMaker {
+		b.TakerBuyVolume += trade.Qty
+		b.TakerBuyQuoteVolume += quote
+	}
+	b.TicksNumber++
+	b.TradesNumber += trade.TradesNumber
+	if b.Volume != 0 {
+		b.Vwap = b.QuoteVolume / b.Volume
+	}
+}
+
+// BarBuilder builds bars from trades in time order, one trade at a time.
+// Its state is kept between calls, so bars continue across day files instead of resetting at midnight.
+type BarBuilder interface {
+	// Add adds a trade, ok is true if the trade closes a bar.
+	Add(trade BarTrade) (bar Bar, ok bool)
+	// Pending returns the bar which is not closed yet, ok is false if it has no trade.
+	Pending() (bar Bar, ok bool)
+}
+
+// thresholdBarBuilder closes a bar once the measure of its trades reaches a fixed threshold.
+type thresholdBarBuilder struct {
+	threshold float64
+	measure   func(BarTrade) float64
+	sum       float64
+	bar       Bar
+}
+
+func newThresholdBarBuilder(name string, threshold float64, measure func(BarTrade) float64) (BarBuilder, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("%s bar threshold must be positive, got %v", name, threshold)
+	}
+	return &thresholdBarBuilder{threshold: threshold, measure: measure}, nil
+}
+
+// NewTickBarBuilder returns a BarBuilder closing a bar every ticks trades.
+func NewTickBarBuilder(ticks int64) (BarBuilder, error) {
+	return newThresholdBarBuilder("tick", float64(ticks), func(BarTrade) float64 { return 1 })
+}
+
+// NewVolumeBarBuilder returns a BarBuilder closing a bar once its base volume reaches volume.
+func NewVolumeBarBuilder(volume float64) (BarBuilder, error) {
+	return newThresholdBarBuilder("volume", volume, func(t BarTrade) float64 { return t.Qty })
+}
+
+// NewDollarBarBuilder returns a BarBuilder closing a bar once its quote volume reaches quoteVolume.
+func NewDollarBarBuilder(quoteVolume float64) (BarBuilder, error) {
+	return newThresholdBarBuilder("dollar", quoteVolume, func(t BarTrade) float64 { return t.Price * t.Qty })
+}
+
+func (b *thresholdBarBuilder) Add(trade BarTrade) (bar Bar, ok bool) {
+	b.bar.add(trade)
+	b.sum += b.measure(trade)
+	if b.sum < b.threshold {
+		return
+	}
+	bar, b.bar, b.sum = b.bar, Bar{}, 0
+	return bar, true
+}
+
+func (b *thresholdBarBuilder) Pending() (Bar, bool) {
+	return b.bar, b.bar.TicksNumber > 0
+}
+
+// AdaptiveBarConfig configures the adaptive thresholds of imbalance and run bars.
+// The expected number of ticks of a bar and the expected imbalance per tick are
+// exponentially weighted moving averages over the closed bars.
+type AdaptiveBarConfig struct {
+	// InitialTicks is the expected number of ticks of the first bar,
+	// and the number of ticks used to estimate the first expected imbalance.
+	InitialTicks int64
+	// Alpha is the weight of the last bar in the moving averages, in (0, 1].
+	Alpha float64
+	// MinTicks and MaxTicks bound the expected number of ticks, so thresholds can not collapse or explode.
+	// Bars also have at least MinTicks ticks. No bound if 0.
+	MinTicks int64
+	MaxTicks int64
+}
+
+func (c AdaptiveBarConfig) validate() error {
+	if c.InitialTicks <= 0 {
+		return fmt.Errorf("initial ticks must be positive, got %d", c.InitialTicks)
+	}
+	if c.Alpha <= 0 || c.Alpha > 1 {
+		return fmt.Errorf("alpha must be in (0, 1], got %v", c.Alpha)
+	}
+	if c.MaxTicks > 0 && c.MinTicks > c.MaxTicks {
+		return fmt.Errorf("min ticks %d is more than max ticks %d", c.MinTicks, c.MaxTicks)
+	}
+	return nil
+}
+
+func (c AdaptiveBarConfig) boundTicks(ticks float64) float64 {
+	if c.MinTicks > 0 {
+		ticks = math.Max(ticks, float64(c.MinTicks))
+	}
+	if c.MaxTicks > 0 {
+		ticks = math.Min(ticks, float64(c.MaxTicks))
+	}
+	return ticks
+}
+
+// minExpectedImbalanceRatio floors the expected imbalance per tick as a ratio of the expected value per tick.
+// Balanced buys and sells, such as an alternating warm-up, expect no imbalance,
+// and a threshold of 0 would close a bar on every tick from then on.
+const minExpectedImbalanceRatio = 0.1
+
+// adaptiveBarBuilder builds imbalance and run bars.
+//
+// For imbalance bars, θ is the sum of sign * value of the ticks of the bar,
+// and the bar is closed once |θ| >= E[T] * |E[sign * value]|, see minExpectedImbalanceRatio.
+// For run bars, θ is the larger of the buy value and the sell value of the bar,
+// and the bar is closed once θ >= E[T] * max(E[buy value], E[sell value]).
+// Value is 1 for tick bars and the base volume for volume bars, expectations are per tick.
+type adaptiveBarBuilder struct {
+	cfg   AdaptiveBarConfig
+	run   bool
+	value func(BarTrade) float64
+
+	expectedTicks float64
+	// expected buy and sell values per tick, the imbalance is their difference
+	expectedBuy  float64
+	expectedSell float64
+	// warm is true once the first expectations are estimated
+	warm bool
+
+	buy  float64
+	sell float64
+	bar  Bar
+}
+
+func newAdaptiveBarBuilder(cfg AdaptiveBarConfig, run bool, value func(BarTrade) float64) (BarBuilder, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &adaptiveBarBuilder{
+		cfg:           cfg,
+		run:           run,
+		value:         value,
+		expectedTicks: cfg.boundTicks(float64(cfg.InitialTicks)),
+	}, nil
+}
+
+func barTick(BarTrade) float64 { return 1 }
+
+func barVolume(t BarTrade) float64 { return t.Qty }
+
+// NewTickImbalanceBarBuilder returns a BarBuilder of tick imbalance bars.
+func NewTickImbalanceBarBuilder(cfg AdaptiveBarConfig) (BarBuilder, error) {
+	return newAdaptiveBarBuilder(cfg, false, barTick)
+}
+
+// NewVolumeImbalanceBarBuilder returns a BarBuilder of volume imbalance bars.
+func NewVolumeImbalanceBarBuilder(cfg AdaptiveBarConfig) (BarBuilder, error) {
+	return newAdaptiveBarBuilder(cfg, false, barVolume)
+}
+
+// NewTickRunBarBuilder returns a BarBuilder of tick run bars.
+func NewTickRunBarBuilder(cfg AdaptiveBarConfig) (BarBuilder, error) {
+	return newAdaptiveBarBuilder(cfg, true, barTick)
+}
+
+// NewVolumeRunBarBuilder returns a BarBuilder of volume run bars.
+func NewVolumeRunBarBuilder(cfg AdaptiveBarConfig) (BarBuilder, error) {
+	return newAdaptiveBarBuilder(cfg, true, barVolume)
+}
+
+func (b *adaptiveBarBuilder) Add(trade BarTrade) (bar Bar, ok bool) {
+	b.bar.add(trade)
+	value := b.value(trade)
+	if trade.sign() > 0 {
+		b.buy += value
+	} else {
+		b.sell += value
+	}
+
+	if !b.warm {
+		// the first expectations come from the first InitialTicks ticks, which are all in the first bar
+		if b.bar.TicksNumber < b.cfg.InitialTicks {
+			return
+		}
+		b.expectedBuy = b.buy / float64(b.bar.TicksNumber)
+		b.expectedSell = b.sell / float64(b.bar.TicksNumber)
+		b.warm = true
+	}
+
+	if b.bar.TicksNumber < b.cfg.MinTicks || b.theta() < b.threshold() {
+		return
+	}
+
+	ticks := float64(b.bar.TicksNumber)
+	alpha := b.cfg.Alpha
+	b.expectedTicks = b.cfg.boundTicks(alpha*ticks + (1-alpha)*b.expectedTicks)
+	b.expectedBuy = alpha*b.buy/ticks + (1-alpha)*b.expectedBuy
+	b.expectedSell = alpha*b.sell/ticks + (1-alpha)*b.expectedSell
+
+	bar, b.bar, b.buy, b.sell = b.bar, Bar{}, 0, 0
+	return bar, true
+}
+
+func (b *adaptiveBarBuilder) theta() float64 {
+	if b.run {
+		return math.Max(b.buy, b.sell)
+	}
+	return math.Abs(b.buy - b.sell)
+}
+
+func (b *adaptiveBarBuilder) threshold() float64 {
+	if b.run {
+		return b.expectedTicks * math.Max(b.expectedBuy, b.expectedSell)
+	}
+	imbalance := math.Max(math.Abs(b.expectedBuy-b.expectedSell), minExpectedImbalanceRatio*(b.expectedBuy+b.expectedSell))
+	return b.expectedTicks * imbalance
+}
+
+func (b *adaptiveBarBuilder) Pending() (Bar, bool) {
+	return b.bar, b.bar.TicksNumber > 0
+}
+
+// TradesToBars adds trades to builder in order, and returns the closed bars.
+// The bar which is not closed stays in builder, see BarBuilder.Pending.
+func TradesToBars[T any](trades []T, toBarTrade func(T) BarTrade, builder BarBuilder) []Bar {
+	var bars []Bar
+	for _, trade := range trades {
+		if bar, ok := builder.Add(toBarTrade(trade)); ok {
+			bars = append(bars, bar)
+		}
+	}
+	return bars
+}
+
+// OneDirTradesToBars streams the csv files of dir in date order to builder, and returns the closed bars.
+// Files are read one by one, so bars continue across files.
+// The bar which is not closed stays in builder, so the next directory or day can continue it.
+//
+// Parameters:
+//   - dir: The directory of the csv files.
+//   - startTime: Files before the day of startTime are skipped.
+//   - lineToStruct: Converts a csv line to a trade.
+//   - filter: Trades are skipped if it returns false, no filter if nil.
+//   - toBarTrade: Converts a trade to a BarTrade.
+//   - builder: Builds the bars.
+//
+// Returns:
+//   - The closed bars.
+//   - An error if any file can not be read, nil otherwise.
+func OneDirTradesToBars[T any](dir string, startTime time.Time, lineToStruct LineToStructFunc[T], filter func(T) bool, toBarTrade func(T) BarTrade, builder BarBuilder) ([]Bar, error) {
+	files, err := tidyDirFiles(dir, startTime)
+	if err != nil {
+		return nil, err
+	}
+	var bars []Bar
+	for _, file := range files {
+		gLogger.Info("Streaming Lines To Structs", "file", file)
+		stream, err := StreamLinesToStructs(context.Background(), filepath.Join(dir, file), lineToStruct)
+		if err != nil {
+			gLogger.Error("Stream Lines To Structs", "file", file, "error", err)
+			return nil, err
+		}
+		n := len(bars)
+		for stream.Next() {
+			trade := stream.Struct()
+			if filter != nil && !filter(trade) {
+				continue
+			}
+			if bar, ok := builder.Add(toBarTrade(trade)); ok {
+				bars = append(bars, bar)
+			}
+		}
+		err = stream.Err()
+		stream.Close()
+		if err != nil {
+			gLogger.Error("Stream Lines To Structs", "file", file, "error", err)
+			return nil, err
+		}
+		gLogger.Info("Built Bars", "file", file, "len", len(bars)-n)
+	}
+	return bars, nil
+}
+
+// OneDirAggTradesToBars builds bars from the agg trades csv files of dir, see OneDirTradesToBars.
+func OneDirAggTradesToBars(dir string, startTime time.Time, builder BarBuilder) ([]Bar, error) {
+	return OneDirTradesToBars(dir, startTime, AggTradeLineToStruct, AggTradesReadFilter, AggTradeToBarTrade, builder)
+}
+
+// OneDirSpotTradesToBars builds bars from the spot trades csv files of dir, see OneDirTradesToBars.
+func OneDirSpotTradesToBars(dir string, startTime time.Time, builder BarBuilder) ([]Bar, error) {
+	return OneDirTradesToBars(dir, startTime, SpotTradeLineToStruct, nil, SpotTradeToBarTrade, builder)
+}
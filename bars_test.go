@@ -0,0 +1,156 @@
+package bncvision
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+func TestThresholdBarBuilders(t *testing.T) {
+	trades := []BarTrade{
+		{Time: 1, Price: 10, Qty: 1, TradesNumber: 1},
+		{Time: 2, Price: 12, Qty: 2, TradesNumber: 2, IsBuyerMaker: true},
+		{Time: 3, Price: 8, Qty: 1, TradesNumber: 1},
+		{Time: 4, Price: 10, Qty: 4, TradesNumber: 1},
+		{Time: 5, Price: 11, Qty: 1, TradesNumber: 1},
+	}
+	identity := func(t BarTrade) BarTrade { return t }
+
+	tick, err := NewTickBarBuilder(2)
+	if err != nil {
+		t.Fatalf("NewTickBarBuilder failed: %v", err)
+	}
+	bars := TradesToBars(trades, identity, tick)
+	if len(bars) != 2 {
+		t.Fatalf("Expected 2 tick bars, got %d", len(bars))
+	}
+	first := bars[0]
+	if first.OpenTime != 1 || first.CloseTime != 2 || first.OpenPrice != 10 || first.HighPrice != 12 || first.ClosePrice != 12 ||
+		first.Volume != 3 || first.QuoteVolume != 34 || first.TakerBuyVolume != 1 || first.TicksNumber != 2 || first.TradesNumber != 3 {
+		t.Errorf("Unexpected first tick bar %+v", first)
+	}
+	if pending, ok := tick.Pending(); !ok || pending.TicksNumber != 1 || pending.OpenTime != 5 {
+		t.Errorf("Unexpected pending bar %+v", pending)
+	}
+
+	volume, _ := NewVolumeBarBuilder(4)
+	bars = TradesToBars(trades, identity, volume)
+	if len(bars) != 2 || bars[0].CloseTime != 3 || bars[1].OpenTime != 4 || bars[1].CloseTime != 4 {
+		t.Errorf("Unexpected volume bars %+v", bars)
+	}
+
+	dollar, _ := NewDollarBarBuilder(40)
+	bars = TradesToBars(trades, identity, dollar)
+	if len(bars) != 2 || bars[0].CloseTime != 3 || bars[1].QuoteVolume != 40 {
+		t.Errorf("Unexpected dollar bars %+v", bars)
+	}
+
+	if _, err := NewVolumeBarBuilder(0); err == nil {
+		t.Errorf("Expected error for zero threshold")
+	}
+}
+
+func TestAdaptiveBarBuilders(t *testing.T) {
+	cfg := AdaptiveBarConfig{InitialTicks: 4, Alpha: 0.5, MinTicks: 2}
+	// 3 buys and 1 sell per 4 ticks
+	var trades []BarTrade
+	for i := int64(0); i < 40; i++ {
+		trades = append(trades, BarTrade{Time: i, Price: 1, Qty: 1, TradesNumber: 1, IsBuyerMaker: i%4 == 3})
+	}
+	identity := func(t BarTrade) BarTrade { return t }
+
+	for name, newBuilder := range map[string]func(AdaptiveBarConfig) (BarBuilder, error){
+		"tickImbalance":   NewTickImbalanceBarBuilder,
+		"volumeImbalance": NewVolumeImbalanceBarBuilder,
+		"tickRun":         NewTickRunBarBuilder,
+		"volumeRun":       NewVolumeRunBarBuilder,
+	} {
+		builder, err := newBuilder(cfg)
+		if err != nil {
+			t.Fatalf("%s: failed to create builder: %v", name, err)
+		}
+		bars := TradesToBars(trades, identity, builder)
+		if len(bars) == 0 {
+			t.Fatalf("%s: expected bars", name)
+		}
+		// the first bar is the warm-up bar of InitialTicks ticks
+		if bars[0].TicksNumber != 4 {
+			t.Errorf("%s: expected 4 ticks in the first bar, got %d", name, bars[0].TicksNumber)
+		}
+		var ticks int64
+		for _, bar := range bars {
+			if bar.TicksNumber < cfg.MinTicks {
+				t.Errorf("%s: bar of %d ticks is shorter than MinTicks", name, bar.TicksNumber)
+			}
+			ticks += bar.TicksNumber
+		}
+		if pending, ok := builder.Pending(); ok {
+			ticks += pending.TicksNumber
+		}
+		if ticks != int64(len(trades)) {
+			t.Errorf("%s: expected %d ticks in all bars, got %d", name, len(trades), ticks)
+		}
+	}
+
+	if _, err := NewTickImbalanceBarBuilder(AdaptiveBarConfig{InitialTicks: 4}); err == nil {
+		t.Errorf("Expected error for zero alpha")
+	}
+}
+
+func TestImbalanceBarBuilderBalancedWarmUp(t *testing.T) {
+	// alternating buys and sells in the warm-up, then buys
+	var trades []BarTrade
+	for i := int64(0); i < 8; i++ {
+		trades = append(trades, BarTrade{Time: i, Price: 1, Qty: 1, TradesNumber: 1, IsBuyerMaker: i < 4 && i%2 == 1})
+	}
+	identity := func(t BarTrade) BarTrade { return t }
+
+	builder, err := NewTickImbalanceBarBuilder(AdaptiveBarConfig{InitialTicks: 4, Alpha: 0.5})
+	if err != nil {
+		t.Fatalf("NewTickImbalanceBarBuilder failed: %v", err)
+	}
+	bars := TradesToBars(trades, identity, builder)
+	if len(bars) == 0 {
+		t.Fatalf("Expected bars")
+	}
+	// the balanced warm-up bar is not closed without imbalance
+	if bars[0].TicksNumber != 5 {
+		t.Errorf("Expected 5 ticks in the first bar, got %d", bars[0].TicksNumber)
+	}
+	if threshold := builder.(*adaptiveBarBuilder).threshold(); threshold <= 0 {
+		t.Errorf("Expected a positive threshold, got %v", threshold)
+	}
+}
+
+func TestOneDirAggTradesToBarsAcrossDays(t *testing.T) {
+	dir := t.TempDir()
+	midnight := int64(1609545600000) // 2021-01-02
+	row := func(id, time int64) string {
+		aggTrade := bnc.AggTrades{Id: id, Price: 10, Qty: 1, FirstTradeId: id, LastTradeId: id, Time: time}
+		return aggTrade.CSVRow()
+	}
+	writeTestRows(t, filepath.Join(dir, "BTCUSDT-aggTrades-2021-01-01.csv"), []string{row(1, midnight-3), row(2, midnight-2), row(3, midnight-1)})
+	writeTestRows(t, filepath.Join(dir, "BTCUSDT-aggTrades-2021-01-02.csv"), []string{row(4, midnight), row(5, midnight+1)})
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("skipped"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	builder, _ := NewVolumeBarBuilder(2)
+	bars, err := OneDirAggTradesToBars(dir, time.Time{}, builder)
+	if err != nil {
+		t.Fatalf("OneDirAggTradesToBars failed: %v", err)
+	}
+	if len(bars) != 2 {
+		t.Fatalf("Expected 2 bars, got %+v", bars)
+	}
+	// the second bar crosses midnight
+	if bars[1].OpenTime != midnight-1 || bars[1].CloseTime != midnight {
+		t.Errorf("Unexpected bar across midnight %+v", bars[1])
+	}
+	if pending, ok := builder.Pending(); !ok || pending.OpenTime != midnight+1 {
+		t.Errorf("Unexpected pending bar %+v", pending)
+	}
+}
@@ -0,0 +1,194 @@
+package bncvision
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	ErrInvalidChecksumFile = errors.New("invalid checksum file")
+	ErrChecksumMismatch    = errors.New("checksum mismatch")
+)
+
+// ParseChecksumFile parses a Binance Vision .CHECKSUM file,
+// whose content is "<sha256>  <file name>\n".
+//
+// Returns:
+//   - The lower case hex sha256.
+//   - The file name in the checksum file.
+//   - An error if the content is invalid, nil otherwise.
+func ParseChecksumFile(data []byte) (sum, fileName string, err error) {
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return "", "", ErrInvalidChecksumFile
+	}
+	sum = strings.ToLower(fields[0])
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+		return "", "", ErrInvalidChecksumFile
+	}
+	return sum, fields[1], nil
+}
+
+// FileSha256 returns the lower case hex sha256 of a file.
+func FileSha256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyFileChecksum checks a file against the content of its .CHECKSUM file.
+func VerifyFileChecksum(filePath string, checksumData []byte) error {
+	return verifyChecksumData(filePath, filepath.Base(filePath), checksumData)
+}
+
+// verifyChecksumData checks a file against checksumData,
+// and the file name in checksumData must be fileName, which may differ from the name of a temporary file.
+func verifyChecksumData(filePath, fileName string, checksumData []byte) error {
+	sum, checksumFileName, err := ParseChecksumFile(checksumData)
+	if err != nil {
+		return err
+	}
+	if checksumFileName != fileName {
+		return fmt.Errorf("%w: checksum file is for %s", ErrInvalidChecksumFile, checksumFileName)
+	}
+	fileSum, err := FileSha256(filePath)
+	if err != nil {
+		return err
+	}
+	if fileSum != sum {
+		return fmt.Errorf("%w: %s expected %s, got %s", ErrChecksumMismatch, filePath, sum, fileSum)
+	}
+	return nil
+}
+
+// DownloadAndVerifyChecksum downloads the .CHECKSUM file of url,
+// verifies the local file with it, and saves it next to the local file if they match.
+//
+// Parameters:
+//   - filePath: The local path of the downloaded file.
+//   - url: The url of the downloaded file, url + ".CHECKSUM" is the url of the checksum file.
+//
+// Returns:
+//   - An error if the checksum file can not be downloaded or the local file does not match it, nil otherwise.
+func (c *Client) DownloadAndVerifyChecksum(filePath, url string) error {
+	data, _, err := c.DownloadWithRetry(url+CHECKSUM_FILE_SUFFIX, 1)
+	if err != nil {
+		return fmt.Errorf("failed to download checksum file: %w", err)
+	}
+	err = VerifyFileChecksum(filePath, data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath+CHECKSUM_FILE_SUFFIX, data, 0644)
+}
+
+// ArchiveVerifyResult is the result of VerifyLocalArchive.
+type ArchiveVerifyResult struct {
+	// Verified is the number of zip files matching their checksums.
+	Verified int
+	// Corrupt are the zip files not matching their checksums, or whose checksum files are invalid.
+	Corrupt []string
+	// MissingChecksum are the zip files without .CHECKSUM files.
+	MissingChecksum []string
+}
+
+// OK returns true if all zip files match their checksums.
+func (r ArchiveVerifyResult) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.MissingChecksum) == 0
+}
+
+// VerifyLocalArchive re-hashes all zip files under dir, such as $HOME/data.binance.vision,
+// and reports the files which are corrupt or missing their .CHECKSUM files.
+//
+// Parameters:
+//   - dir: The root directory to be walked.
+//   - maxCpus: The max number of files hashed at the same time.
+//
+// Returns:
+//   - The verify result, files are sorted by path.
+//   - An error if dir can not be walked or any file can not be read, nil otherwise.
+func VerifyLocalArchive(dir string, maxCpus int) (ArchiveVerifyResult, error) {
+	result := ArchiveVerifyResult{}
+
+	if maxCpus <= 0 {
+		maxCpus = 1
+	}
+
+	var zipFiles []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".zip") {
+			zipFiles = append(zipFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	wg := errgroup.Group{}
+	wg.SetLimit(maxCpus)
+	mu := sync.Mutex{}
+
+	for _, zipFile := range zipFiles {
+		zipFile := zipFile
+		wg.Go(func() error {
+			checksumData, err := os.ReadFile(zipFile + CHECKSUM_FILE_SUFFIX)
+			if os.IsNotExist(err) {
+				slog.Warn("Missing Checksum", "file", zipFile)
+				mu.Lock()
+				result.MissingChecksum = append(result.MissingChecksum, zipFile)
+				mu.Unlock()
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = VerifyFileChecksum(zipFile, checksumData)
+			if errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrInvalidChecksumFile) {
+				slog.Warn("Corrupt Archive", "file", zipFile, "error", err)
+				mu.Lock()
+				result.Corrupt = append(result.Corrupt, zipFile)
+				mu.Unlock()
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			result.Verified++
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return result, err
+	}
+
+	sort.Strings(result.Corrupt)
+	sort.Strings(result.MissingChecksum)
+
+	return result, nil
+}
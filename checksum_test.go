@@ -0,0 +1,110 @@
+package bncvision
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseChecksumFile(t *testing.T) {
+	sum := strings.Repeat("aB", 32)
+	gotSum, fileName, err := ParseChecksumFile([]byte(sum + "  BTCUSDT-aggTrades-2024-01-01.zip\n"))
+	if err != nil {
+		t.Fatalf("ParseChecksumFile failed: %v", err)
+	}
+	if gotSum != strings.ToLower(sum) || fileName != "BTCUSDT-aggTrades-2024-01-01.zip" {
+		t.Errorf("Unexpected result %s %s", gotSum, fileName)
+	}
+
+	for _, data := range []string{"", "abc  a.zip", sum, sum + "  a.zip  b.zip", strings.Repeat("zz", 32) + "  a.zip"} {
+		if _, _, err := ParseChecksumFile([]byte(data)); err == nil {
+			t.Errorf("Expected error for %q", data)
+		}
+	}
+}
+
+func TestClientDownloadVerifyChecksum(t *testing.T) {
+	root, prefix := newTestDataVisionRoot(t, 2)
+	// a wrong checksum file on the server takes precedence over the generated one
+	badZip := prefix + "/BTCUSDT-aggTrades-2024-01-02.zip"
+	badChecksum := strings.Repeat("0", 64) + "  BTCUSDT-aggTrades-2024-01-02.zip\n"
+	if err := os.WriteFile(filepath.Join(root, badZip+CHECKSUM_FILE_SUFFIX), []byte(badChecksum), 0644); err != nil {
+		t.Fatalf("Failed to write checksum file: %v", err)
+	}
+	server := NewFakeDataVisionServer(root, 0)
+	defer server.Close()
+	client := server.Client()
+
+	localDir := t.TempDir()
+
+	goodZip := prefix + "/BTCUSDT-aggTrades-2024-01-01.zip"
+	goodPath := filepath.Join(localDir, goodZip)
+	if err := client.DownloadSaveZipWithRetryAndValidate(goodPath, client.FileURL(goodZip), 2); err != nil {
+		t.Fatalf("DownloadSaveZipWithRetryAndValidate failed: %v", err)
+	}
+	if _, err := os.Stat(goodPath + CHECKSUM_FILE_SUFFIX); err != nil {
+		t.Errorf("Checksum file should be saved next to the zip file: %v", err)
+	}
+
+	badPath := filepath.Join(localDir, badZip)
+	if err := client.DownloadSaveZipWithRetryAndValidate(badPath, client.FileURL(badZip), 2); err == nil {
+		t.Errorf("Expected checksum mismatch error")
+	}
+	if exists, _ := FileExists(badPath); exists {
+		t.Errorf("Mismatched zip file should be removed")
+	}
+}
+
+func TestVerifyLocalArchive(t *testing.T) {
+	root, prefix := newTestDataVisionRoot(t, 3)
+	server := NewFakeDataVisionServer(root, 0)
+	defer server.Close()
+	client := server.Client()
+
+	_, _, contents, err := client.QueryDataVisionXML(prefix, "")
+	if err != nil {
+		t.Fatalf("QueryDataVisionXML failed: %v", err)
+	}
+	layout := NewLayout(t.TempDir())
+	localDir := layout.Raw
+	if _, err := client.DownloadWithXMLContents(contents, layout, 2); err != nil {
+		t.Fatalf("DownloadWithXMLContents failed: %v", err)
+	}
+
+	result, err := VerifyLocalArchive(localDir, 2)
+	if err != nil {
+		t.Fatalf("VerifyLocalArchive failed: %v", err)
+	}
+	if !result.OK() || result.Verified != 3 {
+		t.Errorf("Expected 3 verified files, got %+v", result)
+	}
+
+	corrupt := filepath.Join(localDir, prefix, "BTCUSDT-aggTrades-2024-01-01.zip")
+	data, err := os.ReadFile(corrupt)
+	if err != nil {
+		t.Fatalf("Failed to read zip file: %v", err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(corrupt, data, 0644); err != nil {
+		t.Fatalf("Failed to write zip file: %v", err)
+	}
+	missing := filepath.Join(localDir, prefix, "BTCUSDT-aggTrades-2024-01-03.zip")
+	if err := os.Remove(missing + CHECKSUM_FILE_SUFFIX); err != nil {
+		t.Fatalf("Failed to remove checksum file: %v", err)
+	}
+
+	result, err = VerifyLocalArchive(localDir, 2)
+	if err != nil {
+		t.Fatalf("VerifyLocalArchive failed: %v", err)
+	}
+	if result.OK() || result.Verified != 1 {
+		t.Errorf("Expected 1 verified file, got %+v", result)
+	}
+	if len(result.Corrupt) != 1 || result.Corrupt[0] != corrupt {
+		t.Errorf("Expected corrupt %s, got %v", corrupt, result.Corrupt)
+	}
+	if len(result.MissingChecksum) != 1 || result.MissingChecksum[0] != missing {
+		t.Errorf("Expected missing checksum %s, got %v", missing, result.MissingChecksum)
+	}
+}
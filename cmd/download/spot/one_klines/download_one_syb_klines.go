@@ -7,11 +7,22 @@ import (
 )
 
 func main() {
-	mid := "spot"
-	timeframe := "1s"
+	layout, err := bncvision.LayoutFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	market := bncvision.MarketSpot
+	interval := bncvision.Kline1s
 	symbols := []string{"BTCUSDT"}
 	for _, symbol := range symbols {
-		undownloadContents, err := bncvision.DownloadAllUnderPath("data/"+mid+"/daily/klines/"+symbol+"/"+timeframe, 20)
+		spec := bncvision.DatasetSpec{
+			Market:    market,
+			Frequency: bncvision.FrequencyDaily,
+			DataType:  bncvision.DataTypeKlines,
+			Symbol:    symbol,
+			Interval:  interval,
+		}
+		undownloadContents, err := bncvision.DownloadDataset(spec, layout, 20)
 		if err != nil {
 			panic(err)
 		}
@@ -7,12 +7,22 @@ import (
 )
 
 func main() {
+	layout, err := bncvision.LayoutFromEnv()
+	if err != nil {
+		panic(err)
+	}
 	// symbols := []string{"ETHBTC", "PEPEUSDT", "WLDUSDT", "BNBUSDT"}
-	// mid := "futures/um"
-	mid := "spot"
+	// market := bncvision.MarketFuturesUM
+	market := bncvision.MarketSpot
 	symbols := []string{"BTCUSDT"}
 	for _, symbol := range symbols {
-		undownloadContents, err := bncvision.DownloadAllUnderPath("data/"+mid+"/daily/aggTrades/"+symbol, 20)
+		spec := bncvision.DatasetSpec{
+			Market:    market,
+			Frequency: bncvision.FrequencyDaily,
+			DataType:  bncvision.DataTypeAggTrades,
+			Symbol:    symbol,
+		}
+		undownloadContents, err := bncvision.DownloadDataset(spec, layout, 20)
 		if err != nil {
 			panic(err)
 		}
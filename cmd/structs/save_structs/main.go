@@ -1,17 +1,40 @@
 package main
 
-import "github.com/dwdwow/bncvision"
+import (
+	"github.com/dwdwow/bncvision"
+)
+
+var layout = bncvision.NewLayout("/home/ubuntu")
 
 func main() {
-	readTradesCSVAndSaveStructs()
+	readTradesCSVAndSaveColumnar()
+}
+
+func btcusdtSpotTrades() bncvision.DatasetSpec {
+	return bncvision.DatasetSpec{
+		Market:    bncvision.MarketSpot,
+		Frequency: bncvision.FrequencyDaily,
+		DataType:  bncvision.DataTypeTrades,
+		Symbol:    "BTCUSDT",
+	}
 }
 
 func readTradesCSVAndSaveStructs() {
-	symbol := "BTCUSDT"
-	csvFileDir := "/home/ubuntu/unzip.binance.vision/data/spot/daily/trades/" + symbol
-	jsonFileDir := "/home/ubuntu/struct.binance.vision/data/spot/daily/trades/" + symbol
+	spec := btcusdtSpotTrades()
+	csvFileDir := spec.UnzipDir(layout)
+	jsonFileDir := spec.StructDir(layout)
 	err := bncvision.ReadAllCSVToStructsAndSaveToJSON(csvFileDir, jsonFileDir, bncvision.SpotTradeRawToStruct)
 	if err != nil {
 		panic(err)
 	}
 }
+
+func readTradesCSVAndSaveColumnar() {
+	spec := btcusdtSpotTrades()
+	csvFileDir := spec.UnzipDir(layout)
+	columnarFileDir := spec.StructDir(layout)
+	err := bncvision.ReadAllCSVToStructsAndSaveToColumnar(csvFileDir, columnarFileDir, spec.Symbol, bncvision.SpotTradeLineToStruct)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -1,7 +1,14 @@
 package main
 
-import "github.com/dwdwow/bncvision/cmd/te/tester"
+import (
+	"flag"
+
+	"github.com/dwdwow/bncvision"
+	"github.com/dwdwow/bncvision/cmd/te/tester"
+)
 
 func main() {
-	tester.TidyOneDirAggTrades()
+	market := flag.String("market", string(bncvision.MarketSpot), "spot, futures/um or futures/cm")
+	flag.Parse()
+	tester.TidyOneDirAggTrades(bncvision.Market(*market))
 }
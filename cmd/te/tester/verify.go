@@ -5,11 +5,25 @@ import (
 	"time"
 
 	"github.com/dwdwow/bncvision"
-	"github.com/dwdwow/cex/bnc"
 )
 
-func VerifyOneDirAggTradesContinuity() {
-	dir := "/home/ubuntu/tidy.binance.vision/data/spot/daily/aggTrades/BTCUSDT"
+// AggTradesSpec returns the BTC agg trades spec of market.
+// Futures-cm agg trades are of the perpetual contract BTCUSD_PERP.
+func AggTradesSpec(market bncvision.Market) bncvision.DatasetSpec {
+	symbol := "BTCUSDT"
+	if market == bncvision.MarketFuturesCM {
+		symbol = "BTCUSD_PERP"
+	}
+	return bncvision.DatasetSpec{
+		Market:    market,
+		Frequency: bncvision.FrequencyDaily,
+		DataType:  bncvision.DataTypeAggTrades,
+		Symbol:    symbol,
+	}
+}
+
+func VerifyOneDirAggTradesContinuity(market bncvision.Market) {
+	dir := AggTradesSpec(market).TidyDir(bncvision.NewLayout("/home/ubuntu"))
 	maxCpus := 20
 	missingIds, err := bncvision.OneDirAggTradesMissings(dir, maxCpus, time.Date(2024, 10, 20, 0, 0, 0, 0, time.UTC))
 	if err != nil {
@@ -25,33 +39,20 @@ func VerifyOneDirAggTradesContinuity() {
 	}
 }
 
-func ScanOneDirAggTradesMissingsAndDownload() {
-	aggTradesDir := "/home/ubuntu/unzip.binance.vision/data/spot/daily/aggTrades/BTCUSDT"
-	saveDir := "/home/ubuntu/missing.binance.vision/data/spot/daily/aggTrades/BTCUSDT"
-	symbol := "BTCUSDT"
-	tradesType := bnc.AggTradesTypeSpot
+func ScanOneDirAggTradesMissingsAndDownload(market bncvision.Market) {
+	layout := bncvision.NewLayout("/home/ubuntu")
 	maxCpus := 20
 	startTime := time.Date(2024, 10, 20, 0, 0, 0, 0, time.UTC)
-	err := bncvision.ScanOneDirAggTradesMissingsAndDownload(aggTradesDir, saveDir, symbol, tradesType, maxCpus, startTime)
+	err := bncvision.ScanDatasetMissingsAndDownload(AggTradesSpec(market), layout, maxCpus, startTime)
 	if err != nil {
 		panic(err)
 	}
 }
 
-func TidyOneDirAggTrades() {
-	rawDir := "/home/ubuntu/unzip.binance.vision/data/spot/daily/aggTrades/BTCUSDT"
-	missingDir := "/home/ubuntu/missing.binance.vision/data/spot/daily/aggTrades/BTCUSDT"
-	tidyDir := "/home/ubuntu/tidy.binance.vision/data/spot/daily/aggTrades/BTCUSDT"
-	symbol := "BTCUSDT"
+func TidyOneDirAggTrades(market bncvision.Market) {
+	layout := bncvision.NewLayout("/home/ubuntu")
 	maxCpus := 20
-	err := bncvision.TidyOneDirAggTrades(bncvision.TidyOneDirAggTradesParams{
-		RawDir:              rawDir,
-		MissingDir:          missingDir,
-		TidyDir:             tidyDir,
-		Symbol:              symbol,
-		MaxCpus:             maxCpus,
-		CheckTidyFileExists: true,
-	})
+	err := bncvision.TidyDataset(AggTradesSpec(market), layout, maxCpus, true)
 	if err != nil {
 		panic(err)
 	}
@@ -11,17 +11,22 @@ func main() {
 }
 
 func unzip() {
-	var err error
-	// dataType := "futures/um"
-	dataType := "spot"
+	layout := bncvision.NewLayout("/home/ubuntu")
+	// market := bncvision.MarketFuturesUM
+	market := bncvision.MarketSpot
 	// symbols := []string{"BTCUSDT", "ETHUSDT", "ETHBTC", "PEPEUSDT", "WLDUSDT", "BNBUSDT"}
 	symbols := []string{"BTCUSDT"}
 	for _, symbol := range symbols {
-		// err := bncvision.UnzipAllAndSaveInDir("/home/ubuntu/data.binance.vision/data/spot/daily/trades/"+symbol, "/home/ubuntu/unzip.binance.vision/data/spot/daily/trades/"+symbol)
+		// err := bncvision.UnzipDataset(bncvision.DatasetSpec{Market: market, Frequency: bncvision.FrequencyDaily, DataType: bncvision.DataTypeTrades, Symbol: symbol}, layout)
 		// if err != nil {
 		// 	log.Fatal(err)
 		// }
-		err = bncvision.UnzipAllAndSaveInDir("/home/ubuntu/data.binance.vision/data/"+dataType+"/daily/aggTrades/"+symbol, "/home/ubuntu/unzip.binance.vision/data/"+dataType+"/daily/aggTrades/"+symbol)
+		err := bncvision.UnzipDataset(bncvision.DatasetSpec{
+			Market:    market,
+			Frequency: bncvision.FrequencyDaily,
+			DataType:  bncvision.DataTypeAggTrades,
+			Symbol:    symbol,
+		}, layout)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,652 @@
+package bncvision
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dwdwow/cex/bnc"
+	"golang.org/x/sync/errgroup"
+)
+
+// Columnar file layout, all integers are little endian:
+//
+//	magic     [4]byte "BNCV"
+//	version   uint16
+//	kind      uint8
+//	columns   uint8
+//	symbolLen uint16
+//	symbol    [symbolLen]byte
+//	startTime int64, the min time of rows
+//	endTime   int64, the max time of rows
+//	rows      uint64
+//	checksum  uint32, crc32 castagnoli of the whole file except the checksum itself
+//	columns times:
+//		encoding uint8
+//		decimals uint8
+//		size     uint64
+//	column data
+//
+// Ids and timestamps are delta encoded varints,
+// prices and quantities are scaled to integers by 10^decimals and delta encoded as varints too,
+// and bools are packed as bitmaps.
+// Decimals are chosen per file and column, at most 8, as the most decimals which keep every value exact,
+// so big values, such as volumes of big supply symbols over 9.2e10, are saved with fewer decimals.
+
+const (
+	COLUMNAR_FILE_EXT = ".bcol"
+
+	columnarMagic   = "BNCV"
+	columnarVersion = 2
+)
+
+type ColumnarKind uint8
+
+const (
+	ColumnarKindAggTrades ColumnarKind = iota + 1
+	ColumnarKindSpotTrades
+	ColumnarKindKlines
+)
+
+type columnEncoding uint8
+
+const (
+	columnEncodingDeltaInt columnEncoding = iota + 1
+	columnEncodingScaledFloat
+	columnEncodingBool
+)
+
+var (
+	ErrColumnarInvalidFile  = errors.New("invalid columnar file")
+	ErrColumnarChecksum     = errors.New("columnar file checksum mismatch")
+	ErrColumnarKindMismatch = errors.New("columnar file kind mismatch")
+	ErrColumnarPrecision    = errors.New("float can not be scaled to integer without losing precision")
+)
+
+var columnarCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ColumnarHeader is the header of a columnar file.
+type ColumnarHeader struct {
+	Version   uint16
+	Kind      ColumnarKind
+	Symbol    string
+	StartTime int64
+	EndTime   int64
+	Rows      int64
+	Checksum  uint32
+}
+
+// ColumnarStruct is the struct types which can be saved as columnar files.
+type ColumnarStruct interface {
+	bnc.AggTrades | bnc.SpotTrade | bnc.Kline
+}
+
+type columnarColumn[T any] struct {
+	encoding columnEncoding
+	decimals uint8
+	getInt   func(*T) int64
+	setInt   func(*T, int64)
+	getFloat func(*T) float64
+	setFloat func(*T, float64)
+	getBool  func(*T) bool
+	setBool  func(*T, bool)
+}
+
+func deltaIntColumn[T any](get func(*T) int64, set func(*T, int64)) columnarColumn[T] {
+	return columnarColumn[T]{encoding: columnEncodingDeltaInt, getInt: get, setInt: set}
+}
+
+func scaledFloatColumn[T any](decimals uint8, get func(*T) float64, set func(*T, float64)) columnarColumn[T] {
+	return columnarColumn[T]{encoding: columnEncodingScaledFloat, decimals: decimals, getFloat: get, setFloat: set}
+}
+
+func boolColumn[T any](get func(*T) bool, set func(*T, bool)) columnarColumn[T] {
+	return columnarColumn[T]{encoding: columnEncodingBool, getBool: get, setBool: set}
+}
+
+type columnarCodec[T any] struct {
+	kind    ColumnarKind
+	time    func(*T) int64
+	columns []columnarColumn[T]
+}
+
+var aggTradesColumnarCodec = columnarCodec[bnc.AggTrades]{
+	kind: ColumnarKindAggTrades,
+	time: func(t *bnc.AggTrades) int64 { return t.Time },
+	columns: []columnarColumn[bnc.AggTrades]{
+		deltaIntColumn(func(t *bnc.AggTrades) int64 { return t.Id }, func(t *bnc.AggTrades, v int64) { t.Id = v }),
+		scaledFloatColumn(8, func(t *bnc.AggTrades) float64 { return t.Price }, func(t *bnc.AggTrades, v float64) { t.Price = v }),
+		scaledFloatColumn(8, func(t *bnc.AggTrades) float64 { return t.Qty }, func(t *bnc.AggTrades, v float64) { t.Qty = v }),
+		deltaIntColumn(func(t *bnc.AggTrades) int64 { return t.FirstTradeId }, func(t *bnc.AggTrades, v int64) { t.FirstTradeId = v }),
+		deltaIntColumn(func(t *bnc.AggTrades) int64 { return t.LastTradeId }, func(t *bnc.AggTrades, v int64) { t.LastTradeId = v }),
+		deltaIntColumn(func(t *bnc.AggTrades) int64 { return t.Time }, func(t *bnc.AggTrades, v int64) { t.Time = v }),
+		boolColumn(func(t *bnc.AggTrades) bool { return t.IsBuyerMaker }, func(t *bnc.AggTrades, v bool) { t.IsBuyerMaker = v }),
+		boolColumn(func(t *bnc.AggTrades) bool { return t.IsBestMatch }, func(t *bnc.AggTrades, v bool) { t.IsBestMatch = v }),
+	},
+}
+
+var spotTradesColumnarCodec = columnarCodec[bnc.SpotTrade]{
+	kind: ColumnarKindSpotTrades,
+	time: func(t *bnc.SpotTrade) int64 { return t.Time },
+	columns: []columnarColumn[bnc.SpotTrade]{
+		deltaIntColumn(func(t *bnc.SpotTrade) int64 { return t.Id }, func(t *bnc.SpotTrade, v int64) { t.Id = v }),
+		scaledFloatColumn(8, func(t *bnc.SpotTrade) float64 { return t.Price }, func(t *bnc.SpotTrade, v float64) { t.Price = v }),
+		scaledFloatColumn(8, func(t *bnc.SpotTrade) float64 { return t.Qty }, func(t *bnc.SpotTrade, v float64) { t.Qty = v }),
+		scaledFloatColumn(8, func(t *bnc.SpotTrade) float64 { return t.QuoteQty }, func(t *bnc.SpotTrade, v float64) { t.QuoteQty = v }),
+		deltaIntColumn(func(t *bnc.SpotTrade) int64 { return t.Time }, func(t *bnc.SpotTrade, v int64) { t.Time = v }),
+		boolColumn(func(t *bnc.SpotTrade) bool { return t.IsBuyerMaker }, func(t *bnc.SpotTrade, v bool) { t.IsBuyerMaker = v }),
+		boolColumn(func(t *bnc.SpotTrade) bool { return t.IsBestMatch }, func(t *bnc.SpotTrade, v bool) { t.IsBestMatch = v }),
+	},
+}
+
+var klinesColumnarCodec = columnarCodec[bnc.Kline]{
+	kind: ColumnarKindKlines,
+	time: func(k *bnc.Kline) int64 { return k.OpenTime },
+	columns: []columnarColumn[bnc.Kline]{
+		deltaIntColumn(func(k *bnc.Kline) int64 { return k.OpenTime }, func(k *bnc.Kline, v int64) { k.OpenTime = v }),
+		scaledFloatColumn(8, func(k *bnc.Kline) float64 { return k.OpenPrice }, func(k *bnc.Kline, v float64) { k.OpenPrice = v }),
+		scaledFloatColumn(8, func(k *bnc.Kline) float64 { return k.HighPrice }, func(k *bnc.Kline, v float64) { k.HighPrice = v }),
+		scaledFloatColumn(8, func(k *bnc.Kline) float64 { return k.LowPrice }, func(k *bnc.Kline, v float64) { k.LowPrice = v }),
+		scaledFloatColumn(8, func(k *bnc.Kline) float64 { return k.ClosePrice }, func(k *bnc.Kline, v float64) { k.ClosePrice = v }),
+		scaledFloatColumn(8, func(k *bnc.Kline) float64 { return k.Volume }, func(k *bnc.Kline, v float64) { k.Volume = v }),
+		deltaIntColumn(func(k *bnc.Kline) int64 { return k.CloseTime }, func(k *bnc.Kline, v int64) { k.CloseTime = v }),
+		scaledFloatColumn(8, func(k *bnc.Kline) float64 { return k.QuoteAssetVolume }, func(k *bnc.Kline, v float64) { k.QuoteAssetVolume = v }),
+		deltaIntColumn(func(k *bnc.Kline) int64 { return k.TradesNumber }, func(k *bnc.Kline, v int64) { k.TradesNumber = v }),
+		scaledFloatColumn(8, func(k *bnc.Kline) float64 { return k.TakerBuyBaseAssetVolume }, func(k *bnc.Kline, v float64) { k.TakerBuyBaseAssetVolume = v }),
+		scaledFloatColumn(8, func(k *bnc.Kline) float64 { return k.TakerBuyQuoteAssetVolume }, func(k *bnc.Kline, v float64) { k.TakerBuyQuoteAssetVolume = v }),
+	},
+}
+
+func getColumnarCodec[T ColumnarStruct]() columnarCodec[T] {
+	var codec any
+	var t T
+	switch any(t).(type) {
+	case bnc.AggTrades:
+		codec = aggTradesColumnarCodec
+	case bnc.SpotTrade:
+		codec = spotTradesColumnarCodec
+	case bnc.Kline:
+		codec = klinesColumnarCodec
+	}
+	return codec.(columnarCodec[T])
+}
+
+// EncodeColumnar encodes structs to the columnar format.
+//
+// Parameters:
+//   - symbol: The symbol saved in the header.
+//   - data: A slice of structs to be encoded.
+//
+// Returns:
+//   - The encoded bytes.
+//   - An error if any float can not be scaled to integer without losing precision by any decimals, nil otherwise.
+func EncodeColumnar[T ColumnarStruct](symbol string, data []T) ([]byte, error) {
+	codec := getColumnarCodec[T]()
+
+	columns := make([][]byte, len(codec.columns))
+	decimals := make([]uint8, len(codec.columns))
+	for i, column := range codec.columns {
+		var err error
+		columns[i], decimals[i], err = encodeColumn(column, data)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %w", i, err)
+		}
+	}
+
+	var startTime, endTime int64
+	for i := range data {
+		t := codec.time(&data[i])
+		if i == 0 || t < startTime {
+			startTime = t
+		}
+		if i == 0 || t > endTime {
+			endTime = t
+		}
+	}
+
+	buf := make([]byte, 0, 64+len(symbol))
+	buf = append(buf, columnarMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, columnarVersion)
+	buf = append(buf, byte(codec.kind), byte(len(codec.columns)))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(symbol)))
+	buf = append(buf, symbol...)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(startTime))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(endTime))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(data)))
+	checksumOffset := len(buf)
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+	for i, column := range codec.columns {
+		buf = append(buf, byte(column.encoding), decimals[i])
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(len(columns[i])))
+	}
+	for _, column := range columns {
+		buf = append(buf, column...)
+	}
+	binary.LittleEndian.PutUint32(buf[checksumOffset:], columnarChecksum(buf, checksumOffset))
+
+	return buf, nil
+}
+
+// columnarChecksum returns the checksum of data, skipping the checksum at offset,
+// so the header, such as rows and symbol, is covered as well as column data.
+func columnarChecksum(data []byte, offset int) uint32 {
+	crc := crc32.Update(0, columnarCrcTable, data[:offset])
+	return crc32.Update(crc, columnarCrcTable, data[offset+4:])
+}
+
+// columnarChecksumOffset returns the offset of the checksum of a file of header,
+// after the magic, version, kind, column number, symbol, start time, end time and rows.
+func columnarChecksumOffset(header ColumnarHeader) int {
+	return 10 + len(header.Symbol) + 24
+}
+
+// encodeColumn returns the encoded column and its decimals.
+// Scaled floats take the most decimals, up to the decimals of column, which keep every value exact.
+func encodeColumn[T any](column columnarColumn[T], data []T) ([]byte, uint8, error) {
+	if column.encoding == columnEncodingScaledFloat {
+		var err error
+		for decimals := int(column.decimals); decimals >= 0; decimals-- {
+			var buf []byte
+			if buf, err = encodeScaledFloats(column, data, uint8(decimals)); err == nil {
+				return buf, uint8(decimals), nil
+			}
+		}
+		return nil, 0, err
+	}
+
+	var buf []byte
+	switch column.encoding {
+	case columnEncodingDeltaInt:
+		var prev int64
+		for i := range data {
+			v := column.getInt(&data[i])
+			buf = binary.AppendVarint(buf, v-prev)
+			prev = v
+		}
+	case columnEncodingBool:
+		buf = make([]byte, (len(data)+7)/8)
+		for i := range data {
+			if column.getBool(&data[i]) {
+				buf[i/8] |= 1 << (i % 8)
+			}
+		}
+	}
+	return buf, 0, nil
+}
+
+func encodeScaledFloats[T any](column columnarColumn[T], data []T, decimals uint8) ([]byte, error) {
+	var buf []byte
+	var prev int64
+	for i := range data {
+		f := column.getFloat(&data[i])
+		v, err := floatToScaled(f, decimals)
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendVarint(buf, v-prev)
+		prev = v
+	}
+	return buf, nil
+}
+
+// DecodeColumnar decodes structs from columnar bytes.
+//
+// Parameters:
+//   - data: The columnar bytes.
+//
+// Returns:
+//   - The header of the columnar data.
+//   - A slice of decoded structs.
+//   - An error if the data is invalid, the checksum mismatches or the kind is not T, nil otherwise.
+func DecodeColumnar[T ColumnarStruct](data []byte) (ColumnarHeader, []T, error) {
+	codec := getColumnarCodec[T]()
+
+	header, columnInfos, body, err := decodeColumnarHeader(data)
+	if err != nil {
+		return header, nil, err
+	}
+	if columnarChecksum(data, columnarChecksumOffset(header)) != header.Checksum {
+		return header, nil, ErrColumnarChecksum
+	}
+	if header.Kind != codec.kind {
+		return header, nil, fmt.Errorf("%w: expected %d, got %d", ErrColumnarKindMismatch, codec.kind, header.Kind)
+	}
+	if len(columnInfos) != len(codec.columns) {
+		return header, nil, fmt.Errorf("%w: expected %d columns, got %d", ErrColumnarInvalidFile, len(codec.columns), len(columnInfos))
+	}
+
+	result := make([]T, header.Rows)
+	for i, column := range codec.columns {
+		info := columnInfos[i]
+		if info.encoding != column.encoding {
+			return header, nil, fmt.Errorf("%w: column %d encoding mismatch", ErrColumnarInvalidFile, i)
+		}
+		column.decimals = info.decimals
+		if err := decodeColumn(column, body[:info.size], result); err != nil {
+			return header, nil, fmt.Errorf("column %d: %w", i, err)
+		}
+		body = body[info.size:]
+	}
+
+	return header, result, nil
+}
+
+// decodeColumn decodes buf to the column of result, buf must be consumed exactly by the rows of result.
+func decodeColumn[T any](column columnarColumn[T], buf []byte, result []T) error {
+	switch column.encoding {
+	case columnEncodingDeltaInt:
+		var v int64
+		for i := range result {
+			delta, n := binary.Varint(buf)
+			if n <= 0 {
+				return ErrColumnarInvalidFile
+			}
+			buf = buf[n:]
+			v += delta
+			column.setInt(&result[i], v)
+		}
+	case columnEncodingScaledFloat:
+		var v int64
+		for i := range result {
+			delta, n := binary.Varint(buf)
+			if n <= 0 {
+				return ErrColumnarInvalidFile
+			}
+			buf = buf[n:]
+			v += delta
+			column.setFloat(&result[i], scaledToFloat(v, column.decimals))
+		}
+	case columnEncodingBool:
+		if len(buf) < (len(result)+7)/8 {
+			return ErrColumnarInvalidFile
+		}
+		for i := range result {
+			column.setBool(&result[i], buf[i/8]&(1<<(i%8)) != 0)
+		}
+		buf = buf[(len(result)+7)/8:]
+	default:
+		return ErrColumnarInvalidFile
+	}
+	if len(buf) > 0 {
+		return fmt.Errorf("%w: %d bytes left after %d rows", ErrColumnarInvalidFile, len(buf), len(result))
+	}
+	return nil
+}
+
+// maxExactScaled is the max integer which can be converted to float64 exactly.
+const maxExactScaled = 1 << 53
+
+// floatToScaled scales f to an integer by 10^decimals,
+// and makes sure scaledToFloat returns exactly f.
+func floatToScaled(f float64, decimals uint8) (int64, error) {
+	scale := math.Pow10(int(decimals))
+	v := int64(math.Round(f * scale))
+	if scaledToFloat(v, decimals) == f {
+		return v, nil
+	}
+	// big numbers, f*scale may be not exact
+	s := strings.Replace(strconv.FormatFloat(f, 'f', int(decimals), 64), ".", "", 1)
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err == nil && scaledToFloat(v, decimals) == f {
+		return v, nil
+	}
+	return 0, fmt.Errorf("%w: %v", ErrColumnarPrecision, f)
+}
+
+func scaledToFloat(v int64, decimals uint8) float64 {
+	if v > -maxExactScaled && v < maxExactScaled {
+		// both are exact, so the division is correctly rounded
+		return float64(v) / math.Pow10(int(decimals))
+	}
+	s := strconv.FormatInt(v, 10)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	if len(s) <= int(decimals) {
+		s = strings.Repeat("0", int(decimals)-len(s)+1) + s
+	}
+	s = s[:len(s)-int(decimals)] + "." + s[len(s)-int(decimals):]
+	if neg {
+		s = "-" + s
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+type columnarColumnInfo struct {
+	encoding columnEncoding
+	decimals uint8
+	size     uint64
+}
+
+func decodeColumnarHeader(data []byte) (header ColumnarHeader, columns []columnarColumnInfo, body []byte, err error) {
+	if len(data) < 10 || string(data[:4]) != columnarMagic {
+		err = ErrColumnarInvalidFile
+		return
+	}
+	header.Version = binary.LittleEndian.Uint16(data[4:])
+	if header.Version != columnarVersion {
+		err = fmt.Errorf("%w: unsupported version %d", ErrColumnarInvalidFile, header.Version)
+		return
+	}
+	header.Kind = ColumnarKind(data[6])
+	columnNum := int(data[7])
+	symbolLen := int(binary.LittleEndian.Uint16(data[8:]))
+	data = data[10:]
+	if len(data) < symbolLen+28+columnNum*10 {
+		err = ErrColumnarInvalidFile
+		return
+	}
+	header.Symbol = string(data[:symbolLen])
+	data = data[symbolLen:]
+	header.StartTime = int64(binary.LittleEndian.Uint64(data))
+	header.EndTime = int64(binary.LittleEndian.Uint64(data[8:]))
+	header.Rows = int64(binary.LittleEndian.Uint64(data[16:]))
+	header.Checksum = binary.LittleEndian.Uint32(data[24:])
+	data = data[28:]
+	var total uint64
+	for i := 0; i < columnNum; i++ {
+		info := columnarColumnInfo{
+			encoding: columnEncoding(data[0]),
+			decimals: data[1],
+			size:     binary.LittleEndian.Uint64(data[2:]),
+		}
+		total += info.size
+		columns = append(columns, info)
+		data = data[10:]
+	}
+	if header.Rows < 0 || uint64(len(data)) != total {
+		err = ErrColumnarInvalidFile
+		return
+	}
+	// Rows is checked against the column sizes before any allocation
+	for i, info := range columns {
+		if info.size < minColumnSize(info.encoding, uint64(header.Rows)) {
+			err = fmt.Errorf("%w: column %d of %d bytes is too small for %d rows", ErrColumnarInvalidFile, i, info.size, header.Rows)
+			return
+		}
+	}
+	body = data
+	return
+}
+
+// minColumnSize returns the min size of a column of rows,
+// varints take at least one byte per row and bools take one bit per row.
+func minColumnSize(encoding columnEncoding, rows uint64) uint64 {
+	switch encoding {
+	case columnEncodingDeltaInt, columnEncodingScaledFloat:
+		return rows
+	case columnEncodingBool:
+		return rows/8 + min(rows%8, 1)
+	}
+	return 0
+}
+
+// SaveStructsToColumnar encodes structs to the columnar format and saves them to a file.
+//
+// Parameters:
+//   - data: A slice of structs to be saved.
+//   - symbol: The symbol saved in the header.
+//   - filePath: The path to the columnar file where the data will be saved.
+//
+// Returns:
+//   - An error if any step of the encoding or saving process fails, nil otherwise.
+func SaveStructsToColumnar[T ColumnarStruct](data []T, symbol, filePath string) error {
+	buf, err := EncodeColumnar(symbol, data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, buf, 0644)
+}
+
+// ReadColumnarToStructs memory-maps a columnar file and decodes its structs.
+//
+// Parameters:
+//   - filePath: The path to the columnar file to be read.
+//
+// Returns:
+//   - The header of the columnar file.
+//   - A slice of decoded structs.
+//   - An error if any step of the reading or decoding process fails, nil otherwise.
+func ReadColumnarToStructs[T ColumnarStruct](filePath string) (ColumnarHeader, []T, error) {
+	data, unmap, err := mmapFile(filePath)
+	if err != nil {
+		return ColumnarHeader{}, nil, err
+	}
+	defer unmap()
+	return DecodeColumnar[T](data)
+}
+
+// ReadColumnarHeader reads the header of a columnar file without decoding its structs.
+func ReadColumnarHeader(filePath string) (ColumnarHeader, error) {
+	data, unmap, err := mmapFile(filePath)
+	if err != nil {
+		return ColumnarHeader{}, err
+	}
+	defer unmap()
+	header, _, _, err := decodeColumnarHeader(data)
+	return header, err
+}
+
+// ReadAllCSVToStructsAndSaveToColumnar reads all CSV files in a directory, converts their contents to a slice of structs,
+// and saves the structs to columnar files in a specified directory.
+//
+// Parameters:
+//   - csvFileDir: The directory containing the CSV files to be read.
+//   - columnarFileDir: The directory where the columnar files will be saved.
+//   - symbol: The symbol saved in the headers.
+//   - convertFunc: A function that converts a single CSV line to a struct of type T.
+//
+// Returns:
+//   - An error if any step of the reading, conversion, or saving process fails, nil otherwise.
+func ReadAllCSVToStructsAndSaveToColumnar[T ColumnarStruct](csvFileDir, columnarFileDir, symbol string, convertFunc LineToStructFunc[T]) error {
+	if err := os.MkdirAll(columnarFileDir, 0o755); err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(csvFileDir)
+	if err != nil {
+		return err
+	}
+
+	maxWorkers := runtime.NumCPU() / 2
+	if maxWorkers == 0 {
+		maxWorkers = 1
+	}
+
+	wg := errgroup.Group{}
+	wg.SetLimit(maxWorkers)
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".csv") {
+			continue
+		}
+		file := file
+		wg.Go(func() error {
+			csvFilePath := filepath.Join(csvFileDir, file.Name())
+			columnarFilePath := filepath.Join(columnarFileDir, strings.TrimSuffix(file.Name(), ".csv")+COLUMNAR_FILE_EXT)
+			slog.Info("reading", "csvFilePath", csvFilePath, "columnarFilePath", columnarFilePath)
+			data, err := ReadLinesToStructs(csvFilePath, convertFunc)
+			if err == nil {
+				err = SaveStructsToColumnar(data, symbol, columnarFilePath)
+			}
+			if err != nil {
+				slog.Error("read", "csvFilePath", csvFilePath, "columnarFilePath", columnarFilePath, "error", err)
+				return err
+			}
+			slog.Info("read", "csvFilePath", csvFilePath, "columnarFilePath", columnarFilePath)
+			return nil
+		})
+	}
+
+	return wg.Wait()
+}
+
+// ReadOneDirColumnarToStructs reads all columnar files in a directory in parallel,
+// and returns their structs concatenated in file name order.
+//
+// Parameters:
+//   - dir: The directory containing the columnar files.
+//   - maxCpus: The max number of files decoded at the same time.
+//
+// Returns:
+//   - A slice of structs of all files.
+//   - An error if any file fails to be read, nil otherwise.
+func ReadOneDirColumnarToStructs[T ColumnarStruct](dir string, maxCpus int) ([]T, error) {
+	if maxCpus <= 0 {
+		maxCpus = 1
+	}
+
+	var validFiles []string
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), COLUMNAR_FILE_EXT) {
+			validFiles = append(validFiles, file.Name())
+		}
+	}
+
+	sort.Strings(validFiles)
+
+	wg := errgroup.Group{}
+	wg.SetLimit(maxCpus)
+
+	results := make([][]T, len(validFiles))
+
+	for i, file := range validFiles {
+		i, file := i, file
+		wg.Go(func() error {
+			_, data, err := ReadColumnarToStructs[T](filepath.Join(dir, file))
+			if err != nil {
+				slog.Error("Read Columnar To Structs", "file", file, "error", err)
+				return err
+			}
+			results[i] = data
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+
+	var total int
+	for _, data := range results {
+		total += len(data)
+	}
+	all := make([]T, 0, total)
+	for _, data := range results {
+		all = append(all, data...)
+	}
+	return all, nil
+}
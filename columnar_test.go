@@ -0,0 +1,184 @@
+package bncvision
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+func TestColumnarAggTrades(t *testing.T) {
+	var aggTrades []bnc.AggTrades
+	for _, line := range testAggTradeLines {
+		aggTrade, err := AggTradeLineToStruct([]byte(line))
+		if err != nil {
+			t.Fatalf("AggTradeLineToStruct failed: %v", err)
+		}
+		aggTrades = append(aggTrades, aggTrade)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "BTCUSDT-aggTrades-2024-10-01"+COLUMNAR_FILE_EXT)
+	if err := SaveStructsToColumnar(aggTrades, "BTCUSDT", filePath); err != nil {
+		t.Fatalf("SaveStructsToColumnar failed: %v", err)
+	}
+
+	header, got, err := ReadColumnarToStructs[bnc.AggTrades](filePath)
+	if err != nil {
+		t.Fatalf("ReadColumnarToStructs failed: %v", err)
+	}
+	if header.Symbol != "BTCUSDT" || header.Kind != ColumnarKindAggTrades || header.Rows != int64(len(aggTrades)) {
+		t.Errorf("Unexpected header %+v", header)
+	}
+	if header.StartTime != 1727740800000 || header.EndTime != 1727740800043 {
+		t.Errorf("Unexpected time range %d - %d", header.StartTime, header.EndTime)
+	}
+	if len(got) != len(aggTrades) {
+		t.Fatalf("Expected %d agg trades, got %d", len(aggTrades), len(got))
+	}
+	for i := range aggTrades {
+		if got[i] != aggTrades[i] {
+			t.Errorf("Expected %v, got %v", aggTrades[i], got[i])
+		}
+	}
+
+	if _, _, err := ReadColumnarToStructs[bnc.Kline](filePath); !errors.Is(err, ErrColumnarKindMismatch) {
+		t.Errorf("Expected ErrColumnarKindMismatch, got %v", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read columnar file: %v", err)
+	}
+	data[len(data)-2] ^= 0xff
+	if _, _, err := DecodeColumnar[bnc.AggTrades](data); !errors.Is(err, ErrColumnarChecksum) {
+		t.Errorf("Expected ErrColumnarChecksum, got %v", err)
+	}
+}
+
+func TestDecodeColumnarCorruptRows(t *testing.T) {
+	var aggTrades []bnc.AggTrades
+	for _, line := range testAggTradeLines {
+		aggTrade, err := AggTradeLineToStruct([]byte(line))
+		if err != nil {
+			t.Fatalf("AggTradeLineToStruct failed: %v", err)
+		}
+		aggTrades = append(aggTrades, aggTrade)
+	}
+	data, err := EncodeColumnar("BTCUSDT", aggTrades)
+	if err != nil {
+		t.Fatalf("EncodeColumnar failed: %v", err)
+	}
+
+	// rows are after the magic, version, kind, column number, symbol, start time and end time
+	rowsOffset := 10 + len("BTCUSDT") + 16
+	checksumOffset := rowsOffset + 8
+	for _, rows := range []uint64{1 << 40, 1 << 63} {
+		corrupt := slices.Clone(data)
+		binary.LittleEndian.PutUint64(corrupt[rowsOffset:], rows)
+		if _, _, err := DecodeColumnar[bnc.AggTrades](corrupt); !errors.Is(err, ErrColumnarInvalidFile) {
+			t.Errorf("Expected ErrColumnarInvalidFile for %d rows, got %v", rows, err)
+		}
+	}
+
+	// the header is covered by the checksum
+	for _, rows := range []uint64{uint64(len(aggTrades)) - 1, uint64(len(aggTrades)) + 1} {
+		corrupt := slices.Clone(data)
+		binary.LittleEndian.PutUint64(corrupt[rowsOffset:], rows)
+		if _, _, err := DecodeColumnar[bnc.AggTrades](corrupt); !errors.Is(err, ErrColumnarChecksum) {
+			t.Errorf("Expected ErrColumnarChecksum for %d rows, got %v", rows, err)
+		}
+	}
+	corrupt := slices.Clone(data)
+	corrupt[10] = 'C'
+	if _, _, err := DecodeColumnar[bnc.AggTrades](corrupt); !errors.Is(err, ErrColumnarChecksum) {
+		t.Errorf("Expected ErrColumnarChecksum for a corrupt symbol, got %v", err)
+	}
+
+	// columns must be consumed exactly by rows, even with a matching checksum
+	binary.LittleEndian.PutUint64(corrupt[rowsOffset:], uint64(len(aggTrades))-1)
+	corrupt[10] = 'B'
+	binary.LittleEndian.PutUint32(corrupt[checksumOffset:], columnarChecksum(corrupt, checksumOffset))
+	if _, _, err := DecodeColumnar[bnc.AggTrades](corrupt); !errors.Is(err, ErrColumnarInvalidFile) {
+		t.Errorf("Expected ErrColumnarInvalidFile for fewer rows, got %v", err)
+	}
+}
+
+func TestColumnarKlines(t *testing.T) {
+	var klines []bnc.Kline
+	for _, line := range testKlineLines {
+		kline, err := KlineLineToStruct([]byte(line))
+		if err != nil {
+			t.Fatalf("KlineLineToStruct failed: %v", err)
+		}
+		klines = append(klines, kline)
+	}
+	// big quote volume of daily klines
+	klines[1].QuoteAssetVolume = 31234567890.12345678
+
+	data, err := EncodeColumnar("BTCUSDT", klines)
+	if err != nil {
+		t.Fatalf("EncodeColumnar failed: %v", err)
+	}
+	_, got, err := DecodeColumnar[bnc.Kline](data)
+	if err != nil {
+		t.Fatalf("DecodeColumnar failed: %v", err)
+	}
+	for i := range klines {
+		if got[i] != klines[i] {
+			t.Errorf("Expected %v, got %v", klines[i], got[i])
+		}
+	}
+
+	// volumes of big supply symbols, over the int64 range with 8 decimals
+	pepe := []bnc.Kline{
+		{OpenTime: 1, OpenPrice: 0.00001234, Volume: 12345678901234.56, QuoteAssetVolume: 152345678.12345678},
+		{OpenTime: 2, OpenPrice: 0.00001235, Volume: 9876543210987.5, QuoteAssetVolume: 121987654.87654321},
+	}
+	data, err = EncodeColumnar("1000PEPEUSDT", pepe)
+	if err != nil {
+		t.Fatalf("EncodeColumnar failed: %v", err)
+	}
+	_, got, err = DecodeColumnar[bnc.Kline](data)
+	if err != nil {
+		t.Fatalf("DecodeColumnar failed: %v", err)
+	}
+	for i := range pepe {
+		if got[i] != pepe[i] {
+			t.Errorf("Expected %v, got %v", pepe[i], got[i])
+		}
+	}
+
+	if _, err := EncodeColumnar("BTCUSDT", []bnc.Kline{{OpenPrice: 0.123456789}}); !errors.Is(err, ErrColumnarPrecision) {
+		t.Errorf("Expected ErrColumnarPrecision, got %v", err)
+	}
+}
+
+func TestReadAllCSVToStructsAndSaveToColumnar(t *testing.T) {
+	csvDir := t.TempDir()
+	columnarDir := t.TempDir()
+	files := map[string]string{
+		"BTCUSDT-trades-2024-10-01.csv": testSpotTradeLines[0],
+		"BTCUSDT-trades-2024-10-02.csv": testSpotTradeLines[1],
+	}
+	for name, line := range files {
+		if err := os.WriteFile(filepath.Join(csvDir, name), []byte(line+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write csv file: %v", err)
+		}
+	}
+
+	if err := ReadAllCSVToStructsAndSaveToColumnar(csvDir, columnarDir, "BTCUSDT", SpotTradeLineToStruct); err != nil {
+		t.Fatalf("ReadAllCSVToStructsAndSaveToColumnar failed: %v", err)
+	}
+
+	trades, err := ReadOneDirColumnarToStructs[bnc.SpotTrade](columnarDir, 2)
+	if err != nil {
+		t.Fatalf("ReadOneDirColumnarToStructs failed: %v", err)
+	}
+	if len(trades) != 2 || trades[0].Id != 3949434637 || trades[1].Id != 3949434638 {
+		t.Errorf("Unexpected trades %v", trades)
+	}
+}
@@ -1,12 +1,9 @@
 package bncvision
 
-import (
-	"os"
-	"path/filepath"
-)
-
 const (
 	DATA_VISION_URL = "https://data.binance.vision"
+	// DATA_VISION_LIST_URL is the S3 bucket listing of https://data.binance.vision
+	DATA_VISION_LIST_URL = "https://s3-ap-northeast-1.amazonaws.com/data.binance.vision"
 
 	// DATA_BINANCE_VISION is the directory for https://data.binance.vision
 	DATA_BINANCE_VISION = "data.binance.vision"
@@ -24,21 +21,3 @@ const (
 	// save struct data to json file for future use
 	STRUCT_BINANCE_VISION = "struct.binance.vision"
 )
-
-var (
-	homeDir string
-	dataDir string
-	tidyDir string
-	missDir string
-)
-
-func init() {
-	var err error
-	homeDir, err = os.UserHomeDir()
-	if err != nil {
-		panic(err)
-	}
-	dataDir = filepath.Join(homeDir, DATA_BINANCE_VISION, "data")
-	tidyDir = filepath.Join(homeDir, TIDY_BINANCE_VISION, "data")
-	missDir = filepath.Join(homeDir, MISSING_BINANCE_VISION, "data")
-}
@@ -1,6 +1,7 @@
 package bncvision
 
 import (
+	"context"
 	"encoding/csv"
 	"io"
 	"os"
@@ -168,6 +169,29 @@ func ReadCSVToStructsWithFilter[T any](filePath string, convertFunc RawToStructF
 	return CSVToStructsWithFilter(data, convertFunc, filterFunc)
 }
 
+// ReadLinesToStructs reads a CSV file, or a zip file containing exactly one CSV file,
+// and converts its lines to a slice of structs using a LineToStructFunc.
+//
+// Parameters:
+//   - filePath: The path to the CSV or zip file to be read.
+//   - convertFunc: A function that converts a single CSV line to a struct of type T.
+//
+// Returns:
+//   - A slice of structs of type T, where each struct represents a line from the CSV data.
+//   - An error if any step of the reading or conversion process fails, nil otherwise.
+func ReadLinesToStructs[T any](filePath string, convertFunc LineToStructFunc[T]) ([]T, error) {
+	stream, err := StreamLinesToStructs(context.Background(), filePath, convertFunc)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	var result []T
+	for stream.Next() {
+		result = append(result, stream.Struct())
+	}
+	return result, stream.Err()
+}
+
 func AggTradesReadFilter(aggTrade bnc.AggTrades) bool {
 	return aggTrade.FirstTradeId != -1 && aggTrade.LastTradeId != -1
 }
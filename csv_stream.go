@@ -0,0 +1,319 @@
+package bncvision
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// StructStream yields structs one at a time, CSVStream and LineStream both implement it.
+type StructStream[T any] interface {
+	Next() bool
+	Struct() T
+	Err() error
+}
+
+// CSVStream yields structs converted from CSV records one at a time,
+// so a file never has to be loaded into memory as a whole.
+//
+// Usage:
+//
+//	stream, err := StreamCSVToStructs(ctx, filePath, AggTradeRawToStruct)
+//	if err != nil {
+//		return err
+//	}
+//	defer stream.Close()
+//	for stream.Next() {
+//		aggTrade := stream.Struct()
+//		...
+//	}
+//	if err := stream.Err(); err != nil {
+//		return err
+//	}
+//
+// The first record is treated as a header and skipped if it can not be converted.
+// Records with a different number of fields from the first record are errors.
+// Records are reused between calls, so convertFunc must not retain the raw slice.
+type CSVStream[T any] struct {
+	ctx         context.Context
+	reader      *csv.Reader
+	closers     []io.Closer
+	convertFunc RawToStructFunc[T]
+	item        T
+	err         error
+	started     bool
+	done        bool
+}
+
+// NewCSVStream creates a CSVStream reading CSV records from r.
+//
+// Parameters:
+//   - ctx: The context to stop streaming, Err returns ctx.Err() once it's done.
+//   - r: The reader of CSV data.
+//   - convertFunc: A function that converts a single CSV row (string slice) to a struct of type T.
+//
+// Returns:
+//   - A CSVStream of structs of type T.
+func NewCSVStream[T any](ctx context.Context, r io.Reader, convertFunc RawToStructFunc[T]) *CSVStream[T] {
+	reader := csv.NewReader(r)
+	// records must have as many fields as the first record, as ReadCSV,
+	// so truncated rows are errors instead of reaching convertFunc
+	reader.FieldsPerRecord = 0
+	reader.ReuseRecord = true
+	return &CSVStream[T]{
+		ctx:         ctx,
+		reader:      reader,
+		convertFunc: convertFunc,
+	}
+}
+
+// StreamCSVToStructs opens a CSV file, or a zip file containing exactly one CSV file,
+// and returns a CSVStream of its records converted by convertFunc.
+// The caller must Close the stream.
+//
+// Parameters:
+//   - ctx: The context to stop streaming.
+//   - filePath: The path to the CSV or zip file to be read.
+//   - convertFunc: A function that converts a single CSV row (string slice) to a struct of type T.
+//
+// Returns:
+//   - A CSVStream of structs of type T.
+//   - An error if the file can not be opened, nil otherwise.
+func StreamCSVToStructs[T any](ctx context.Context, filePath string, convertFunc RawToStructFunc[T]) (*CSVStream[T], error) {
+	r, closers, err := openCSVFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	stream := NewCSVStream(ctx, r, convertFunc)
+	stream.closers = closers
+	return stream, nil
+}
+
+// openCSVFile opens a CSV file, or the only file in a zip file.
+func openCSVFile(filePath string) (io.Reader, []io.Closer, error) {
+	if !strings.HasSuffix(filePath, ".zip") {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, []io.Closer{file}, nil
+	}
+
+	zipReader, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(zipReader.File) != 1 {
+		zipReader.Close()
+		return nil, nil, fmt.Errorf("zipReader.File must be 1, but got %d", len(zipReader.File))
+	}
+
+	fileReader, err := zipReader.File[0].Open()
+	if err != nil {
+		zipReader.Close()
+		return nil, nil, err
+	}
+
+	return fileReader, []io.Closer{fileReader, zipReader}, nil
+}
+
+// Next advances the stream to the next struct, which will then be available through Struct.
+// It returns false when the stream stops, either by reaching the end of the data or an error.
+// After Next returns false, Err returns the error occurred, if any.
+func (s *CSVStream[T]) Next() bool {
+	if s.done {
+		return false
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			return s.stop(s.ctx.Err())
+		default:
+		}
+
+		record, err := s.reader.Read()
+		if errors.Is(err, io.EOF) {
+			return s.stop(nil)
+		}
+		if err != nil {
+			return s.stop(err)
+		}
+
+		item, err := s.convertFunc(record)
+		if err != nil {
+			if !s.started {
+				// header
+				s.started = true
+				continue
+			}
+			line, _ := s.reader.FieldPos(0)
+			return s.stop(fmt.Errorf("line %d: %w", line, err))
+		}
+
+		s.started = true
+		s.item = item
+		return true
+	}
+}
+
+func (s *CSVStream[T]) stop(err error) bool {
+	s.err = err
+	s.done = true
+	var empty T
+	s.item = empty
+	return false
+}
+
+// Struct returns the most recent struct generated by a call to Next.
+func (s *CSVStream[T]) Struct() T {
+	return s.item
+}
+
+// Err returns the first error encountered by the stream, or nil if it reached the end of the data.
+func (s *CSVStream[T]) Err() error {
+	return s.err
+}
+
+// Close closes the underlying files of the stream.
+func (s *CSVStream[T]) Close() error {
+	var errs []error
+	for _, closer := range s.closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.closers = nil
+	return errors.Join(errs...)
+}
+
+// LineStream is like CSVStream, but converts raw lines with a LineToStructFunc,
+// which is much faster for the fixed Binance Vision layouts, such as AggTradeLineToStruct.
+// Empty lines are skipped, and the first line is treated as a header and skipped if it can not be converted.
+type LineStream[T any] struct {
+	ctx         context.Context
+	scanner     *bufio.Scanner
+	closers     []io.Closer
+	convertFunc LineToStructFunc[T]
+	item        T
+	err         error
+	line        int
+	started     bool
+	done        bool
+}
+
+// NewLineStream creates a LineStream reading lines from r.
+//
+// Parameters:
+//   - ctx: The context to stop streaming, Err returns ctx.Err() once it's done.
+//   - r: The reader of CSV data.
+//   - convertFunc: A function that converts a single CSV line to a struct of type T.
+//
+// Returns:
+//   - A LineStream of structs of type T.
+func NewLineStream[T any](ctx context.Context, r io.Reader, convertFunc LineToStructFunc[T]) *LineStream[T] {
+	return &LineStream[T]{
+		ctx:         ctx,
+		scanner:     bufio.NewScanner(r),
+		convertFunc: convertFunc,
+	}
+}
+
+// StreamLinesToStructs opens a CSV file, or a zip file containing exactly one CSV file,
+// and returns a LineStream of its lines converted by convertFunc.
+// The caller must Close the stream.
+//
+// Parameters:
+//   - ctx: The context to stop streaming.
+//   - filePath: The path to the CSV or zip file to be read.
+//   - convertFunc: A function that converts a single CSV line to a struct of type T.
+//
+// Returns:
+//   - A LineStream of structs of type T.
+//   - An error if the file can not be opened, nil otherwise.
+func StreamLinesToStructs[T any](ctx context.Context, filePath string, convertFunc LineToStructFunc[T]) (*LineStream[T], error) {
+	r, closers, err := openCSVFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	stream := NewLineStream(ctx, r, convertFunc)
+	stream.closers = closers
+	return stream, nil
+}
+
+// Next advances the stream to the next struct, which will then be available through Struct.
+// It returns false when the stream stops, either by reaching the end of the data or an error.
+// After Next returns false, Err returns the error occurred, if any.
+func (s *LineStream[T]) Next() bool {
+	if s.done {
+		return false
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			return s.stop(s.ctx.Err())
+		default:
+		}
+
+		if !s.scanner.Scan() {
+			return s.stop(s.scanner.Err())
+		}
+		s.line++
+
+		line := bytes.TrimSuffix(s.scanner.Bytes(), []byte{'\r'})
+		if len(line) == 0 {
+			continue
+		}
+
+		item, err := s.convertFunc(line)
+		if err != nil {
+			if !s.started {
+				// header
+				s.started = true
+				continue
+			}
+			return s.stop(fmt.Errorf("line %d: %w", s.line, err))
+		}
+
+		s.started = true
+		s.item = item
+		return true
+	}
+}
+
+func (s *LineStream[T]) stop(err error) bool {
+	s.err = err
+	s.done = true
+	var empty T
+	s.item = empty
+	return false
+}
+
+// Struct returns the most recent struct generated by a call to Next.
+func (s *LineStream[T]) Struct() T {
+	return s.item
+}
+
+// Err returns the first error encountered by the stream, or nil if it reached the end of the data.
+func (s *LineStream[T]) Err() error {
+	return s.err
+}
+
+// Close closes the underlying files of the stream.
+func (s *LineStream[T]) Close() error {
+	var errs []error
+	for _, closer := range s.closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.closers = nil
+	return errors.Join(errs...)
+}
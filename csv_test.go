@@ -1,8 +1,11 @@
 package bncvision
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -52,3 +55,80 @@ func TestReadCSV(t *testing.T) {
 		}
 	}
 }
+
+func TestStreamCSVToStructs(t *testing.T) {
+	tempDir := t.TempDir()
+	rows := "agg_trade_id,price,quantity,first_trade_id,last_trade_id,transact_time,is_buyer_maker\n" +
+		"1,100.5,1,10,11,1609459200000,true\n" +
+		"2,101,2,12,12,1609459200001,false\n" +
+		"3,99.5,3,13,15,1609459200002,true\n"
+
+	csvPath := filepath.Join(tempDir, "BTCUSDT-aggTrades-2021-01-01.csv")
+	if err := os.WriteFile(csvPath, []byte(rows), 0644); err != nil {
+		t.Fatalf("Failed to write csv file: %v", err)
+	}
+	zipPath := filepath.Join(tempDir, "BTCUSDT-aggTrades-2021-01-01.zip")
+	if err := ZipDataAndSave([]byte(rows), "BTCUSDT-aggTrades-2021-01-01.csv", zipPath); err != nil {
+		t.Fatalf("Failed to write zip file: %v", err)
+	}
+
+	for _, filePath := range []string{csvPath, zipPath} {
+		stream, err := StreamCSVToStructs(context.Background(), filePath, AggTradeRawToStruct)
+		if err != nil {
+			t.Fatalf("StreamCSVToStructs failed: %v", err)
+		}
+		var ids []int64
+		for stream.Next() {
+			ids = append(ids, stream.Struct().Id)
+		}
+		if err := stream.Err(); err != nil {
+			t.Errorf("%s: stream error: %v", filePath, err)
+		}
+		if err := stream.Close(); err != nil {
+			t.Errorf("%s: close error: %v", filePath, err)
+		}
+		if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+			t.Errorf("%s: Expected ids [1 2 3], got %v", filePath, ids)
+		}
+
+		aggTrades, err := ReadLinesToStructs(filePath, AggTradeLineToStruct)
+		if err != nil {
+			t.Errorf("%s: ReadLinesToStructs failed: %v", filePath, err)
+		}
+		if len(aggTrades) != 3 || aggTrades[2].LastTradeId != 15 {
+			t.Errorf("%s: Expected 3 agg trades, got %v", filePath, aggTrades)
+		}
+	}
+
+	truncatedPath := filepath.Join(tempDir, "BTCUSDT-aggTrades-2021-01-02.csv")
+	if err := os.WriteFile(truncatedPath, []byte("1,100.5,1,10,11,1609459200000,true\n2,1,1,2\n"), 0644); err != nil {
+		t.Fatalf("Failed to write csv file: %v", err)
+	}
+	truncated, err := StreamCSVToStructs(context.Background(), truncatedPath, AggTradeRawToStruct)
+	if err != nil {
+		t.Fatalf("StreamCSVToStructs failed: %v", err)
+	}
+	for truncated.Next() {
+	}
+	if err := truncated.Err(); !errors.Is(err, csv.ErrFieldCount) {
+		t.Errorf("Expected csv.ErrFieldCount for a truncated row, got %v", err)
+	}
+	truncated.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := StreamCSVToStructs(ctx, csvPath, AggTradeRawToStruct)
+	if err != nil {
+		t.Fatalf("StreamCSVToStructs failed: %v", err)
+	}
+	defer stream.Close()
+	if !stream.Next() {
+		t.Fatalf("Expected first struct, got error: %v", stream.Err())
+	}
+	cancel()
+	if stream.Next() {
+		t.Errorf("Expected stream to stop after cancel")
+	}
+	if !errors.Is(stream.Err(), context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", stream.Err())
+	}
+}
@@ -0,0 +1,369 @@
+package bncvision
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+// Market is the market segment of Binance Vision keys, such as data/<market>/daily/...
+type Market string
+
+const (
+	MarketSpot      Market = "spot"
+	MarketFuturesUM Market = "futures/um"
+	MarketFuturesCM Market = "futures/cm"
+	MarketOption    Market = "option"
+)
+
+// Frequency is the partition of Binance Vision files, one file per day or per month.
+type Frequency string
+
+const (
+	FrequencyDaily   Frequency = "daily"
+	FrequencyMonthly Frequency = "monthly"
+)
+
+// DataType is the data type segment of Binance Vision keys.
+// Data types not listed here are still accepted by the parsers.
+type DataType string
+
+const (
+	DataTypeAggTrades          DataType = "aggTrades"
+	DataTypeTrades             DataType = "trades"
+	DataTypeKlines             DataType = "klines"
+	DataTypeMarkPriceKlines    DataType = "markPriceKlines"
+	DataTypeIndexPriceKlines   DataType = "indexPriceKlines"
+	DataTypePremiumIndexKlines DataType = "premiumIndexKlines"
+	DataTypeFundingRate        DataType = "fundingRate"
+	DataTypeBookTicker         DataType = "bookTicker"
+	DataTypeBookDepth          DataType = "bookDepth"
+	DataTypeMetrics            DataType = "metrics"
+	DataTypeBVOLIndex          DataType = "BVOLIndex"
+	DataTypeEOHSummary         DataType = "EOHSummary"
+)
+
+// HasInterval returns true if the files of the data type are partitioned by kline interval,
+// such as data/spot/daily/klines/BTCUSDT/1s/BTCUSDT-1s-2024-01-01.zip.
+func (t DataType) HasInterval() bool {
+	switch t {
+	case DataTypeKlines, DataTypeMarkPriceKlines, DataTypeIndexPriceKlines, DataTypePremiumIndexKlines:
+		return true
+	}
+	return false
+}
+
+const (
+	datasetDailyLayout   = "2006-01-02"
+	datasetMonthlyLayout = "2006-01"
+)
+
+var ErrInvalidDatasetSpec = errors.New("invalid dataset spec")
+
+// DatasetSpec describes a Binance Vision dataset directory, or one file of it if Date is not zero.
+//
+// Example:
+//
+//	DatasetSpec{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeKlines, Symbol: "BTCUSDT", Interval: Kline1s, Date: day}
+//
+// is data/spot/daily/klines/BTCUSDT/1s/BTCUSDT-1s-2024-01-01.zip.
+type DatasetSpec struct {
+	Market    Market
+	Frequency Frequency
+	DataType  DataType
+	Symbol    string
+	// Interval is only used by the data types which have intervals.
+	Interval KlineInterval
+	// Date is the day of daily files or any day in the month of monthly files, in UTC.
+	// The zero Date means the directory of all files.
+	Date time.Time
+}
+
+// Validate checks if all fields needed by the key of the spec are set.
+func (s DatasetSpec) Validate() error {
+	switch s.Market {
+	case MarketSpot, MarketFuturesUM, MarketFuturesCM, MarketOption:
+	default:
+		return fmt.Errorf("%w: unknown market %q", ErrInvalidDatasetSpec, s.Market)
+	}
+	switch s.Frequency {
+	case FrequencyDaily, FrequencyMonthly:
+	default:
+		return fmt.Errorf("%w: unknown frequency %q", ErrInvalidDatasetSpec, s.Frequency)
+	}
+	if s.DataType == "" || strings.Contains(string(s.DataType), "/") {
+		return fmt.Errorf("%w: invalid data type %q", ErrInvalidDatasetSpec, s.DataType)
+	}
+	if s.Symbol == "" || strings.Contains(s.Symbol, "/") {
+		return fmt.Errorf("%w: invalid symbol %q", ErrInvalidDatasetSpec, s.Symbol)
+	}
+	if s.DataType.HasInterval() {
+		if !isKlineInterval(s.Interval) {
+			return fmt.Errorf("%w: invalid interval %q of %s", ErrInvalidDatasetSpec, s.Interval, s.DataType)
+		}
+	} else if s.Interval != "" {
+		return fmt.Errorf("%w: %s has no interval", ErrInvalidDatasetSpec, s.DataType)
+	}
+	return nil
+}
+
+func isKlineInterval(interval KlineInterval) bool {
+	_, ok := KlineIntervalToMilli[interval]
+	return ok || interval == Kline1mo
+}
+
+// Dir returns the remote prefix of the dataset directory without trailing slash,
+// such as data/spot/daily/klines/BTCUSDT/1s.
+// It stops at the first empty field, so a spec without Symbol is the directory of all symbols.
+func (s DatasetSpec) Dir() string {
+	parts := []string{"data", string(s.Market), string(s.Frequency), string(s.DataType), s.Symbol}
+	if s.DataType.HasInterval() {
+		parts = append(parts, string(s.Interval))
+	}
+	for i, part := range parts {
+		if part == "" {
+			parts = parts[:i]
+			break
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// FileBaseName returns the file name without extension, such as BTCUSDT-1s-2024-01-01 or BTCUSDT-aggTrades-2024-01.
+func (s DatasetSpec) FileBaseName() string {
+	layout := datasetDailyLayout
+	if s.Frequency == FrequencyMonthly {
+		layout = datasetMonthlyLayout
+	}
+	typ := string(s.DataType)
+	if s.DataType.HasInterval() {
+		typ = string(s.Interval)
+	}
+	return s.Symbol + "-" + typ + "-" + s.Date.UTC().Format(layout)
+}
+
+// ZipFileName returns the archive name, such as BTCUSDT-aggTrades-2024-01-01.zip.
+func (s DatasetSpec) ZipFileName() string {
+	return s.FileBaseName() + ".zip"
+}
+
+// CSVFileName returns the name of the csv file inside the archive, such as BTCUSDT-aggTrades-2024-01-01.csv.
+func (s DatasetSpec) CSVFileName() string {
+	return s.FileBaseName() + ".csv"
+}
+
+// Key returns the remote key of the archive, such as data/spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-01-01.zip.
+// If Date is zero, it's the same as Dir.
+func (s DatasetSpec) Key() string {
+	if s.Date.IsZero() {
+		return s.Dir()
+	}
+	return s.Dir() + "/" + s.ZipFileName()
+}
+
+// LocalArchiveDir returns the local directory of the archives in layout, such as <layout.Raw>/data/spot/daily/aggTrades/BTCUSDT.
+func (s DatasetSpec) LocalArchiveDir(layout Layout) string {
+	return filepath.Join(layout.Raw, filepath.FromSlash(s.Dir()))
+}
+
+// LocalArchivePath returns the local path of the archive in layout, such as <layout.Raw>/<Key>.
+func (s DatasetSpec) LocalArchivePath(layout Layout) string {
+	return filepath.Join(s.LocalArchiveDir(layout), s.ZipFileName())
+}
+
+// UnzipDir returns the local directory of the unzipped csv files in layout, such as <layout.Unzip>/data/spot/daily/aggTrades/BTCUSDT.
+func (s DatasetSpec) UnzipDir(layout Layout) string {
+	return filepath.Join(layout.Unzip, filepath.FromSlash(s.Dir()))
+}
+
+// UnzipPath returns the local path of the unzipped csv file in layout.
+func (s DatasetSpec) UnzipPath(layout Layout) string {
+	return filepath.Join(s.UnzipDir(layout), s.CSVFileName())
+}
+
+// MissingDir returns the local directory of the missing data downloaded from the api in layout.
+func (s DatasetSpec) MissingDir(layout Layout) string {
+	return filepath.Join(layout.Missing, filepath.FromSlash(s.Dir()))
+}
+
+// TidyDir returns the local directory of the tidied csv files in layout.
+func (s DatasetSpec) TidyDir(layout Layout) string {
+	return filepath.Join(layout.Tidy, filepath.FromSlash(s.Dir()))
+}
+
+// StructDir returns the local directory of the struct files in layout.
+func (s DatasetSpec) StructDir(layout Layout) string {
+	return filepath.Join(layout.Struct, filepath.FromSlash(s.Dir()))
+}
+
+// AggTradesType returns the api type used to download missing agg trades of the market.
+func (s DatasetSpec) AggTradesType() (bnc.AggTradesType, error) {
+	switch s.Market {
+	case MarketSpot:
+		return bnc.AggTradesTypeSpot, nil
+	case MarketFuturesUM:
+		return bnc.AggTradesTypeUmFutures, nil
+	case MarketFuturesCM:
+		return AggTradesTypeCmFutures, nil
+	}
+	return "", fmt.Errorf("%w: agg trades api of market %q is not supported", ErrInvalidDatasetSpec, s.Market)
+}
+
+// ParseDatasetKey parses a remote key back into a spec.
+// The key may be a file key with .zip, .csv or .CHECKSUM extension, or a directory key,
+// such as data/spot/daily/klines/BTCUSDT/1s/BTCUSDT-1s-2024-01-01.zip or data/futures/um/monthly/aggTrades/BTCUSDT/.
+func ParseDatasetKey(key string) (DatasetSpec, error) {
+	spec := DatasetSpec{}
+	parts := strings.Split(strings.Trim(path.Clean("/"+key), "/"), "/")
+	if len(parts) < 5 || parts[0] != "data" {
+		return spec, fmt.Errorf("%w: unknown key %q", ErrInvalidDatasetSpec, key)
+	}
+	parts = parts[1:]
+
+	if parts[0] == "futures" {
+		spec.Market = Market(parts[0] + "/" + parts[1])
+		parts = parts[2:]
+	} else {
+		spec.Market = Market(parts[0])
+		parts = parts[1:]
+	}
+	if len(parts) < 3 {
+		return spec, fmt.Errorf("%w: unknown key %q", ErrInvalidDatasetSpec, key)
+	}
+	spec.Frequency = Frequency(parts[0])
+	spec.DataType = DataType(parts[1])
+	spec.Symbol = parts[2]
+	parts = parts[3:]
+
+	if spec.DataType.HasInterval() && len(parts) > 0 {
+		spec.Interval = KlineInterval(parts[0])
+		parts = parts[1:]
+	}
+
+	switch len(parts) {
+	case 0:
+	case 1:
+		fileSpec, err := ParseDatasetFileName(parts[0])
+		if err != nil {
+			return spec, err
+		}
+		if fileSpec.Symbol != spec.Symbol || fileSpec.Frequency != spec.Frequency ||
+			(spec.DataType.HasInterval() && fileSpec.Interval != spec.Interval) ||
+			(!spec.DataType.HasInterval() && fileSpec.DataType != spec.DataType) {
+			return spec, fmt.Errorf("%w: file name %q does not match key %q", ErrInvalidDatasetSpec, parts[0], key)
+		}
+		spec.Date = fileSpec.Date
+	default:
+		return spec, fmt.Errorf("%w: unknown key %q", ErrInvalidDatasetSpec, key)
+	}
+
+	if err := spec.Validate(); err != nil {
+		return spec, err
+	}
+	return spec, nil
+}
+
+// ParseDatasetFileName parses a file name, such as BTCUSDT-1s-2024-01-01.zip or BTCUSDT-aggTrades-2024-01.csv.
+// The file name has no market, so Market of the spec is empty,
+// and the data type of interval file names is DataTypeKlines.
+func ParseDatasetFileName(fileName string) (DatasetSpec, error) {
+	spec := DatasetSpec{}
+	base := strings.TrimSuffix(filepath.Base(fileName), CHECKSUM_FILE_SUFFIX)
+	base = strings.TrimSuffix(strings.TrimSuffix(base, ".zip"), ".csv")
+
+	var rest string
+	if date, err := time.Parse(datasetDailyLayout, suffixAfterDash(base, len(datasetDailyLayout))); err == nil {
+		spec.Frequency = FrequencyDaily
+		spec.Date = date
+		rest = base[:len(base)-len(datasetDailyLayout)-1]
+	} else if date, err := time.Parse(datasetMonthlyLayout, suffixAfterDash(base, len(datasetMonthlyLayout))); err == nil {
+		spec.Frequency = FrequencyMonthly
+		spec.Date = date
+		rest = base[:len(base)-len(datasetMonthlyLayout)-1]
+	} else {
+		return spec, fmt.Errorf("%w: no date in file name %q", ErrInvalidDatasetSpec, fileName)
+	}
+
+	i := strings.LastIndexByte(rest, '-')
+	if i <= 0 || i == len(rest)-1 {
+		return spec, fmt.Errorf("%w: unknown file name %q", ErrInvalidDatasetSpec, fileName)
+	}
+	spec.Symbol = rest[:i]
+	typ := rest[i+1:]
+	if isKlineInterval(KlineInterval(typ)) {
+		spec.DataType = DataTypeKlines
+		spec.Interval = KlineInterval(typ)
+	} else {
+		spec.DataType = DataType(typ)
+	}
+	return spec, nil
+}
+
+// suffixAfterDash returns the last n bytes of s if they are preceded by a dash, otherwise empty string.
+func suffixAfterDash(s string, n int) string {
+	if len(s) <= n || s[len(s)-n-1] != '-' {
+		return ""
+	}
+	return s[len(s)-n:]
+}
+
+// ParseDatasetPath parses a local path containing a key,
+// such as /home/ubuntu/data.binance.vision/data/spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-01-01.zip
+// or /home/ubuntu/unzip.binance.vision/data/spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-01-01.csv.
+func ParseDatasetPath(filePath string) (DatasetSpec, error) {
+	slashPath := "/" + strings.Trim(filepath.ToSlash(filePath), "/")
+	i := strings.LastIndex(slashPath, "/data/")
+	if i < 0 {
+		return DatasetSpec{}, fmt.Errorf("%w: no key in path %q", ErrInvalidDatasetSpec, filePath)
+	}
+	return ParseDatasetKey(slashPath[i+1:])
+}
+
+// DownloadDataset downloads the archives of spec to layout.Raw.
+// If spec.Date is zero, all archives in the directory of spec are downloaded,
+// otherwise only the archive of the date is downloaded. Existing archives are skipped.
+//
+// Parameters:
+//   - spec: The dataset to be downloaded.
+//   - layout: The local data roots, the archives are saved under layout.Raw.
+//   - maxDownloadingNum: The max number of files downloaded at the same time.
+//
+// Returns:
+//   - The contents failed to be downloaded.
+//   - An error if listing or downloading fails, nil otherwise.
+func (c *Client) DownloadDataset(spec DatasetSpec, layout Layout, maxDownloadingNum int8) (undownloadContents []DataVisionXMLContent, err error) {
+	if err = spec.Validate(); err != nil {
+		return
+	}
+	if spec.Date.IsZero() {
+		var contents []DataVisionXMLContent
+		_, _, contents, err = c.QueryDataVisionXML(spec.Dir(), "")
+		if err != nil {
+			return
+		}
+		return c.DownloadWithXMLContents(contents, layout, maxDownloadingNum)
+	}
+	return c.DownloadWithXMLContents([]DataVisionXMLContent{{Key: spec.Key()}}, layout, maxDownloadingNum)
+}
+
+// DownloadDataset downloads spec with DefaultClient, see Client.DownloadDataset.
+func DownloadDataset(spec DatasetSpec, layout Layout, maxDownloadingNum int8) (undownloadContents []DataVisionXMLContent, err error) {
+	return DefaultClient.DownloadDataset(spec, layout, maxDownloadingNum)
+}
+
+// UnzipDataset unzips the archives of spec in layout to the unzip directory of spec.
+// If spec.Date is zero, all archives in the directory are unzipped. Existing csv files are skipped.
+func UnzipDataset(spec DatasetSpec, layout Layout) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	if spec.Date.IsZero() {
+		return UnzipAllAndSaveInDir(spec.LocalArchiveDir(layout), spec.UnzipDir(layout))
+	}
+	return UnzipAndSaveWithExistChecking(spec.LocalArchivePath(layout), spec.UnzipDir(layout))
+}
@@ -0,0 +1,181 @@
+package bncvision
+
+import (
+	"fmt"
+	"time"
+)
+
+// truncateDay returns the UTC midnight of t.
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// truncateMonth returns the UTC midnight of the first day of the month of t.
+func truncateMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// PlanDatasetRange plans the archives covering the days from start to end, both inclusive.
+// Months fully covered by the range use monthly archives, the partial months at the edges use daily archives.
+// Frequency and Date of spec are ignored.
+//
+// Returns:
+//   - The monthly and daily archives sorted by date.
+//   - An error if spec is invalid or start is after end, nil otherwise.
+func PlanDatasetRange(spec DatasetSpec, start, end time.Time) ([]DatasetSpec, error) {
+	start, end = truncateDay(start), truncateDay(end)
+	if start.After(end) {
+		return nil, fmt.Errorf("%w: start %s is after end %s", ErrInvalidDatasetSpec, start.Format(time.DateOnly), end.Format(time.DateOnly))
+	}
+	spec.Frequency = FrequencyDaily
+	spec.Date = time.Time{}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	var plan []DatasetSpec
+	for month := truncateMonth(start); !month.After(end); month = month.AddDate(0, 1, 0) {
+		monthEnd := month.AddDate(0, 1, -1)
+		if !month.Before(start) && !monthEnd.After(end) {
+			monthly := spec
+			monthly.Frequency = FrequencyMonthly
+			monthly.Date = month
+			plan = append(plan, monthly)
+			continue
+		}
+		plan = append(plan, dailySpecs(spec, maxTime(month, start), minTime(monthEnd, end))...)
+	}
+	return plan, nil
+}
+
+// dailySpecs returns the daily archives from start to end, both inclusive.
+func dailySpecs(spec DatasetSpec, start, end time.Time) []DatasetSpec {
+	var specs []DatasetSpec
+	for day := truncateDay(start); !day.After(end); day = day.AddDate(0, 0, 1) {
+		daily := spec
+		daily.Frequency = FrequencyDaily
+		daily.Date = day
+		specs = append(specs, daily)
+	}
+	return specs
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// DatasetRangeResult is the result of DownloadDatasetRange.
+type DatasetRangeResult struct {
+	// Archives are the published archives chosen to cover the range, sorted by date.
+	Archives []DatasetSpec
+	// Unavailable are the daily archives not published, such as today's file or days without trading.
+	Unavailable []DatasetSpec
+	// Undownloaded are the archives failed to be downloaded.
+	Undownloaded []DataVisionXMLContent
+}
+
+// PlanPublishedDatasetRange plans the archives like PlanDatasetRange,
+// and checks the plan against the listings of the monthly and daily directories of spec.
+// A monthly archive which is not published yet falls back to the daily archives of the month.
+//
+// Returns:
+//   - The published archives sorted by date.
+//   - The daily archives which are not published.
+//   - An error if planning or listing fails, nil otherwise.
+func (c *Client) PlanPublishedDatasetRange(spec DatasetSpec, start, end time.Time) (archives, unavailable []DatasetSpec, err error) {
+	plan, err := PlanDatasetRange(spec, start, end)
+	if err != nil {
+		return
+	}
+
+	published := map[string]bool{}
+	listed := map[Frequency]bool{}
+	list := func(frequency Frequency) error {
+		if listed[frequency] {
+			return nil
+		}
+		listed[frequency] = true
+		dirSpec := spec
+		dirSpec.Frequency = frequency
+		files, err := c.ListDatasetFiles(dirSpec)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			published[file.Key()] = true
+		}
+		return nil
+	}
+
+	for _, planned := range plan {
+		if err = list(planned.Frequency); err != nil {
+			return
+		}
+		if published[planned.Key()] {
+			archives = append(archives, planned)
+			continue
+		}
+		if planned.Frequency == FrequencyDaily {
+			unavailable = append(unavailable, planned)
+			continue
+		}
+		gLogger.Info("Monthly Archive Not Published, Falling Back To Daily", "key", planned.Key())
+		if err = list(FrequencyDaily); err != nil {
+			return
+		}
+		monthEnd := planned.Date.AddDate(0, 1, -1)
+		for _, daily := range dailySpecs(planned, planned.Date, monthEnd) {
+			if published[daily.Key()] {
+				archives = append(archives, daily)
+			} else {
+				unavailable = append(unavailable, daily)
+			}
+		}
+	}
+	return
+}
+
+// DownloadDatasetRange downloads the archives covering the days from start to end, both inclusive,
+// to layout.Raw, instead of downloading the whole directory.
+// Monthly archives are used for fully covered months and daily archives for the partial edges,
+// and the daily archives are used if a monthly archive is not published yet. Existing archives are skipped.
+//
+// Parameters:
+//   - spec: The market, data type, symbol and interval to be downloaded, Frequency and Date are ignored.
+//   - start: The first day.
+//   - end: The last day.
+//   - layout: The local data roots, the archives are saved under layout.Raw.
+//   - maxDownloadingNum: The max number of files downloaded at the same time.
+//
+// Returns:
+//   - The archives downloaded, unavailable and failed.
+//   - An error if planning, listing or downloading fails, nil otherwise.
+func (c *Client) DownloadDatasetRange(spec DatasetSpec, start, end time.Time, layout Layout, maxDownloadingNum int8) (result DatasetRangeResult, err error) {
+	result.Archives, result.Unavailable, err = c.PlanPublishedDatasetRange(spec, start, end)
+	if err != nil {
+		return
+	}
+	contents := make([]DataVisionXMLContent, 0, len(result.Archives))
+	for _, archive := range result.Archives {
+		contents = append(contents, DataVisionXMLContent{Key: archive.Key()})
+	}
+	result.Undownloaded, err = c.DownloadWithXMLContents(contents, layout, maxDownloadingNum)
+	return
+}
+
+// DownloadDatasetRange downloads a date range with DefaultClient, see Client.DownloadDatasetRange.
+func DownloadDatasetRange(spec DatasetSpec, start, end time.Time, layout Layout, maxDownloadingNum int8) (DatasetRangeResult, error) {
+	return DefaultClient.DownloadDatasetRange(spec, start, end, layout, maxDownloadingNum)
+}
@@ -0,0 +1,85 @@
+package bncvision
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlanDatasetRange(t *testing.T) {
+	spec := DatasetSpec{Market: MarketSpot, DataType: DataTypeKlines, Symbol: "BTCUSDT", Interval: Kline1m}
+	plan, err := PlanDatasetRange(spec, time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC), time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("PlanDatasetRange failed: %v", err)
+	}
+	// 17 days of January, February and March, 10 days of April
+	if len(plan) != 29 {
+		t.Fatalf("Expected 29 archives, got %d", len(plan))
+	}
+	if plan[0].Key() != "data/spot/daily/klines/BTCUSDT/1m/BTCUSDT-1m-2024-01-15.zip" {
+		t.Errorf("Unexpected first archive %s", plan[0].Key())
+	}
+	if plan[17].Key() != "data/spot/monthly/klines/BTCUSDT/1m/BTCUSDT-1m-2024-02.zip" ||
+		plan[18].Key() != "data/spot/monthly/klines/BTCUSDT/1m/BTCUSDT-1m-2024-03.zip" {
+		t.Errorf("Unexpected monthly archives %s %s", plan[17].Key(), plan[18].Key())
+	}
+	if plan[28].Key() != "data/spot/daily/klines/BTCUSDT/1m/BTCUSDT-1m-2024-04-10.zip" {
+		t.Errorf("Unexpected last archive %s", plan[28].Key())
+	}
+
+	plan, err = PlanDatasetRange(spec, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(plan) != 1 || plan[0].Frequency != FrequencyMonthly {
+		t.Errorf("Expected one monthly archive, got %v %v", plan, err)
+	}
+
+	if _, err := PlanDatasetRange(spec, time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Errorf("Expected error when start is after end")
+	}
+}
+
+func TestClientDownloadDatasetRange(t *testing.T) {
+	root := t.TempDir()
+	spec := DatasetSpec{Market: MarketSpot, DataType: DataTypeAggTrades, Symbol: "BTCUSDT"}
+
+	// January is published monthly, February only daily without Feb 10th, March daily
+	january := spec
+	january.Frequency = FrequencyMonthly
+	january.Date = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeTestDatasetZip(t, root, january, []byte("1"))
+	for _, daily := range dailySpecs(spec, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)) {
+		if daily.Date.Equal(time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC)) {
+			continue
+		}
+		writeTestDatasetZip(t, root, daily, []byte("1"))
+	}
+
+	server := NewFakeDataVisionServer(root, 0)
+	defer server.Close()
+	client := server.Client()
+
+	layout := NewLayout(t.TempDir())
+	result, err := client.DownloadDatasetRange(spec, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), layout, 4)
+	if err != nil {
+		t.Fatalf("DownloadDatasetRange failed: %v", err)
+	}
+	// January monthly, 28 days of February and 2 days of March
+	if len(result.Archives) != 31 {
+		t.Errorf("Expected 31 archives, got %d", len(result.Archives))
+	}
+	if len(result.Unavailable) != 1 || result.Unavailable[0].Key() != "data/spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-02-10.zip" {
+		t.Errorf("Unexpected unavailable %v", result.Unavailable)
+	}
+	if len(result.Undownloaded) != 0 {
+		t.Errorf("Unexpected undownloaded %v", result.Undownloaded)
+	}
+	for _, archive := range result.Archives {
+		if exists, _ := FileExists(archive.LocalArchivePath(layout)); !exists {
+			t.Errorf("Archive %s not downloaded", archive.Key())
+		}
+	}
+	march3 := spec
+	march3.Frequency = FrequencyDaily
+	march3.Date = time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)
+	if exists, _ := FileExists(march3.LocalArchivePath(layout)); exists {
+		t.Errorf("Archive out of range should not be downloaded")
+	}
+}
@@ -0,0 +1,140 @@
+package bncvision
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDatasetSpecPaths(t *testing.T) {
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		spec DatasetSpec
+		key  string
+		csv  string
+	}{
+		{
+			DatasetSpec{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeAggTrades, Symbol: "BTCUSDT", Date: day},
+			"data/spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-01-02.zip",
+			"BTCUSDT-aggTrades-2024-01-02.csv",
+		},
+		{
+			DatasetSpec{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeKlines, Symbol: "BTCUSDT", Interval: Kline1s, Date: day},
+			"data/spot/daily/klines/BTCUSDT/1s/BTCUSDT-1s-2024-01-02.zip",
+			"BTCUSDT-1s-2024-01-02.csv",
+		},
+		{
+			DatasetSpec{Market: MarketFuturesUM, Frequency: FrequencyMonthly, DataType: DataTypeFundingRate, Symbol: "BTCUSDT", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			"data/futures/um/monthly/fundingRate/BTCUSDT/BTCUSDT-fundingRate-2024-01.zip",
+			"BTCUSDT-fundingRate-2024-01.csv",
+		},
+		{
+			DatasetSpec{Market: MarketFuturesCM, Frequency: FrequencyMonthly, DataType: DataTypeMarkPriceKlines, Symbol: "BTCUSD_PERP", Interval: Kline1h, Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+			"data/futures/cm/monthly/markPriceKlines/BTCUSD_PERP/1h/BTCUSD_PERP-1h-2024-02.zip",
+			"BTCUSD_PERP-1h-2024-02.csv",
+		},
+		{
+			DatasetSpec{Market: MarketOption, Frequency: FrequencyDaily, DataType: DataTypeEOHSummary, Symbol: "BTC-240102-40000-C", Date: day},
+			"data/option/daily/EOHSummary/BTC-240102-40000-C/BTC-240102-40000-C-EOHSummary-2024-01-02.zip",
+			"BTC-240102-40000-C-EOHSummary-2024-01-02.csv",
+		},
+	}
+
+	for _, c := range cases {
+		if err := c.spec.Validate(); err != nil {
+			t.Errorf("Validate %s failed: %v", c.key, err)
+		}
+		if key := c.spec.Key(); key != c.key {
+			t.Errorf("Expected key %s, got %s", c.key, key)
+		}
+		if name := c.spec.CSVFileName(); name != c.csv {
+			t.Errorf("Expected csv %s, got %s", c.csv, name)
+		}
+		if p := c.spec.LocalArchivePath(NewLayout("/root")); p != filepath.Join("/root", DATA_BINANCE_VISION, filepath.FromSlash(c.key)) {
+			t.Errorf("Unexpected archive path %s", p)
+		}
+
+		for _, key := range []string{c.key, c.key + CHECKSUM_FILE_SUFFIX, "/" + c.key} {
+			parsed, err := ParseDatasetKey(key)
+			if err != nil {
+				t.Errorf("ParseDatasetKey %s failed: %v", key, err)
+				continue
+			}
+			if parsed != c.spec {
+				t.Errorf("Expected %+v, got %+v", c.spec, parsed)
+			}
+		}
+
+		parsed, err := ParseDatasetPath(c.spec.UnzipPath(NewLayout("/home/ubuntu")))
+		if err != nil || parsed != c.spec {
+			t.Errorf("ParseDatasetPath expected %+v, got %+v %v", c.spec, parsed, err)
+		}
+	}
+
+	dir := DatasetSpec{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeKlines, Symbol: "BTCUSDT", Interval: Kline1m}
+	parsed, err := ParseDatasetKey("data/spot/daily/klines/BTCUSDT/1m/")
+	if err != nil || parsed != dir || parsed.Key() != "data/spot/daily/klines/BTCUSDT/1m" {
+		t.Errorf("Unexpected directory spec %+v %v", parsed, err)
+	}
+	if prefix := (DatasetSpec{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeKlines}).Dir(); prefix != "data/spot/daily/klines" {
+		t.Errorf("Unexpected partial dir %s", prefix)
+	}
+}
+
+func TestParseDatasetInvalid(t *testing.T) {
+	keys := []string{
+		"",
+		"data/spot/daily",
+		"spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-01-02.zip",
+		"data/spot/daily/aggTrades/BTCUSDT/ETHUSDT-aggTrades-2024-01-02.zip",
+		"data/spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-01.zip",
+		"data/spot/daily/klines/BTCUSDT/7s/BTCUSDT-7s-2024-01-02.zip",
+		"data/spot/hourly/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-01-02.zip",
+		"data/spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-13-02.zip",
+	}
+	for _, key := range keys {
+		if _, err := ParseDatasetKey(key); !errors.Is(err, ErrInvalidDatasetSpec) {
+			t.Errorf("Expected invalid spec error for %q, got %v", key, err)
+		}
+	}
+
+	spec, err := ParseDatasetFileName("BTCUSDT-1s-2024-01-02.zip")
+	if err != nil || spec.DataType != DataTypeKlines || spec.Interval != Kline1s || spec.Market != "" {
+		t.Errorf("Unexpected file name spec %+v %v", spec, err)
+	}
+}
+
+func TestDatasetDownloadAndUnzip(t *testing.T) {
+	serverRoot, _ := newTestDataVisionRoot(t, 2)
+	server := NewFakeDataVisionServer(serverRoot, 0)
+	defer server.Close()
+	client := server.Client()
+
+	layout := NewLayout(t.TempDir())
+	spec := DatasetSpec{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeAggTrades, Symbol: "BTCUSDT"}
+
+	day := spec
+	day.Date = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	undownloaded, err := client.DownloadDataset(day, layout, 2)
+	if err != nil || len(undownloaded) != 0 {
+		t.Fatalf("DownloadDataset failed: %v %v", undownloaded, err)
+	}
+	files, _ := os.ReadDir(spec.LocalArchiveDir(layout))
+	if len(files) != 2 {
+		// one zip and its checksum
+		t.Errorf("Expected 2 files, got %d", len(files))
+	}
+
+	undownloaded, err = client.DownloadDataset(spec, layout, 2)
+	if err != nil || len(undownloaded) != 0 {
+		t.Fatalf("DownloadDataset failed: %v %v", undownloaded, err)
+	}
+	if err := UnzipDataset(spec, layout); err != nil {
+		t.Fatalf("UnzipDataset failed: %v", err)
+	}
+	if exists, _ := FileExists(day.UnzipPath(layout)); !exists {
+		t.Errorf("Unzipped file %s not found", day.UnzipPath(layout))
+	}
+}
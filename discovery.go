@@ -0,0 +1,275 @@
+package bncvision
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// LISTING_CACHE_FILE_NAME is the name of the cached listing file in the cache directory of a prefix.
+const LISTING_CACHE_FILE_NAME = ".listing.json"
+
+// ListingCache caches listings of prefixes on disk, so discovering hundreds of symbols
+// does not list the bucket again until the TTL expires.
+// The cache directory has the same layout as the bucket, such as <Dir>/data/spot/daily/aggTrades/.listing.json.
+type ListingCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+// NewListingCache returns a ListingCache saving listings under dir, a TTL less than or equal to 0 never expires.
+func NewListingCache(dir string, ttl time.Duration) *ListingCache {
+	return &ListingCache{Dir: dir, TTL: ttl}
+}
+
+type cachedListing struct {
+	Prefix    string                        `json:"prefix"`
+	FetchedAt int64                         `json:"fetchedAt"`
+	Prefixes  []DataVisionXMLCommonPrefixes `json:"prefixes"`
+	Contents  []DataVisionXMLContent        `json:"contents"`
+}
+
+func (lc *ListingCache) filePath(prefix string) string {
+	return filepath.Join(lc.Dir, filepath.FromSlash(strings.Trim(prefix, "/")), LISTING_CACHE_FILE_NAME)
+}
+
+// get returns the cached listing of prefix if it exists and is not expired.
+func (lc *ListingCache) get(prefix string) (cachedListing, bool) {
+	listing := cachedListing{}
+	data, err := os.ReadFile(lc.filePath(prefix))
+	if err != nil {
+		return listing, false
+	}
+	if err := json.Unmarshal(data, &listing); err != nil {
+		gLogger.Warn("Invalid Listing Cache", "prefix", prefix, "error", err)
+		return listing, false
+	}
+	if lc.TTL > 0 && time.Since(time.UnixMilli(listing.FetchedAt)) > lc.TTL {
+		return listing, false
+	}
+	return listing, true
+}
+
+func (lc *ListingCache) put(listing cachedListing) error {
+	filePath := lc.filePath(listing.Prefix)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(listing)
+	if err != nil {
+		return err
+	}
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+// Invalidate removes the cached listing of prefix.
+func (lc *ListingCache) Invalidate(prefix string) error {
+	err := os.Remove(lc.filePath(prefix))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// listPrefix lists prefix through c.Cache if it's not nil.
+func (c *Client) listPrefix(prefix string) (prefixes []DataVisionXMLCommonPrefixes, contents []DataVisionXMLContent, err error) {
+	prefix = strings.Trim(prefix, "/") + "/"
+	if c.Cache != nil {
+		if listing, ok := c.Cache.get(prefix); ok {
+			return listing.Prefixes, listing.Contents, nil
+		}
+	}
+	_, prefixes, contents, err = c.QueryDataVisionXML(prefix, "")
+	if err != nil {
+		return
+	}
+	if c.Cache != nil {
+		err := c.Cache.put(cachedListing{
+			Prefix:    prefix,
+			FetchedAt: time.Now().UnixMilli(),
+			Prefixes:  prefixes,
+			Contents:  contents,
+		})
+		if err != nil {
+			gLogger.Warn("Failed To Cache Listing", "prefix", prefix, "error", err)
+		}
+	}
+	return
+}
+
+// listSubDirs returns the names of the common prefixes directly under prefix, sorted.
+func (c *Client) listSubDirs(prefix string) ([]string, error) {
+	prefixes, _, err := c.listPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		name := path.Base(strings.TrimSuffix(p.Prefix, "/"))
+		if name != "" && name != "." {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ListSymbols lists all symbols of a market, frequency and data type, sorted.
+func (c *Client) ListSymbols(market Market, frequency Frequency, dataType DataType) ([]string, error) {
+	spec := DatasetSpec{Market: market, Frequency: frequency, DataType: dataType}
+	return c.listSubDirs(spec.Dir())
+}
+
+// ListIntervals lists all intervals of a symbol of the data types which have intervals, such as klines.
+func (c *Client) ListIntervals(market Market, frequency Frequency, dataType DataType, symbol string) ([]KlineInterval, error) {
+	spec := DatasetSpec{Market: market, Frequency: frequency, DataType: dataType, Symbol: symbol}
+	names, err := c.listSubDirs(spec.Dir())
+	if err != nil {
+		return nil, err
+	}
+	intervals := make([]KlineInterval, 0, len(names))
+	for _, name := range names {
+		intervals = append(intervals, KlineInterval(name))
+	}
+	return intervals, nil
+}
+
+// ListDatasetFiles lists the archives in the directory of spec, sorted by date.
+// Checksum files and keys which can not be parsed are skipped.
+func (c *Client) ListDatasetFiles(spec DatasetSpec) ([]DatasetSpec, error) {
+	spec.Date = time.Time{}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	_, contents, err := c.listPrefix(spec.Dir())
+	if err != nil {
+		return nil, err
+	}
+	var files []DatasetSpec
+	for _, content := range contents {
+		if !strings.HasSuffix(content.Key, ".zip") {
+			continue
+		}
+		file, err := ParseDatasetKey(content.Key)
+		if err != nil {
+			gLogger.Warn("Unknown Dataset Key", "key", content.Key, "error", err)
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Date.Before(files[j].Date)
+	})
+	return files, nil
+}
+
+// DatasetDateRange is the first and the last available dates of a dataset.
+// For monthly archives, First is the first day of the first month and Last is the last day of the last month.
+type DatasetDateRange struct {
+	Symbol string
+	First  time.Time
+	Last   time.Time
+	// Files is the number of archives, less than the number of days, or months for monthly archives,
+	// between First and Last if some are missing.
+	Files int
+}
+
+// DatasetDateRange works out the first and the last available dates of spec from its archive keys.
+// Last of monthly archives is the last day of the last month.
+// The range is zero if there is no archive.
+func (c *Client) DatasetDateRange(spec DatasetSpec) (DatasetDateRange, error) {
+	dateRange := DatasetDateRange{Symbol: spec.Symbol}
+	files, err := c.ListDatasetFiles(spec)
+	if err != nil {
+		return dateRange, err
+	}
+	if len(files) == 0 {
+		return dateRange, nil
+	}
+	dateRange.First = files[0].Date
+	dateRange.Last = files[len(files)-1].Date
+	if spec.Frequency == FrequencyMonthly {
+		dateRange.Last = dateRange.Last.AddDate(0, 1, -1)
+	}
+	dateRange.Files = len(files)
+	return dateRange, nil
+}
+
+// DiscoverDatasetDateRanges lists all symbols of spec, whose Symbol is ignored,
+// and works out the date range of each symbol.
+// Interval of spec is used for every symbol of the data types which have intervals.
+//
+// Parameters:
+//   - spec: The market, frequency, data type and interval to be discovered.
+//   - maxQueryingNum: The max number of symbols listed at the same time.
+//
+// Returns:
+//   - The date ranges of all symbols, sorted by symbol.
+//   - An error if any listing fails, nil otherwise.
+func (c *Client) DiscoverDatasetDateRanges(spec DatasetSpec, maxQueryingNum int8) ([]DatasetDateRange, error) {
+	symbols, err := c.ListSymbols(spec.Market, spec.Frequency, spec.DataType)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxQueryingNum <= 0 {
+		maxQueryingNum = 1
+	}
+
+	ranges := make([]DatasetDateRange, len(symbols))
+	wg := errgroup.Group{}
+	wg.SetLimit(int(maxQueryingNum))
+	mu := sync.Mutex{}
+
+	for i, symbol := range symbols {
+		i, symbolSpec := i, spec
+		symbolSpec.Symbol = symbol
+		wg.Go(func() error {
+			dateRange, err := c.DatasetDateRange(symbolSpec)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			ranges[i] = dateRange
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+
+	return ranges, nil
+}
+
+// ListSymbols lists symbols with DefaultClient, see Client.ListSymbols.
+func ListSymbols(market Market, frequency Frequency, dataType DataType) ([]string, error) {
+	return DefaultClient.ListSymbols(market, frequency, dataType)
+}
+
+// ListIntervals lists intervals with DefaultClient, see Client.ListIntervals.
+func ListIntervals(market Market, frequency Frequency, dataType DataType, symbol string) ([]KlineInterval, error) {
+	return DefaultClient.ListIntervals(market, frequency, dataType, symbol)
+}
+
+// GetDatasetDateRange works out the date range of spec with DefaultClient, see Client.DatasetDateRange.
+func GetDatasetDateRange(spec DatasetSpec) (DatasetDateRange, error) {
+	return DefaultClient.DatasetDateRange(spec)
+}
+
+// DiscoverDatasetDateRanges discovers date ranges with DefaultClient, see Client.DiscoverDatasetDateRanges.
+func DiscoverDatasetDateRanges(spec DatasetSpec, maxQueryingNum int8) ([]DatasetDateRange, error) {
+	return DefaultClient.DiscoverDatasetDateRanges(spec, maxQueryingNum)
+}
@@ -0,0 +1,144 @@
+package bncvision
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDiscoveryRoot creates a local bucket with daily aggTrades of two symbols, klines of two intervals
+// and monthly aggTrades of one symbol.
+func newTestDiscoveryRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	files := []DatasetSpec{
+		{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeAggTrades, Symbol: "BTCUSDT", Date: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeAggTrades, Symbol: "BTCUSDT", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeAggTrades, Symbol: "BTCUSDT", Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeAggTrades, Symbol: "ETHUSDT", Date: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeKlines, Symbol: "BTCUSDT", Interval: Kline1s, Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeKlines, Symbol: "BTCUSDT", Interval: Kline1m, Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Market: MarketSpot, Frequency: FrequencyMonthly, DataType: DataTypeAggTrades, Symbol: "BTCUSDT", Date: time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{Market: MarketSpot, Frequency: FrequencyMonthly, DataType: DataTypeAggTrades, Symbol: "BTCUSDT", Date: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, file := range files {
+		writeTestDatasetZip(t, root, file, []byte("1"))
+	}
+	return root
+}
+
+// writeTestDatasetZip writes the archive of spec into a local bucket.
+func writeTestDatasetZip(t *testing.T, root string, spec DatasetSpec, data []byte) {
+	t.Helper()
+	filePath := filepath.Join(root, filepath.FromSlash(spec.Key()))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := ZipDataAndSave(data, spec.CSVFileName(), filePath); err != nil {
+		t.Fatalf("Failed to create zip: %v", err)
+	}
+}
+
+func TestClientDiscovery(t *testing.T) {
+	server := NewFakeDataVisionServer(newTestDiscoveryRoot(t), 2)
+	defer server.Close()
+	client := server.Client()
+
+	symbols, err := client.ListSymbols(MarketSpot, FrequencyDaily, DataTypeAggTrades)
+	if err != nil {
+		t.Fatalf("ListSymbols failed: %v", err)
+	}
+	if len(symbols) != 2 || symbols[0] != "BTCUSDT" || symbols[1] != "ETHUSDT" {
+		t.Errorf("Unexpected symbols %v", symbols)
+	}
+
+	intervals, err := client.ListIntervals(MarketSpot, FrequencyDaily, DataTypeKlines, "BTCUSDT")
+	if err != nil {
+		t.Fatalf("ListIntervals failed: %v", err)
+	}
+	if len(intervals) != 2 || intervals[0] != Kline1m || intervals[1] != Kline1s {
+		t.Errorf("Unexpected intervals %v", intervals)
+	}
+
+	spec := DatasetSpec{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeAggTrades, Symbol: "BTCUSDT"}
+	dateRange, err := client.DatasetDateRange(spec)
+	if err != nil {
+		t.Fatalf("DatasetDateRange failed: %v", err)
+	}
+	if !dateRange.First.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) ||
+		!dateRange.Last.Equal(time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)) ||
+		dateRange.Files != 3 {
+		t.Errorf("Unexpected date range %+v", dateRange)
+	}
+
+	monthly := spec
+	monthly.Frequency = FrequencyMonthly
+	dateRange, err = client.DatasetDateRange(monthly)
+	if err != nil {
+		t.Fatalf("DatasetDateRange failed: %v", err)
+	}
+	if !dateRange.First.Equal(time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC)) ||
+		!dateRange.Last.Equal(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)) ||
+		dateRange.Files != 2 {
+		t.Errorf("Unexpected monthly date range %+v", dateRange)
+	}
+
+	ranges, err := client.DiscoverDatasetDateRanges(spec, 2)
+	if err != nil {
+		t.Fatalf("DiscoverDatasetDateRanges failed: %v", err)
+	}
+	if len(ranges) != 2 || ranges[1].Symbol != "ETHUSDT" || ranges[1].Files != 1 ||
+		!ranges[1].First.Equal(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected ranges %+v", ranges)
+	}
+}
+
+func TestClientDiscoveryCache(t *testing.T) {
+	root := newTestDiscoveryRoot(t)
+	server := NewFakeDataVisionServer(root, 0)
+	defer server.Close()
+	client := server.Client()
+	transport := &countingTransport{base: client.HTTPClient.Transport}
+	client.HTTPClient = &http.Client{Transport: transport}
+	client.Cache = NewListingCache(t.TempDir(), time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.ListSymbols(MarketSpot, FrequencyDaily, DataTypeAggTrades); err != nil {
+			t.Fatalf("ListSymbols failed: %v", err)
+		}
+	}
+	if n := transport.count.Load(); n != 1 {
+		t.Errorf("Expected 1 request, got %d", n)
+	}
+
+	// a new symbol is not visible until the cache is invalidated
+	newFile := DatasetSpec{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeAggTrades, Symbol: "BNBUSDT", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	writeTestDatasetZip(t, root, newFile, []byte("1"))
+	symbols, _ := client.ListSymbols(MarketSpot, FrequencyDaily, DataTypeAggTrades)
+	if len(symbols) != 2 {
+		t.Errorf("Expected cached symbols, got %v", symbols)
+	}
+	if err := client.Cache.Invalidate("data/spot/daily/aggTrades"); err != nil {
+		t.Fatalf("Invalidate failed: %v", err)
+	}
+	symbols, _ = client.ListSymbols(MarketSpot, FrequencyDaily, DataTypeAggTrades)
+	if len(symbols) != 3 {
+		t.Errorf("Expected 3 symbols, got %v", symbols)
+	}
+
+	// expired listings are listed again
+	client.Cache.TTL = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	transport.count.Store(0)
+	if _, err := client.ListSymbols(MarketSpot, FrequencyDaily, DataTypeAggTrades); err != nil {
+		t.Fatalf("ListSymbols failed: %v", err)
+	}
+	if n := transport.count.Load(); n != 1 {
+		t.Errorf("Expected 1 request after expiry, got %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(client.Cache.Dir, "data", "spot", "daily", "aggTrades", LISTING_CACHE_FILE_NAME)); err != nil {
+		t.Errorf("Listing cache file not found: %v", err)
+	}
+}
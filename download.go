@@ -5,8 +5,79 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+// Client downloads and lists Binance Vision data.
+// Both base urls can be pointed to a mirror or a FakeDataVisionServer.
+type Client struct {
+	// ListURL is the base url of the S3 ListBucketResult listing, DATA_VISION_LIST_URL by default.
+	ListURL string
+	// DownloadURL is the base url of data files, DATA_VISION_URL by default.
+	DownloadURL string
+	// HTTPClient sends all requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Progress is called while streaming files to disk if it's not nil.
+	Progress DownloadProgressFunc
+	// Retry is the backoff between attempts, permanent errors such as 404 are never retried.
+	Retry RetryPolicy
+	// Limiter limits requests and bytes per second of all goroutines using the Client, unlimited if nil.
+	Limiter *RateLimiter
+	// Cache caches the listings used by symbol and date range discovery, no cache if nil.
+	// QueryDataVisionXML and syncing always list the bucket.
+	Cache *ListingCache
+}
+
+// DownloadProgressFunc reports the progress of a download.
+// Total is -1 if the server does not report the size.
+// It may be called from multiple goroutines when files are downloaded concurrently.
+type DownloadProgressFunc func(url string, downloaded, total int64)
+
+// DOWNLOAD_PART_SUFFIX is the suffix of partially downloaded files, which are resumed by the next download.
+const DOWNLOAD_PART_SUFFIX = ".part"
+
+// NewClient returns a Client of https://data.binance.vision.
+func NewClient() *Client {
+	return &Client{
+		ListURL:     DATA_VISION_LIST_URL,
+		DownloadURL: DATA_VISION_URL,
+		HTTPClient:  &http.Client{},
+		Retry:       DefaultRetryPolicy,
+	}
+}
+
+// DefaultClient is used by the package level download and listing functions.
+var DefaultClient = NewClient()
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+// do sends req after the request rate limit allows it.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	c.Limiter.WaitRequest()
+	return c.httpClient().Do(req)
+}
+
+// get sends a GET request after the request rate limit allows it.
+func (c *Client) get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+// FileURL returns the download url of a key, such as data/spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-01-01.zip.
+func (c *Client) FileURL(key string) string {
+	return strings.TrimSuffix(c.DownloadURL, "/") + "/" + strings.TrimPrefix(key, "/")
+}
+
 // Download downloads a file from the given URL and returns its contents as a byte slice.
 //
 // Parameters:
@@ -16,12 +87,9 @@ import (
 //   - A byte slice containing the downloaded file's contents.
 //   - An integer representing the HTTP status code of the response.
 //   - An error if any step of the download process fails.
-func Download(url string) ([]byte, int, error) {
-	// Create a new HTTP client
-	client := &http.Client{}
-
+func (c *Client) Download(url string) ([]byte, int, error) {
 	// Send a GET request to the URL
-	resp, err := client.Get(url)
+	resp, err := c.get(url)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to send GET request: %w", err)
 	}
@@ -29,30 +97,31 @@ func Download(url string) ([]byte, int, error) {
 
 	statusCode := resp.StatusCode
 
+	if statusCode != http.StatusOK {
+		return nil, statusCode, fmt.Errorf("failed to download file: %w", newHTTPStatusError(url, resp))
+	}
+
 	// Read the response body
-	data, err := io.ReadAll(resp.Body)
+	data, err := io.ReadAll(&progressReader{reader: resp.Body, limiter: c.Limiter})
 	if err != nil {
 		return nil, statusCode, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	if statusCode != http.StatusOK {
-		return nil, statusCode, fmt.Errorf("failed to download file: unexpected status code %d", statusCode)
-	}
-
 	return data, statusCode, nil
 }
 
 // DownloadWithRetry downloads a file from the given URL with retry attempts.
+// It waits c.Retry between attempts, and returns immediately on permanent errors such as 404.
 //
 // Parameters:
 //   - url: The URL of the file to be downloaded.
-//   - retryCount: The number of retry attempts if the initial download fails.
+//   - tryCount: The number of attempts.
 //
 // Returns:
 //   - A byte slice containing the downloaded file's contents.
 //   - An integer representing the HTTP status code of the response.
 //   - An error if the download process fails after all retry attempts.
-func DownloadWithRetry(url string, tryCount int) ([]byte, int, error) {
+func (c *Client) DownloadWithRetry(url string, tryCount int) ([]byte, int, error) {
 	var (
 		data       []byte
 		statusCode int
@@ -60,71 +129,275 @@ func DownloadWithRetry(url string, tryCount int) ([]byte, int, error) {
 	)
 
 	for i := 0; i < tryCount; i++ {
-		data, statusCode, err = Download(url)
+		data, statusCode, err = c.Download(url)
 		if err == nil {
 			return data, statusCode, nil
 		}
 
-		if i < tryCount {
+		if IsPermanentDownloadError(err) {
+			return nil, statusCode, err
+		}
+
+		if i < tryCount-1 {
 			// Log the error and retry
 			gLogger.Error("Download attempt failed, retrying", "attempt", i+1, "error", err)
+			c.Retry.wait(i, err)
 		}
 	}
 
-	return nil, statusCode, fmt.Errorf("failed to download file after %d attempts: %w", tryCount+1, err)
+	return nil, statusCode, fmt.Errorf("failed to download file after %d attempts: %w", tryCount, err)
+}
+
+// DownloadToFile streams a file from the given URL to filePath without buffering it in memory.
+// If filePath already exists, it's treated as a partial download,
+// and only the rest of the file is requested with an HTTP Range request.
+// If the server ignores the range, filePath is truncated and downloaded from the beginning.
+// The progress of the download is reported to c.Progress if it's not nil.
+//
+// Parameters:
+//   - url: The URL of the file to be downloaded.
+//   - filePath: The local path where the file is written, its directories are created if they don't exist.
+//
+// Returns:
+//   - The size of filePath after the download.
+//   - An error if the download is interrupted or fails, filePath is kept so the next call resumes it.
+func (c *Client) DownloadToFile(url, filePath string) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return offset, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return offset, fmt.Errorf("failed to send GET request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	total := int64(-1)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			gLogger.Warn("Server Ignored Range, Restarting Download", "url", url, "offset", offset)
+			if err := file.Truncate(0); err != nil {
+				return offset, err
+			}
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return 0, err
+			}
+			offset = 0
+		}
+		total = resp.ContentLength
+	case http.StatusPartialContent:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			return offset, fmt.Errorf("unexpected content range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+		}
+		total = size
+	case http.StatusRequestedRangeNotSatisfiable:
+		_, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if ok && size == offset {
+			// already completed
+			return offset, nil
+		}
+		// the partial file is longer than the remote file, it's stale
+		if err := file.Truncate(0); err != nil {
+			return offset, err
+		}
+		return 0, fmt.Errorf("failed to resume download: partial file is larger than remote file")
+	default:
+		return offset, fmt.Errorf("failed to download file: %w", newHTTPStatusError(url, resp))
+	}
+
+	reader := &progressReader{
+		reader:     resp.Body,
+		url:        url,
+		downloaded: offset,
+		total:      total,
+		progress:   c.Progress,
+		limiter:    c.Limiter,
+	}
+
+	_, err = io.Copy(file, reader)
+	if err != nil {
+		return reader.downloaded, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if total >= 0 && reader.downloaded != total {
+		return reader.downloaded, fmt.Errorf("failed to read response body: %w", io.ErrUnexpectedEOF)
+	}
+
+	if err := file.Close(); err != nil {
+		return reader.downloaded, err
+	}
+
+	return reader.downloaded, nil
+}
+
+// parseContentRange parses the start and the total size of "bytes <start>-<end>/<size>" or "bytes */<size>".
+// The size is -1 if it's unknown.
+func parseContentRange(contentRange string) (start, size int64, ok bool) {
+	rangeAndSize, found := strings.CutPrefix(contentRange, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	byteRange, sizeStr, found := strings.Cut(rangeAndSize, "/")
+	if !found {
+		return 0, 0, false
+	}
+	size = -1
+	if sizeStr != "*" {
+		var err error
+		size, err = strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	if byteRange == "*" {
+		return 0, size, true
+	}
+	startStr, _, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
+
+// progressReader reports the downloaded bytes while reading,
+// and waits for the bytes rate limit after each read.
+type progressReader struct {
+	reader     io.Reader
+	url        string
+	downloaded int64
+	total      int64
+	progress   DownloadProgressFunc
+	limiter    *RateLimiter
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.limiter.WaitBytes(n)
+		r.downloaded += int64(n)
+		if r.progress != nil {
+			r.progress(r.url, r.downloaded, r.total)
+		}
+	}
+	return n, err
 }
 
 // DownloadSaveZipWithRetry downloads a zip file from the given URL, saves it locally, and verifies its validity.
-// If the initial attempt fails or produces an invalid zip, it retries the process.
+// The zip file is streamed to savePath + ".part", interrupted downloads are resumed with HTTP Range requests,
+// and it's renamed to savePath only after it's verified.
 //
 // Parameters:
 //   - url: The URL of the zip file to be downloaded.
 //   - savePath: The local path where the zip file will be saved.
-//   - tryCount: The number of retry attempts if the initial download fails or produces an invalid zip.
+//   - tryCount: The number of attempts to download or resume the zip file.
 //
 // Returns:
 //   - An error if the download process fails after all retry attempts, or if the resulting file is invalid.
 //   - nil if the download succeeds and produces a valid zip file.
-func DownloadSaveZipWithRetry(url, savePath string, tryCount int) error {
+func (c *Client) DownloadSaveZipWithRetry(url, savePath string, tryCount int) error {
+	return c.downloadSaveZip(url, savePath, tryCount, false)
+}
+
+func (c *Client) downloadSaveZip(url, savePath string, tryCount int, verifyChecksum bool) error {
 	if tryCount <= 0 {
 		return fmt.Errorf("tryCount must be greater than 0")
 	}
 
-	data, statusCode, err := DownloadWithRetry(url, tryCount)
-	if err != nil {
+	partPath := savePath + DOWNLOAD_PART_SUFFIX
+
+	var err error
+	for i := 0; i < tryCount; i++ {
+		_, err = c.DownloadToFile(url, partPath)
+		if err == nil || IsPermanentDownloadError(err) {
+			break
+		}
+		gLogger.Error("Download attempt failed, resuming", "attempt", i+1, "error", err)
+		if i < tryCount-1 {
+			c.Retry.wait(i, err)
+		}
+	}
+	if IsPermanentDownloadError(err) {
+		os.Remove(partPath)
 		return fmt.Errorf("failed to download zip file: %w", err)
 	}
-
-	if statusCode != http.StatusOK {
-		return fmt.Errorf("failed to download zip file: unexpected status code %d", statusCode)
+	if err != nil {
+		return fmt.Errorf("failed to download zip file after %d attempts: %w", tryCount, err)
 	}
 
-	err = SaveZippedDataWithRetry(data, savePath, tryCount)
+	err = isZipFileValid(partPath)
 	if err != nil {
-		return fmt.Errorf("failed to save zip file: %w", err)
+		os.Remove(partPath)
+		return fmt.Errorf("downloaded zip file is invalid: %w", err)
+	}
+
+	var checksumData []byte
+	if verifyChecksum {
+		checksumData, _, err = c.DownloadWithRetry(url+CHECKSUM_FILE_SUFFIX, tryCount)
+		if err != nil {
+			return fmt.Errorf("failed to download checksum file: %w", err)
+		}
+		err = verifyChecksumData(partPath, filepath.Base(savePath), checksumData)
+		if err != nil {
+			os.Remove(partPath)
+			return err
+		}
 	}
 
-	err = IsZippedFileValid(savePath)
+	err = os.Rename(partPath, savePath)
 	if err != nil {
-		return fmt.Errorf("downloaded zip file is invalid: %w", err)
+		return fmt.Errorf("failed to rename zip file: %w", err)
+	}
+
+	if verifyChecksum {
+		err = os.WriteFile(savePath+CHECKSUM_FILE_SUFFIX, checksumData, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to save checksum file: %w", err)
+		}
 	}
 
 	return nil
 }
 
-// DownloadSaveZipWithRetryAndValidate checks the local zip file, and if it's invalid or doesn't exist,
-// downloads the zip file from the given URL, saves it locally, and verifies its validity.
-// If the download attempt fails or produces an invalid zip, it retries the process.
+// DownloadSaveZipWithRetryAndValidate downloads the zip file from the given URL like DownloadSaveZipWithRetry,
+// and also verifies it against url + ".CHECKSUM", which is saved next to the zip file.
+// If the download attempt fails, produces an invalid zip or a checksum mismatch, it retries the process.
 //
 // Parameters:
+//   - filePath: The local path where the zip file will be saved.
 //   - url: The URL of the zip file to be downloaded.
-//   - savePath: The local path where the zip file will be saved.
 //   - tryCount: The number of retry attempts if the download fails or produces an invalid zip.
 //
 // Returns:
 //   - An error if the process fails after all retry attempts, or if the resulting file is invalid.
-//   - nil if the local file is valid or if the download succeeds and produces a valid zip file.
-func DownloadSaveZipWithRetryAndValidate(filePath, url string, tryCount int) error {
+//   - nil if the download succeeds and produces a valid zip file.
+func (c *Client) DownloadSaveZipWithRetryAndValidate(filePath, url string, tryCount int) error {
 	if tryCount <= 0 {
 		return fmt.Errorf("tryCount must be greater than 0")
 	}
@@ -132,24 +405,39 @@ func DownloadSaveZipWithRetryAndValidate(filePath, url string, tryCount int) err
 	gLogger.Info("Downloading zip file", "url", url, "filePath", filePath)
 
 	for i := 0; i < tryCount; i++ {
-		err := DownloadSaveZipWithRetry(url, filePath, 1) // Use 1 for tryCount as we're handling retries here
-		if err != nil {
-			gLogger.Error("Failed to download and save zip", "attempt", i+1, "error", err)
-			continue
-		}
-
-		err = IsZippedFileValid(filePath)
+		err := c.downloadSaveZip(url, filePath, 1, true) // Use 1 for tryCount as we're handling retries here
 		if err == nil {
 			gLogger.Info("Downloaded zip file is valid", "filePath", filePath)
 			return nil
 		}
-
-		gLogger.Error("Downloaded zip file is invalid, retrying", "attempt", i+1, "error", err)
-		// Delete the invalid zip file before retrying
-		if err := os.Remove(filePath); err != nil {
-			gLogger.Error("Failed to remove invalid zip file", "path", filePath, "error", err)
+		if IsPermanentDownloadError(err) {
+			return err
+		}
+		gLogger.Error("Failed to download and validate zip, retrying", "attempt", i+1, "error", err)
+		if i < tryCount-1 {
+			c.Retry.wait(i, err)
 		}
 	}
 
 	return fmt.Errorf("failed to download and validate zip file after %d attempts", tryCount)
 }
+
+// Download downloads a file with DefaultClient, see Client.Download.
+func Download(url string) ([]byte, int, error) {
+	return DefaultClient.Download(url)
+}
+
+// DownloadWithRetry downloads a file with DefaultClient, see Client.DownloadWithRetry.
+func DownloadWithRetry(url string, tryCount int) ([]byte, int, error) {
+	return DefaultClient.DownloadWithRetry(url, tryCount)
+}
+
+// DownloadSaveZipWithRetry downloads a zip file with DefaultClient, see Client.DownloadSaveZipWithRetry.
+func DownloadSaveZipWithRetry(url, savePath string, tryCount int) error {
+	return DefaultClient.DownloadSaveZipWithRetry(url, savePath, tryCount)
+}
+
+// DownloadSaveZipWithRetryAndValidate downloads a zip file with DefaultClient, see Client.DownloadSaveZipWithRetryAndValidate.
+func DownloadSaveZipWithRetryAndValidate(filePath, url string, tryCount int) error {
+	return DefaultClient.DownloadSaveZipWithRetryAndValidate(filePath, url, tryCount)
+}
@@ -0,0 +1,213 @@
+package bncvision
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestDataVisionRoot creates a local bucket with daily aggTrades zips of BTCUSDT.
+func newTestDataVisionRoot(t *testing.T, days int) (root, prefix string) {
+	t.Helper()
+	root = t.TempDir()
+	prefix = "data/spot/daily/aggTrades/BTCUSDT"
+	dir := filepath.Join(root, filepath.FromSlash(prefix))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	for i := 1; i <= days; i++ {
+		name := fmt.Sprintf("BTCUSDT-aggTrades-2024-01-%02d", i)
+		data := []byte(fmt.Sprintf("%d,100,1,%d,%d,1704067200000,true,true", i, i, i))
+		if err := ZipDataAndSave(data, name+".csv", filepath.Join(dir, name+".zip")); err != nil {
+			t.Fatalf("Failed to create zip: %v", err)
+		}
+	}
+	return
+}
+
+func TestClientQueryDataVisionXML(t *testing.T) {
+	root, prefix := newTestDataVisionRoot(t, 5)
+	server := NewFakeDataVisionServer(root, 3)
+	defer server.Close()
+	client := server.Client()
+
+	xmls, prefixes, contents, err := client.QueryDataVisionXML("data/spot/daily/aggTrades/", "")
+	if err != nil {
+		t.Fatalf("QueryDataVisionXML failed: %v", err)
+	}
+	if len(xmls) != 1 || len(prefixes) != 1 || prefixes[0].Prefix != prefix+"/" || len(contents) != 0 {
+		t.Errorf("Unexpected listing %v %v", prefixes, contents)
+	}
+
+	xmls, _, contents, err = client.QueryDataVisionXML(prefix, "")
+	if err != nil {
+		t.Fatalf("QueryDataVisionXML failed: %v", err)
+	}
+	// 5 zips and 5 checksums, 3 keys per page
+	if len(xmls) != 4 {
+		t.Errorf("Expected 4 pages, got %d", len(xmls))
+	}
+	if len(contents) != 10 {
+		t.Fatalf("Expected 10 contents, got %d", len(contents))
+	}
+	for i, content := range contents {
+		if i > 0 && contents[i-1].Key >= content.Key {
+			t.Errorf("Contents are not sorted: %s, %s", contents[i-1].Key, content.Key)
+		}
+		if content.ETag == "" || content.Size == 0 || content.LastModified == "" {
+			t.Errorf("Unexpected content %+v", content)
+		}
+	}
+}
+
+func TestClientDownloadWithXMLContents(t *testing.T) {
+	root, prefix := newTestDataVisionRoot(t, 3)
+	server := NewFakeDataVisionServer(root, 0)
+	defer server.Close()
+	client := server.Client()
+
+	_, _, contents, err := client.QueryDataVisionXML(prefix, "")
+	if err != nil {
+		t.Fatalf("QueryDataVisionXML failed: %v", err)
+	}
+
+	layout := NewLayout(t.TempDir())
+	localDir := layout.Raw
+	undownloaded, err := client.DownloadWithXMLContents(contents, layout, 2)
+	if err != nil {
+		t.Fatalf("DownloadWithXMLContents failed: %v", err)
+	}
+	if len(undownloaded) != 0 {
+		t.Errorf("Expected all downloaded, got %v", undownloaded)
+	}
+
+	for _, content := range contents {
+		if !strings.HasSuffix(content.Key, ".zip") {
+			continue
+		}
+		expected, err := os.ReadFile(filepath.Join(root, content.Key))
+		if err != nil {
+			t.Fatalf("Failed to read source file: %v", err)
+		}
+		got, err := os.ReadFile(filepath.Join(localDir, content.Key))
+		if err != nil {
+			t.Fatalf("Failed to read downloaded file: %v", err)
+		}
+		if !bytes.Equal(expected, got) {
+			t.Errorf("Downloaded file %s mismatch", content.Key)
+		}
+	}
+
+	data, statusCode, err := client.Download(client.FileURL(prefix + "/BTCUSDT-aggTrades-2024-01-01.zip" + CHECKSUM_FILE_SUFFIX))
+	if err != nil || statusCode != 200 {
+		t.Fatalf("Download checksum failed: %d %v", statusCode, err)
+	}
+	if !strings.HasSuffix(string(data), "  BTCUSDT-aggTrades-2024-01-01.zip\n") || len(strings.Fields(string(data))[0]) != 64 {
+		t.Errorf("Unexpected checksum file %q", data)
+	}
+
+	if _, statusCode, err := client.Download(client.FileURL(prefix + "/BTCUSDT-aggTrades-2024-02-01.zip")); err == nil || statusCode != 404 {
+		t.Errorf("Expected 404, got %d %v", statusCode, err)
+	}
+}
+
+func TestClientDownloadSaveZipResume(t *testing.T) {
+	root, prefix := newTestDataVisionRoot(t, 1)
+	key := prefix + "/BTCUSDT-aggTrades-2024-01-01.zip"
+	expected, err := os.ReadFile(filepath.Join(root, key))
+	if err != nil {
+		t.Fatalf("Failed to read source file: %v", err)
+	}
+
+	fake := NewFakeDataVisionServer(root, 0)
+	defer fake.Close()
+
+	// the first response is cut in the middle, the following ones are served normally
+	var requests int
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		ranges = append(ranges, r.Header.Get("Range"))
+		if requests == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(expected)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(expected[:len(expected)/2])
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	var lastDownloaded, lastTotal int64
+	client := &Client{
+		ListURL:     server.URL + "/",
+		DownloadURL: server.URL,
+		HTTPClient:  server.Client(),
+		Progress: func(url string, downloaded, total int64) {
+			lastDownloaded, lastTotal = downloaded, total
+		},
+	}
+
+	savePath := filepath.Join(t.TempDir(), "BTCUSDT-aggTrades-2024-01-01.zip")
+	if err := client.DownloadSaveZipWithRetry(client.FileURL(key), savePath, 2); err != nil {
+		t.Fatalf("DownloadSaveZipWithRetry failed: %v", err)
+	}
+
+	got, err := os.ReadFile(savePath)
+	if err != nil {
+		t.Fatalf("Failed to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(expected, got) {
+		t.Errorf("Resumed file mismatch")
+	}
+	if exists, _ := FileExists(savePath + DOWNLOAD_PART_SUFFIX); exists {
+		t.Errorf("Part file should be renamed")
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != fmt.Sprintf("bytes=%d-", len(expected)/2) {
+		t.Errorf("Unexpected range headers %q", ranges)
+	}
+	if lastDownloaded != int64(len(expected)) || lastTotal != int64(len(expected)) {
+		t.Errorf("Unexpected progress %d/%d", lastDownloaded, lastTotal)
+	}
+}
+
+func TestClientDownloadToFileRangeIgnored(t *testing.T) {
+	data := []byte("0123456789")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	client := &Client{HTTPClient: server.Client()}
+	filePath := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(filePath, []byte("01234xx"), 0644); err != nil {
+		t.Fatalf("Failed to write part file: %v", err)
+	}
+
+	n, err := client.DownloadToFile(server.URL, filePath)
+	if err != nil {
+		t.Fatalf("DownloadToFile failed: %v", err)
+	}
+	got, _ := os.ReadFile(filePath)
+	if n != int64(len(data)) || !bytes.Equal(data, got) {
+		t.Errorf("Expected %q, got %q", data, got)
+	}
+
+	// the part file is already complete, the server answers 416 with the total size
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file"), data, 0644); err != nil {
+		t.Fatalf("Failed to write server file: %v", err)
+	}
+	fake := NewFakeDataVisionServer(root, 0)
+	defer fake.Close()
+	n, err = fake.Client().DownloadToFile(fake.URL()+"/file", filePath)
+	if err != nil || n != int64(len(data)) {
+		t.Errorf("Expected completed file, got %d %v", n, err)
+	}
+}
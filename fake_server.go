@@ -0,0 +1,267 @@
+package bncvision
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CHECKSUM_FILE_SUFFIX = ".CHECKSUM"
+
+// FakeDataVisionServer is an in-process Binance Vision server backed by a local directory,
+// so the whole download pipeline can be tested offline.
+//
+// The directory has the same layout as the bucket,
+// such as <Root>/data/spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-01-01.zip.
+// The server lists keys as S3 ListBucketResult xml with paging, serves files with range support,
+// and serves a generated .CHECKSUM for every zip file which has no .CHECKSUM file in the directory.
+type FakeDataVisionServer struct {
+	Root string
+	// MaxKeys is the max number of keys in one listing page, 1000 by default like S3.
+	MaxKeys int
+
+	server *httptest.Server
+}
+
+// NewFakeDataVisionServer starts a FakeDataVisionServer serving root.
+// The caller must Close the server.
+func NewFakeDataVisionServer(root string, maxKeys int) *FakeDataVisionServer {
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+	s := &FakeDataVisionServer{
+		Root:    root,
+		MaxKeys: maxKeys,
+	}
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+// URL returns the base url of the server.
+func (s *FakeDataVisionServer) URL() string {
+	return s.server.URL
+}
+
+// Close shuts down the server.
+func (s *FakeDataVisionServer) Close() {
+	s.server.Close()
+}
+
+// Client returns a Client whose listing and download base urls are both the server.
+func (s *FakeDataVisionServer) Client() *Client {
+	return &Client{
+		ListURL:     s.server.URL + "/",
+		DownloadURL: s.server.URL,
+		HTTPClient:  s.server.Client(),
+	}
+}
+
+func (s *FakeDataVisionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == "/" {
+		s.serveList(w, r)
+		return
+	}
+	s.serveFile(w, r)
+}
+
+func (s *FakeDataVisionServer) localPath(key string) (string, bool) {
+	key = strings.TrimPrefix(key, "/")
+	if key == "" || strings.Contains(key, "..") {
+		return "", false
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(key)), true
+}
+
+func (s *FakeDataVisionServer) serveFile(w http.ResponseWriter, r *http.Request) {
+	filePath, ok := s.localPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(filePath)
+	if err == nil {
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+		return
+	}
+
+	if !os.IsNotExist(err) || !strings.HasSuffix(filePath, CHECKSUM_FILE_SUFFIX) {
+		http.NotFound(w, r)
+		return
+	}
+
+	zipPath := strings.TrimSuffix(filePath, CHECKSUM_FILE_SUFFIX)
+	checksum, modTime, err := fakeChecksumFile(zipPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, filepath.Base(filePath), modTime, bytes.NewReader(checksum))
+}
+
+// fakeChecksumFile generates the content of the .CHECKSUM file of a zip file,
+// which is the same format as Binance Vision, "<sha256>  <file name>\n".
+func fakeChecksumFile(zipPath string) ([]byte, time.Time, error) {
+	info, err := os.Stat(zipPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(zipPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	sum := sha256.Sum256(data)
+	return []byte(hex.EncodeToString(sum[:]) + "  " + filepath.Base(zipPath) + "\n"), info.ModTime(), nil
+}
+
+func (s *FakeDataVisionServer) serveList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	marker := query.Get("marker")
+	delimiter := query.Get("delimiter")
+	maxKeys := s.MaxKeys
+	if mk, err := strconv.Atoi(query.Get("max-keys")); err == nil && mk > 0 && mk < maxKeys {
+		maxKeys = mk
+	}
+
+	result := DataVisionXML{
+		Xmlns:     "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:      "data.binance.vision",
+		Prefix:    prefix,
+		Maker:     marker,
+		MaxKeys:   int64(maxKeys),
+		Delimiter: delimiter,
+	}
+
+	entries, err := s.listEntries(prefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.key <= marker {
+			continue
+		}
+		if len(result.CommonPrefixes)+len(result.Contents) == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if entry.content == nil {
+			result.CommonPrefixes = append(result.CommonPrefixes, DataVisionXMLCommonPrefixes{Prefix: entry.key})
+		} else {
+			result.Contents = append(result.Contents, *entry.content)
+		}
+		result.NextMarker = entry.key
+	}
+
+	if !result.IsTruncated {
+		result.NextMarker = ""
+	}
+
+	data, err := xml.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+type fakeListEntry struct {
+	key     string
+	content *DataVisionXMLContent
+}
+
+// listEntries lists keys directly under prefix, sorted like S3.
+// Prefix is a directory of Root here, such as data/spot/daily/aggTrades/BTCUSDT/.
+func (s *FakeDataVisionServer) listEntries(prefix string) ([]fakeListEntry, error) {
+	dir := s.Root
+	if prefix != "" {
+		var ok bool
+		dir, ok = s.localPath(path.Clean(prefix))
+		if !ok {
+			return nil, nil
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, file := range files {
+		names[file.Name()] = true
+	}
+
+	var entries []fakeListEntry
+	for _, file := range files {
+		key := prefix + file.Name()
+		if file.IsDir() {
+			entries = append(entries, fakeListEntry{key: key + "/"})
+			continue
+		}
+		filePath := filepath.Join(dir, file.Name())
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fakeListEntry{key: key, content: fakeXMLContent(key, data, info.ModTime())})
+
+		if strings.HasSuffix(file.Name(), ".zip") && !names[file.Name()+CHECKSUM_FILE_SUFFIX] {
+			checksum, modTime, err := fakeChecksumFile(filePath)
+			if err != nil {
+				return nil, err
+			}
+			checksumKey := key + CHECKSUM_FILE_SUFFIX
+			entries = append(entries, fakeListEntry{key: checksumKey, content: fakeXMLContent(checksumKey, checksum, modTime)})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	return entries, nil
+}
+
+func fakeXMLContent(key string, data []byte, modTime time.Time) *DataVisionXMLContent {
+	sum := md5.Sum(data)
+	return &DataVisionXMLContent{
+		Key:          key,
+		LastModified: modTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+		ETag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		Size:         int64(len(data)),
+		StorageClass: "STANDARD",
+	}
+}
@@ -0,0 +1,212 @@
+package bncvision
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GAP_REPORT_VERSION is the version of the gap report layout.
+// Reports of other versions are discarded and the directory is scanned again.
+const GAP_REPORT_VERSION = 1
+
+// MAX_GAP_BACKFILL_ATTEMPTS is the number of back-fills of a gap before it's regarded as Unavailable.
+const MAX_GAP_BACKFILL_ATTEMPTS = 3
+
+const (
+	// GAP_REPORT_JSON_FILE_NAME is the name of the gap report in the missing directory of a dataset.
+	GAP_REPORT_JSON_FILE_NAME = ".gaps.json"
+	// GAP_REPORT_CSV_FILE_NAME is the name of the csv copy of the gap report, for spreadsheets and scripts.
+	GAP_REPORT_CSV_FILE_NAME = ".gaps.csv"
+)
+
+// GapReport is the persisted result of the gap scans of one symbol and data type.
+type GapReport struct {
+	Version int    `json:"version"`
+	Kind    string `json:"kind"`
+	Symbol  string `json:"symbol"`
+	// ScannedAt is the time in milliseconds of the last scan.
+	ScannedAt int64 `json:"scannedAt"`
+	// Last is the edge of the last scanned file, the next scan resumes after it.
+	Last *GapEdge `json:"last"`
+	// ScannedFiles are the sorted names of the scanned files.
+	// The report is started over if a file before Last is not scanned, such as an archive published late.
+	ScannedFiles []string `json:"scannedFiles"`
+	// Gaps are sorted by StartId.
+	Gaps []Gap `json:"gaps"`
+}
+
+// LoadGapReport reads the gap report in dir.
+// A new report is returned if the report file does not exist or its version is not GAP_REPORT_VERSION.
+func LoadGapReport(dir string) (*GapReport, error) {
+	report := &GapReport{Version: GAP_REPORT_VERSION}
+	data, err := os.ReadFile(filepath.Join(dir, GAP_REPORT_JSON_FILE_NAME))
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal gap report: %w", err)
+	}
+	if report.Version != GAP_REPORT_VERSION {
+		gLogger.Warn("Discarding Gap Report Of Other Version", "dir", dir, "version", report.Version)
+		return &GapReport{Version: GAP_REPORT_VERSION}, nil
+	}
+	return report, nil
+}
+
+// Save writes the report to dir as json and csv.
+// It writes temporary files first and renames them, so a crash never leaves a broken report.
+func (r *GapReport) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, GAP_REPORT_JSON_FILE_NAME), data); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, GAP_REPORT_CSV_FILE_NAME), []byte(r.CSV()))
+}
+
+func writeFileAtomic(filePath string, data []byte) error {
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+// CSV returns the gaps as csv with header, files of a gap are joined by semicolons.
+func (r *GapReport) CSV() string {
+	sb := &strings.Builder{}
+	w := csv.NewWriter(sb)
+	_ = w.Write([]string{"kind", "symbol", "start_id", "end_id", "start_time", "end_time", "size", "files", "backfilled", "attempts", "unavailable"})
+	for _, gap := range r.Gaps {
+		_ = w.Write([]string{
+			r.Kind,
+			r.Symbol,
+			strconv.FormatInt(gap.StartId, 10),
+			strconv.FormatInt(gap.EndId, 10),
+			strconv.FormatInt(gap.StartTime, 10),
+			strconv.FormatInt(gap.EndTime, 10),
+			strconv.FormatInt(gap.Size, 10),
+			strings.Join(gap.Files, ";"),
+			strconv.FormatBool(gap.Backfilled),
+			strconv.Itoa(gap.Attempts),
+			strconv.FormatBool(gap.Unavailable),
+		})
+	}
+	w.Flush()
+	return sb.String()
+}
+
+// Pending returns the indexes of the gaps which are not backfilled yet and not Unavailable.
+func (r *GapReport) Pending() []int {
+	var pending []int
+	for i, gap := range r.Gaps {
+		if !gap.Backfilled && !gap.Unavailable {
+			pending = append(pending, i)
+		}
+	}
+	return pending
+}
+
+// ScanOneDirGapReport scans the csv files in dir for gaps of kind, and saves the report to reportDir.
+// The scan is incremental, only files after the last scanned file of the report are read,
+// unless the last scanned file is before the day of startTime, or a file before the last scanned file is not scanned yet,
+// in which case the report is started over. Gaps of a started over report keep the back-fill state,
+// backfilled, attempts and unavailable, of the gaps of the old report they are within.
+//
+// Parameters:
+//   - dir: The directory of the csv files.
+//   - reportDir: The directory of the report, such as the missing directory of the dataset.
+//   - kind: The data type of the csv files.
+//   - maxCpus: The max number of files scanned at the same time.
+//   - startTime: Files before the day of startTime are skipped.
+//
+// Returns:
+//   - The report with the gaps found before and now.
+//   - An error if the report or any file can not be read or saved, nil otherwise.
+func ScanOneDirGapReport[T any](dir, reportDir string, kind TidyKind[T], maxCpus int, startTime time.Time) (*GapReport, error) {
+	report, err := LoadGapReport(reportDir)
+	if err != nil {
+		return nil, err
+	}
+	if report.Kind != "" && (report.Kind != kind.Name || report.Symbol != kind.Symbol) {
+		return nil, fmt.Errorf("gap report in %s is of %s %s, not %s %s", reportDir, report.Symbol, report.Kind, kind.Symbol, kind.Name)
+	}
+	report.Kind, report.Symbol = kind.Name, kind.Symbol
+
+	files, err := tidyDirFiles(dir, startTime)
+	if err != nil {
+		return nil, err
+	}
+	var previous []Gap
+	if report.Last != nil {
+		i := sort.SearchStrings(files, report.Last.File)
+		switch {
+		case i == len(files) || files[i] != report.Last.File:
+			gLogger.Info("Starting Gap Report Over", "dir", dir, "last", report.Last.File)
+			previous = report.Gaps
+			report.Last, report.Gaps, report.ScannedFiles = nil, nil, nil
+		case !report.scanned(files[:i]):
+			gLogger.Warn("Starting Gap Report Over For New Files Before The Last Scanned File", "dir", dir, "last", report.Last.File)
+			previous = report.Gaps
+			report.Last, report.Gaps, report.ScannedFiles = nil, nil, nil
+		default:
+			files = files[i+1:]
+		}
+	}
+
+	gLogger.Info("Scanning Gaps", "kind", kind.Name, "symbol", kind.Symbol, "files", len(files))
+	gaps, last, err := scanGaps(dir, files, kind, maxCpus, report.Last)
+	if err != nil {
+		return nil, err
+	}
+	for i := range gaps {
+		gaps[i] = inheritGap(previous, gaps[i])
+	}
+	report.Gaps = append(report.Gaps, gaps...)
+	report.Last = last
+	report.ScannedFiles = append(report.ScannedFiles, files...)
+	sort.Strings(report.ScannedFiles)
+	report.ScannedAt = time.Now().UnixMilli()
+	if err := report.Save(reportDir); err != nil {
+		return nil, err
+	}
+	gLogger.Info("Scanned Gaps", "kind", kind.Name, "symbol", kind.Symbol, "new", len(gaps), "total", len(report.Gaps))
+	return report, nil
+}
+
+// scanned returns true if all files are in r.ScannedFiles.
+func (r *GapReport) scanned(files []string) bool {
+	for _, file := range files {
+		i := sort.SearchStrings(r.ScannedFiles, file)
+		if i == len(r.ScannedFiles) || r.ScannedFiles[i] != file {
+			return false
+		}
+	}
+	return true
+}
+
+// inheritGap returns gap with the back-fill state of the gap of previous which gap is within.
+func inheritGap(previous []Gap, gap Gap) Gap {
+	for _, g := range previous {
+		if g.StartId <= gap.StartId && gap.EndId <= g.EndId {
+			gap.Backfilled, gap.Attempts, gap.Unavailable = g.Backfilled, g.Attempts, g.Unavailable
+			return gap
+		}
+	}
+	return gap
+}
@@ -0,0 +1,232 @@
+package bncvision
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+func TestScanOneDirGapReportIncremental(t *testing.T) {
+	root := t.TempDir()
+	rawDir := filepath.Join(root, "raw")
+	reportDir := filepath.Join(root, "missing")
+	if err := os.MkdirAll(rawDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	row := func(id int64) string {
+		aggTrade := bnc.AggTrades{Id: id, Price: 1, Qty: 1, FirstTradeId: id, LastTradeId: id, Time: 1609459200000 + id}
+		return aggTrade.CSVRow()
+	}
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-aggTrades-2021-01-01.csv"), []string{row(1), row(2), row(5)})
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-aggTrades-2021-01-02.csv"), []string{row(6), row(7)})
+
+	kind := AggTradesTidyKind("BTCUSDT", bnc.AggTradesTypeSpot)
+	report, err := ScanOneDirGapReport(rawDir, reportDir, kind, 2, time.Time{})
+	if err != nil {
+		t.Fatalf("ScanOneDirGapReport failed: %v", err)
+	}
+	if len(report.Gaps) != 1 || report.Gaps[0].StartId != 3 || report.Gaps[0].Size != 2 {
+		t.Fatalf("Expected one gap from 3 of size 2, got %+v", report.Gaps)
+	}
+	if report.Last == nil || report.Last.File != "BTCUSDT-aggTrades-2021-01-02.csv" || report.Last.NextId != 8 {
+		t.Fatalf("Unexpected last edge %+v", report.Last)
+	}
+	report.Gaps[0].Backfilled = true
+	if err := report.Save(reportDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// a broken old file proves it's not read again
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-aggTrades-2021-01-01.csv"), []string{"broken"})
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-aggTrades-2021-01-03.csv"), []string{row(10)})
+
+	report, err = ScanOneDirGapReport(rawDir, reportDir, kind, 2, time.Time{})
+	if err != nil {
+		t.Fatalf("Incremental ScanOneDirGapReport failed: %v", err)
+	}
+	if len(report.Gaps) != 2 {
+		t.Fatalf("Expected 2 gaps, got %+v", report.Gaps)
+	}
+	if !report.Gaps[0].Backfilled {
+		t.Errorf("Expected the first gap to stay backfilled")
+	}
+	gap := report.Gaps[1]
+	if gap.StartId != 8 || gap.EndId != 9 || gap.Size != 2 || gap.Backfilled ||
+		strings.Join(gap.Files, ",") != "BTCUSDT-aggTrades-2021-01-02.csv,BTCUSDT-aggTrades-2021-01-03.csv" {
+		t.Errorf("Unexpected gap between files %+v", gap)
+	}
+	if pending := report.Pending(); len(pending) != 1 || pending[0] != 1 {
+		t.Errorf("Expected gap 1 pending, got %v", pending)
+	}
+
+	loaded, err := LoadGapReport(reportDir)
+	if err != nil {
+		t.Fatalf("LoadGapReport failed: %v", err)
+	}
+	if loaded.Version != GAP_REPORT_VERSION || loaded.Kind != "aggTrades" || loaded.Symbol != "BTCUSDT" || len(loaded.Gaps) != 2 {
+		t.Errorf("Unexpected loaded report %+v", loaded)
+	}
+	data, err := os.ReadFile(filepath.Join(reportDir, GAP_REPORT_CSV_FILE_NAME))
+	if err != nil {
+		t.Fatalf("Failed to read csv report: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[2], "aggTrades,BTCUSDT,8,9,") || !strings.HasSuffix(lines[2], ",false") {
+		t.Errorf("Unexpected csv report %q", lines)
+	}
+
+	if _, err := ScanOneDirGapReport(rawDir, reportDir, AggTradesTidyKind("ETHUSDT", bnc.AggTradesTypeSpot), 1, time.Time{}); err == nil {
+		t.Errorf("Expected error for a report of another symbol")
+	}
+}
+
+func TestScanOneDirMissingsAndDownloadPartialBackfill(t *testing.T) {
+	root := t.TempDir()
+	rawDir := filepath.Join(root, "raw")
+	missingDir := filepath.Join(root, "missing")
+	if err := os.MkdirAll(rawDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	aggTrade := func(id int64) bnc.AggTrades {
+		return bnc.AggTrades{Id: id, Price: 1, Qty: 1, FirstTradeId: id, LastTradeId: id, Time: 1609459200000 + id}
+	}
+	row := func(id int64) string {
+		a := aggTrade(id)
+		return a.CSVRow()
+	}
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-aggTrades-2021-01-01.csv"), []string{row(1), row(6)})
+
+	kind := AggTradesTidyKind("BTCUSDT", bnc.AggTradesTypeSpot)
+	var backfilled []MissingRange
+	partial := true
+	kind.Backfill = func(missing MissingRange) ([]bnc.AggTrades, error) {
+		backfilled = append(backfilled, missing)
+		var aggTrades []bnc.AggTrades
+		for id := missing.StartId; id <= missing.EndId; id++ {
+			// the api returns some pages empty
+			if partial && id%2 == 1 {
+				continue
+			}
+			aggTrades = append(aggTrades, aggTrade(id))
+		}
+		return aggTrades, nil
+	}
+
+	if err := ScanOneDirMissingsAndDownload(rawDir, missingDir, kind, 1, time.Time{}); err != nil {
+		t.Fatalf("ScanOneDirMissingsAndDownload failed: %v", err)
+	}
+	report, err := LoadGapReport(missingDir)
+	if err != nil {
+		t.Fatalf("LoadGapReport failed: %v", err)
+	}
+	if len(report.Gaps) != 2 || len(report.Pending()) != 2 ||
+		report.Gaps[0].StartId != 3 || report.Gaps[0].EndId != 3 || report.Gaps[0].Size != 1 ||
+		report.Gaps[1].StartId != 5 || report.Gaps[1].EndId != 5 {
+		t.Fatalf("Expected the uncovered ids 3 and 5 pending, got %+v", report.Gaps)
+	}
+
+	partial = false
+	backfilled = nil
+	if err := ScanOneDirMissingsAndDownload(rawDir, missingDir, kind, 1, time.Time{}); err != nil {
+		t.Fatalf("ScanOneDirMissingsAndDownload failed: %v", err)
+	}
+	if len(backfilled) != 2 || backfilled[0].StartId != 3 || backfilled[1].StartId != 5 {
+		t.Errorf("Expected the uncovered ids to be back-filled again, got %+v", backfilled)
+	}
+	report, err = LoadGapReport(missingDir)
+	if err != nil {
+		t.Fatalf("LoadGapReport failed: %v", err)
+	}
+	if len(report.Pending()) != 0 {
+		t.Errorf("Expected all gaps backfilled, got %+v", report.Gaps)
+	}
+}
+
+func TestScanOneDirGapReportLateFile(t *testing.T) {
+	root := t.TempDir()
+	rawDir := filepath.Join(root, "raw")
+	reportDir := filepath.Join(root, "missing")
+	if err := os.MkdirAll(rawDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	row := func(id int64) string {
+		aggTrade := bnc.AggTrades{Id: id, Price: 1, Qty: 1, FirstTradeId: id, LastTradeId: id, Time: 1609459200000 + id}
+		return aggTrade.CSVRow()
+	}
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-aggTrades-2021-01-01.csv"), []string{row(1), row(2)})
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-aggTrades-2021-01-03.csv"), []string{row(8)})
+
+	kind := AggTradesTidyKind("BTCUSDT", bnc.AggTradesTypeSpot)
+	report, err := ScanOneDirGapReport(rawDir, reportDir, kind, 1, time.Time{})
+	if err != nil {
+		t.Fatalf("ScanOneDirGapReport failed: %v", err)
+	}
+	if len(report.Gaps) != 1 || report.Gaps[0].StartId != 3 || report.Gaps[0].EndId != 7 {
+		t.Fatalf("Expected one gap from 3 to 7, got %+v", report.Gaps)
+	}
+	report.Gaps[0].Backfilled = true
+	if err := report.Save(reportDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// the archive of the day between is published late
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-aggTrades-2021-01-02.csv"), []string{row(3), row(4)})
+
+	report, err = ScanOneDirGapReport(rawDir, reportDir, kind, 1, time.Time{})
+	if err != nil {
+		t.Fatalf("ScanOneDirGapReport failed: %v", err)
+	}
+	if len(report.Gaps) != 1 || report.Gaps[0].StartId != 5 || report.Gaps[0].EndId != 7 || !report.Gaps[0].Backfilled {
+		t.Errorf("Expected the rescanned gap from 5 to 7 to stay backfilled, got %+v", report.Gaps)
+	}
+	if len(report.ScannedFiles) != 3 {
+		t.Errorf("Expected 3 scanned files, got %v", report.ScannedFiles)
+	}
+}
+
+func TestScanOneDirMissingsAndDownloadUnavailable(t *testing.T) {
+	root := t.TempDir()
+	rawDir := filepath.Join(root, "raw")
+	missingDir := filepath.Join(root, "missing")
+	if err := os.MkdirAll(rawDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	row := func(id int64) string {
+		aggTrade := bnc.AggTrades{Id: id, Price: 1, Qty: 1, FirstTradeId: id, LastTradeId: id, Time: 1609459200000 + id}
+		return aggTrade.CSVRow()
+	}
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-aggTrades-2021-01-01.csv"), []string{row(1), row(4)})
+
+	kind := AggTradesTidyKind("BTCUSDT", bnc.AggTradesTypeSpot)
+	var backfills int
+	// an outage, the api never returns the missing items
+	kind.Backfill = func(MissingRange) ([]bnc.AggTrades, error) {
+		backfills++
+		return nil, nil
+	}
+
+	for i := 0; i < MAX_GAP_BACKFILL_ATTEMPTS+1; i++ {
+		if err := ScanOneDirMissingsAndDownload(rawDir, missingDir, kind, 1, time.Time{}); err != nil {
+			t.Fatalf("ScanOneDirMissingsAndDownload failed: %v", err)
+		}
+	}
+	if backfills != MAX_GAP_BACKFILL_ATTEMPTS {
+		t.Errorf("Expected %d back-fills, got %d", MAX_GAP_BACKFILL_ATTEMPTS, backfills)
+	}
+	report, err := LoadGapReport(missingDir)
+	if err != nil {
+		t.Fatalf("LoadGapReport failed: %v", err)
+	}
+	if len(report.Gaps) != 1 || report.Gaps[0].Backfilled || !report.Gaps[0].Unavailable ||
+		report.Gaps[0].Attempts != MAX_GAP_BACKFILL_ATTEMPTS || len(report.Pending()) != 0 {
+		t.Fatalf("Expected one unavailable gap, got %+v", report.Gaps)
+	}
+	if lines := strings.Split(strings.TrimSpace(report.CSV()), "\n"); len(lines) != 2 || !strings.HasSuffix(lines[1], ",false,3,true") {
+		t.Errorf("Unexpected csv %q", lines)
+	}
+}
+
@@ -1,13 +1,24 @@
 package bncvision
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dwdwow/cex/bnc"
 	"github.com/dwdwow/mathy"
+	"github.com/dwdwow/props"
+	"golang.org/x/sync/errgroup"
 )
 
 type KlineInterval string
@@ -218,75 +229,303 @@ type KlineVerifyResult struct {
 	OK            bool
 }
 
-// func VerifyKlines(klines []bnc.Kline, maxCpus int) (KlineVerifyResult, error) {
-// 	result := KlineVerifyResult{}
-
-// 	if len(klines) == 0 {
-// 		return KlineVerifyResult{}, errors.New("empty klines")
-// 	}
-
-// 	first := klines[0]
-
-// 	interval, err := CalKlineInterval(first)
-// 	if err != nil {
-// 		return result, err
-// 	}
-// 	result.Interval = interval
-
-// 	if maxCpus <= 0 {
-// 		maxCpus = 1
-// 	}
-
-// 	groups := props.DivideIntoGroups(klines, len(klines)/maxCpus)
-
-// 	for i, group := range groups[:len(groups)-1] {
-// 		if groups[i+1] == nil {
-// 			continue
-// 		}
-// 		nextOpenTime := group[len(group)-1].CloseTime + 1
-// 		nextGroupOpenTime := groups[i+1][0].OpenTime
-// 		if nextOpenTime == nextGroupOpenTime {
-// 			continue
-// 		}
-// 		missingTs, err := CalMissingKlineOpenTimes(nextOpenTime, nextGroupOpenTime, interval)
-// 		if err != nil {
-// 			return result, err
-// 		}
-// 		result.MissingTs = append(result.MissingTs, missingTs...)
-// 	}
-
-// 	wg := errgroup.Group{}
-// 	wg.SetLimit(maxCpus)
-// 	mu := sync.Mutex{}
-
-// 	for _, group := range groups {
-// 		group := group
-// 		if len(group) == 0 {
-// 			continue
-// 		}
-// 		wg.Go(func() error {
-// 			// TODO should check the kline is valid
-// 			for i, kline := range group[:len(group)-1] {
-// 				nextOpenTime := group[i+1].OpenTime
-// 				missingTs, err := CalMissingKlineOpenTimes(kline.OpenTime, nextOpenTime, interval)
-// 				if err != nil {
-// 					return err
-// 				}
-// 				mu.Lock()
-// 				result.MissingTs = append(result.MissingTs, missingTs...)
-// 				mu.Unlock()
-// 			}
-// 			return nil
-// 		})
-// 	}
-
-// 	if err := wg.Wait(); err != nil {
-// 		return result, err
-// 	}
-
-// 	if len(result.MissingTs) == 0 && len(result.InvalidKlines) == 0 {
-// 		result.OK = true
-// 	}
-
-// 	return result, nil
-// }
+// IsKlineValid checks the price and volume fields of a kline,
+// and whether its CloseTime lines up with the given interval.
+func IsKlineValid(kline bnc.Kline, interval KlineInterval) bool {
+	if kline.HighPrice < kline.LowPrice {
+		return false
+	}
+	if kline.OpenPrice > kline.HighPrice || kline.OpenPrice < kline.LowPrice {
+		return false
+	}
+	if kline.ClosePrice > kline.HighPrice || kline.ClosePrice < kline.LowPrice {
+		return false
+	}
+	if kline.Volume < 0 || kline.QuoteAssetVolume < 0 || kline.TakerBuyBaseAssetVolume < 0 || kline.TakerBuyQuoteAssetVolume < 0 {
+		return false
+	}
+	if kline.TradesNumber < 0 {
+		return false
+	}
+	klineInterval, err := CalKlineInterval(kline)
+	if err != nil {
+		return false
+	}
+	return klineInterval == interval
+}
+
+// VerifyKlines checks klines sorted by OpenTime.
+// The interval is calculated from the first kline,
+// missing open times are filled in MissingTs,
+// and klines that fail IsKlineValid are filled in InvalidKlines.
+func VerifyKlines(klines []bnc.Kline, maxCpus int) (KlineVerifyResult, error) {
+	result := KlineVerifyResult{}
+
+	if len(klines) == 0 {
+		return result, errors.New("empty klines")
+	}
+
+	interval, err := CalKlineInterval(klines[0])
+	if err != nil {
+		return result, err
+	}
+	result.Interval = interval
+
+	if maxCpus <= 0 {
+		maxCpus = 1
+	}
+
+	perGroupNum := len(klines) / maxCpus
+	if perGroupNum == 0 {
+		perGroupNum = 1
+	}
+
+	groups := props.DivideIntoGroups(klines, perGroupNum)
+
+	for i, group := range groups[:len(groups)-1] {
+		lastOpenTime := group[len(group)-1].OpenTime
+		nextGroupOpenTime := groups[i+1][0].OpenTime
+		missingTs, err := CalMissingKlineOpenTimes(lastOpenTime, nextGroupOpenTime, interval)
+		if err != nil {
+			return result, err
+		}
+		result.MissingTs = append(result.MissingTs, missingTs...)
+	}
+
+	wg := errgroup.Group{}
+	wg.SetLimit(maxCpus)
+	mu := sync.Mutex{}
+
+	for _, group := range groups {
+		group := group
+		wg.Go(func() error {
+			var invalidKlines []bnc.Kline
+			var missingTs []int64
+			for i, kline := range group {
+				if !IsKlineValid(kline, interval) {
+					invalidKlines = append(invalidKlines, kline)
+				}
+				if i == len(group)-1 {
+					break
+				}
+				ts, err := CalMissingKlineOpenTimes(kline.OpenTime, group[i+1].OpenTime, interval)
+				if err != nil {
+					return err
+				}
+				missingTs = append(missingTs, ts...)
+			}
+			mu.Lock()
+			result.InvalidKlines = append(result.InvalidKlines, invalidKlines...)
+			result.MissingTs = append(result.MissingTs, missingTs...)
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return result, err
+	}
+
+	sort.Slice(result.InvalidKlines, func(i, j int) bool {
+		return result.InvalidKlines[i].OpenTime < result.InvalidKlines[j].OpenTime
+	})
+	sort.Slice(result.MissingTs, func(i, j int) bool {
+		return result.MissingTs[i] < result.MissingTs[j]
+	})
+
+	if len(result.MissingTs) == 0 && len(result.InvalidKlines) == 0 {
+		result.OK = true
+	}
+
+	return result, nil
+}
+
+// VerifyOneDirKlines verifies all kline csv files in one directory,
+// such as klines/<SYMBOL>/<interval>, including the gaps between files.
+func VerifyOneDirKlines(dir string, maxCpus int) (KlineVerifyResult, error) {
+	result := KlineVerifyResult{}
+
+	if maxCpus <= 0 {
+		maxCpus = 1
+	}
+
+	var validFiles []string
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return result, err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".csv") {
+			validFiles = append(validFiles, file.Name())
+		}
+	}
+
+	sort.Slice(validFiles, func(i, j int) bool {
+		return validFiles[i] < validFiles[j]
+	})
+
+	wg := errgroup.Group{}
+	wg.SetLimit(maxCpus)
+
+	results := make([]KlineVerifyResult, len(validFiles))
+	edges := make([][2]bnc.Kline, len(validFiles))
+
+	for i, file := range validFiles {
+		i, file := i, file
+		wg.Go(func() error {
+			filePath := filepath.Join(dir, file)
+			slog.Info("Reading CSV To Structs", "file", file)
+			klines, err := ReadLinesToStructs(filePath, KlineLineToStruct)
+			if err != nil {
+				slog.Error("Read CSV To Structs", "file", file, "error", err)
+				return err
+			}
+			slog.Info("Read CSV To Structs", "file", file, "len", len(klines))
+			if len(klines) == 0 {
+				slog.Info("ReadCSVToStructs Skip", "file", file, "len", len(klines))
+				return nil
+			}
+			slog.Info("Verifying Klines", "file", file)
+			r, err := VerifyKlines(klines, 1)
+			if err != nil {
+				slog.Error("Verify Klines", "file", file, "error", err)
+				return err
+			}
+			if !r.OK {
+				slog.Warn("Invalid Klines", "file", file, "invalid", len(r.InvalidKlines), "missing", len(r.MissingTs))
+			}
+			slog.Info("Verified Klines", "file", file)
+			results[i] = r
+			edges[i] = [2]bnc.Kline{klines[0], klines[len(klines)-1]}
+			return nil
+		})
+	}
+
+	err = wg.Wait()
+	if err != nil {
+		return result, err
+	}
+
+	var prev *bnc.Kline
+	for i, r := range results {
+		if r.Interval == "" {
+			continue
+		}
+		if result.Interval == "" {
+			result.Interval = r.Interval
+		}
+		if r.Interval != result.Interval {
+			return result, fmt.Errorf("kline file %s interval %s is different from %s", validFiles[i], r.Interval, result.Interval)
+		}
+		if prev != nil {
+			missingTs, err := CalMissingKlineOpenTimes(prev.OpenTime, edges[i][0].OpenTime, result.Interval)
+			if err != nil {
+				return result, err
+			}
+			result.MissingTs = append(result.MissingTs, missingTs...)
+		}
+		result.InvalidKlines = append(result.InvalidKlines, r.InvalidKlines...)
+		result.MissingTs = append(result.MissingTs, r.MissingTs...)
+		prev = &edges[i][1]
+	}
+
+	sort.Slice(result.MissingTs, func(i, j int) bool {
+		return result.MissingTs[i] < result.MissingTs[j]
+	})
+
+	if len(result.MissingTs) == 0 && len(result.InvalidKlines) == 0 {
+		result.OK = true
+	}
+
+	return result, nil
+}
+
+// Kline apis of markets, whose klines can be downloaded by DownloadMissingKlines.
+const (
+	SPOT_KLINES_URL       = "https://api.binance.com/api/v3/klines"
+	UM_FUTURES_KLINES_URL = "https://fapi.binance.com/fapi/v1/klines"
+)
+
+var klinesAPIURLs = map[Market]string{
+	MarketSpot:      SPOT_KLINES_URL,
+	MarketFuturesUM: UM_FUTURES_KLINES_URL,
+}
+
+// queryKlines queries at most 1000 klines whose open times are from start to end in milliseconds.
+func (c *Client) queryKlines(apiURL, symbol string, interval bnc.KlineInterval, start, end int64) ([]bnc.Kline, error) {
+	query := url.Values{}
+	query.Set("symbol", symbol)
+	query.Set("interval", string(interval))
+	query.Set("startTime", strconv.FormatInt(start, 10))
+	query.Set("endTime", strconv.FormatInt(end, 10))
+	query.Set("limit", "1000")
+	reqURL := apiURL + "?" + query.Encode()
+	resp, err := c.get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPStatusError(reqURL, resp)
+	}
+	var raws []bnc.RawKline
+	if err := json.NewDecoder(resp.Body).Decode(&raws); err != nil {
+		return nil, err
+	}
+	klines := make([]bnc.Kline, 0, len(raws))
+	for _, raw := range raws {
+		kline, err := bnc.UnmarshalRawKline(raw)
+		if err != nil {
+			return nil, err
+		}
+		klines = append(klines, kline)
+	}
+	return klines, nil
+}
+
+// DownloadMissingKlines downloads the klines whose open times are from missing.StartId to missing.EndId by DefaultClient,
+// see Client.DownloadMissingKlines.
+func DownloadMissingKlines(market Market, symbol string, interval KlineInterval, missing MissingRange) (klines []bnc.Kline, err error) {
+	return DefaultClient.DownloadMissingKlines(market, symbol, interval, missing)
+}
+
+// DownloadMissingKlines downloads the klines whose open times are from missing.StartId to missing.EndId, sorted by open time.
+// Open times in microseconds are queried in milliseconds, and klines are returned in milliseconds.
+// Every page is attempted at most MISSING_AGG_TRADES_TRY_COUNT times with c.Retry between attempts,
+// permanent errors are never retried.
+// Only spot and USDⓈ-M futures klines are supported.
+func (c *Client) DownloadMissingKlines(market Market, symbol string, interval KlineInterval, missing MissingRange) (klines []bnc.Kline, err error) {
+	apiURL, ok := klinesAPIURLs[market]
+	if !ok {
+		return nil, fmt.Errorf("%w: klines api of market %q is not supported", ErrInvalidDatasetSpec, market)
+	}
+	bncInterval, ok := KlineIntervalToBncKlineInterval[interval]
+	if !ok {
+		return nil, ErrKlineIntervalNotSupported
+	}
+
+	start, end := rowTimeToMilli(missing.StartId), rowTimeToMilli(missing.EndId)
+	for start <= end {
+		var ks []bnc.Kline
+		ks, err = retryQuery(c.Retry, MISSING_AGG_TRADES_TRY_COUNT, func() ([]bnc.Kline, error) {
+			return c.queryKlines(apiURL, symbol, bncInterval, start, end)
+		}, "Query klines attempt failed, retrying", "symbol", symbol, "start", start)
+		if err != nil {
+			return
+		}
+		if len(ks) == 0 {
+			break
+		}
+		for _, k := range ks {
+			if k.OpenTime > end {
+				break
+			}
+			klines = append(klines, k)
+		}
+		start = ks[len(ks)-1].OpenTime + 1
+	}
+	sort.Slice(klines, func(i, j int) bool {
+		return klines[i].OpenTime < klines[j].OpenTime
+	})
+	return
+}
@@ -0,0 +1,280 @@
+package bncvision
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+// Kline fields compared by CompareKlines.
+const (
+	KlineFieldOpen                = "open"
+	KlineFieldHigh                = "high"
+	KlineFieldLow                 = "low"
+	KlineFieldClose               = "close"
+	KlineFieldVolume              = "volume"
+	KlineFieldQuoteVolume         = "quote_volume"
+	KlineFieldTakerBuyVolume      = "taker_buy_volume"
+	KlineFieldTakerBuyQuoteVolume = "taker_buy_quote_volume"
+	KlineFieldTradesNumber        = "trades_number"
+)
+
+var klineCompareFields = []string{
+	KlineFieldOpen, KlineFieldHigh, KlineFieldLow, KlineFieldClose,
+	KlineFieldVolume, KlineFieldQuoteVolume, KlineFieldTakerBuyVolume, KlineFieldTakerBuyQuoteVolume,
+	KlineFieldTradesNumber,
+}
+
+// KlineTolerance is the max absolute differences of kline fields which are regarded as equal.
+type KlineTolerance struct {
+	// Price is the tolerance of open, high, low and close prices.
+	Price float64
+	// Volume is the tolerance of volume and taker buy volume.
+	Volume float64
+	// QuoteVolume is the tolerance of quote volume and taker buy quote volume.
+	QuoteVolume float64
+	// TradesNumber is the tolerance of trades number.
+	TradesNumber int64
+}
+
+// DefaultKlineTolerance allows the rounding errors of 8 decimals, which are the precision of binance vision klines.
+var DefaultKlineTolerance = KlineTolerance{Price: 1e-8, Volume: 1e-8, QuoteVolume: 1e-6}
+
+// KlineFieldDiff is a field of two klines whose difference is out of tolerance.
+type KlineFieldDiff struct {
+	Field    string  `json:"field"`
+	Official float64 `json:"official"`
+	Rebuilt  float64 `json:"rebuilt"`
+}
+
+// KlineMismatch is a kline which is different between the official and the rebuilt klines,
+// or which is only in one of them.
+type KlineMismatch struct {
+	OpenTime int64 `json:"openTime"`
+	// Official is nil if the kline is only in the rebuilt klines.
+	Official *bnc.Kline `json:"official"`
+	// Rebuilt is nil if the kline is only in the official klines.
+	Rebuilt *bnc.Kline       `json:"rebuilt"`
+	Diffs   []KlineFieldDiff `json:"diffs"`
+}
+
+// KlineCompareDay is the comparison of the klines of one UTC day.
+type KlineCompareDay struct {
+	Day time.Time `json:"day"`
+	// Compared is the number of klines in both the official and the rebuilt klines.
+	Compared     int `json:"compared"`
+	OfficialOnly int `json:"officialOnly"`
+	RebuiltOnly  int `json:"rebuiltOnly"`
+	// Fields is the number of mismatching klines of every field.
+	Fields     map[string]int  `json:"fields"`
+	Mismatches []KlineMismatch `json:"mismatches"`
+}
+
+// KlineCompareReport is the day by day comparison of the official and the rebuilt klines.
+type KlineCompareReport struct {
+	Tolerance KlineTolerance    `json:"tolerance"`
+	Days      []KlineCompareDay `json:"days"`
+}
+
+// Mismatched returns the days with mismatching klines.
+func (r KlineCompareReport) Mismatched() []KlineCompareDay {
+	var days []KlineCompareDay
+	for _, day := range r.Days {
+		if len(day.Mismatches) > 0 {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+// CSV returns the summary of every day as csv with header,
+// including the number of mismatching klines of every field.
+func (r KlineCompareReport) CSV() string {
+	sb := &strings.Builder{}
+	w := csv.NewWriter(sb)
+	header := []string{"day", "compared", "official_only", "rebuilt_only", "mismatched"}
+	_ = w.Write(append(header, klineCompareFields...))
+	for _, day := range r.Days {
+		row := []string{
+			day.Day.Format(time.DateOnly),
+			strconv.Itoa(day.Compared),
+			strconv.Itoa(day.OfficialOnly),
+			strconv.Itoa(day.RebuiltOnly),
+			strconv.Itoa(len(day.Mismatches)),
+		}
+		for _, field := range klineCompareFields {
+			row = append(row, strconv.Itoa(day.Fields[field]))
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	return sb.String()
+}
+
+// diffKlines returns the fields of official and rebuilt whose differences are out of tolerance.
+func diffKlines(official, rebuilt bnc.Kline, tolerance KlineTolerance) []KlineFieldDiff {
+	var diffs []KlineFieldDiff
+	for _, f := range []struct {
+		field             string
+		official, rebuilt float64
+		tolerance         float64
+	}{
+		{KlineFieldOpen, official.OpenPrice, rebuilt.OpenPrice, tolerance.Price},
+		{KlineFieldHigh, official.HighPrice, rebuilt.HighPrice, tolerance.Price},
+		{KlineFieldLow, official.LowPrice, rebuilt.LowPrice, tolerance.Price},
+		{KlineFieldClose, official.ClosePrice, rebuilt.ClosePrice, tolerance.Price},
+		{KlineFieldVolume, official.Volume, rebuilt.Volume, tolerance.Volume},
+		{KlineFieldQuoteVolume, official.QuoteAssetVolume, rebuilt.QuoteAssetVolume, tolerance.QuoteVolume},
+		{KlineFieldTakerBuyVolume, official.TakerBuyBaseAssetVolume, rebuilt.TakerBuyBaseAssetVolume, tolerance.Volume},
+		{KlineFieldTakerBuyQuoteVolume, official.TakerBuyQuoteAssetVolume, rebuilt.TakerBuyQuoteAssetVolume, tolerance.QuoteVolume},
+		{KlineFieldTradesNumber, float64(official.TradesNumber), float64(rebuilt.TradesNumber), float64(tolerance.TradesNumber)},
+	} {
+		if math.Abs(f.official-f.rebuilt) > f.tolerance {
+			diffs = append(diffs, KlineFieldDiff{Field: f.field, Official: f.official, Rebuilt: f.rebuilt})
+		}
+	}
+	return diffs
+}
+
+// ErrKlinesNotOverlapped is returned if the official and the rebuilt klines have no time range in common,
+// an empty report would read as no mismatches.
+var ErrKlinesNotOverlapped = errors.New("official and rebuilt klines do not overlap")
+
+// CompareKlines aligns the official and the rebuilt klines, sorted or not, by OpenTime,
+// and compares them field by field with tolerance.
+// Only the overlapping time range of both is compared, so that the edges of different time ranges are not reported.
+// ErrKlinesNotOverlapped is returned if there is no overlapping time range, such as one of them is empty.
+func CompareKlines(official, rebuilt []bnc.Kline, tolerance KlineTolerance) (KlineCompareReport, error) {
+	report := KlineCompareReport{Tolerance: tolerance}
+	if len(official) == 0 || len(rebuilt) == 0 {
+		return report, ErrKlinesNotOverlapped
+	}
+
+	start := max(minKlineOpenTime(official), minKlineOpenTime(rebuilt))
+	end := min(maxKlineOpenTime(official), maxKlineOpenTime(rebuilt))
+	if start > end {
+		return report, fmt.Errorf("%w: official from %d to %d, rebuilt from %d to %d", ErrKlinesNotOverlapped,
+			minKlineOpenTime(official), maxKlineOpenTime(official), minKlineOpenTime(rebuilt), maxKlineOpenTime(rebuilt))
+	}
+
+	officials := make(map[int64]bnc.Kline, len(official))
+	for _, kline := range official {
+		officials[kline.OpenTime] = kline
+	}
+	rebuilts := make(map[int64]bnc.Kline, len(rebuilt))
+	for _, kline := range rebuilt {
+		rebuilts[kline.OpenTime] = kline
+	}
+
+	var openTimes []int64
+	for openTime := range officials {
+		openTimes = append(openTimes, openTime)
+	}
+	for openTime := range rebuilts {
+		if _, ok := officials[openTime]; !ok {
+			openTimes = append(openTimes, openTime)
+		}
+	}
+	sort.Slice(openTimes, func(i, j int) bool {
+		return openTimes[i] < openTimes[j]
+	})
+
+	var day *KlineCompareDay
+	for _, openTime := range openTimes {
+		if openTime < start || openTime > end {
+			continue
+		}
+		d := truncateDay(time.UnixMilli(openTime))
+		if day == nil || !day.Day.Equal(d) {
+			report.Days = append(report.Days, KlineCompareDay{Day: d, Fields: map[string]int{}})
+			day = &report.Days[len(report.Days)-1]
+		}
+
+		o, okOfficial := officials[openTime]
+		r, okRebuilt := rebuilts[openTime]
+		switch {
+		case !okRebuilt:
+			day.OfficialOnly++
+			day.Mismatches = append(day.Mismatches, KlineMismatch{OpenTime: openTime, Official: &o})
+		case !okOfficial:
+			day.RebuiltOnly++
+			day.Mismatches = append(day.Mismatches, KlineMismatch{OpenTime: openTime, Rebuilt: &r})
+		default:
+			day.Compared++
+			diffs := diffKlines(o, r, tolerance)
+			if len(diffs) == 0 {
+				continue
+			}
+			for _, diff := range diffs {
+				day.Fields[diff.Field]++
+			}
+			day.Mismatches = append(day.Mismatches, KlineMismatch{OpenTime: openTime, Official: &o, Rebuilt: &r, Diffs: diffs})
+		}
+	}
+
+	return report, nil
+}
+
+func minKlineOpenTime(klines []bnc.Kline) int64 {
+	t := klines[0].OpenTime
+	for _, kline := range klines[1:] {
+		t = min(t, kline.OpenTime)
+	}
+	return t
+}
+
+func maxKlineOpenTime(klines []bnc.Kline) int64 {
+	t := klines[0].OpenTime
+	for _, kline := range klines[1:] {
+		t = max(t, kline.OpenTime)
+	}
+	return t
+}
+
+// CompareOneDirKlines rebuilds klines of interval from the tidy agg trades csv files of aggTradesDir,
+// see OneDirAggTradesToInnerDayKlines, and compares them with the official kline csv files of klinesDir,
+// such as klines/<SYMBOL>/1m, see CompareKlines.
+// Official times in microseconds are converted to milliseconds.
+func CompareOneDirKlines(klinesDir, aggTradesDir string, interval time.Duration, tolerance KlineTolerance, maxCpus int) (KlineCompareReport, error) {
+	files, err := tidyDirFiles(klinesDir, time.Time{})
+	if err != nil {
+		return KlineCompareReport{}, err
+	}
+	var official []bnc.Kline
+	for _, file := range files {
+		klines, err := ReadLinesToStructs(filepath.Join(klinesDir, file), KlineLineToStruct)
+		if err != nil {
+			gLogger.Error("Read CSV To Structs", "file", file, "error", err)
+			return KlineCompareReport{}, err
+		}
+		for _, kline := range klines {
+			kline.OpenTime = rowTimeToMilli(kline.OpenTime)
+			kline.CloseTime = rowTimeToMilli(kline.CloseTime)
+			official = append(official, kline)
+		}
+	}
+
+	rebuiltKlines, err := OneDirAggTradesToInnerDayKlines(aggTradesDir, interval, maxCpus)
+	if err != nil {
+		return KlineCompareReport{}, err
+	}
+	rebuilt := make([]bnc.Kline, len(rebuiltKlines))
+	for i, kline := range rebuiltKlines {
+		rebuilt[i] = *kline
+	}
+
+	report, err := CompareKlines(official, rebuilt, tolerance)
+	if err != nil {
+		return report, err
+	}
+	gLogger.Info("Compared Klines", "official", len(official), "rebuilt", len(rebuilt), "mismatchedDays", len(report.Mismatched()))
+	return report, nil
+}
@@ -0,0 +1,112 @@
+package bncvision
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+func TestCompareKlines(t *testing.T) {
+	start := int64(1609459200000) // 2021-01-01
+	day := KlineIntervalToMilli[Kline1d]
+	var official, rebuilt []bnc.Kline
+	for _, openTime := range []int64{start, start + 60000, start + 120000, start + day, start + day + 60000} {
+		kline := newTestKline(openTime)
+		official = append(official, kline)
+		rebuilt = append(rebuilt, kline)
+	}
+	rebuilt[1].HighPrice = 102
+	rebuilt[1].Volume = 1 + 1e-9
+	rebuilt[3].TradesNumber = 1
+	// missing in the rebuilt klines
+	rebuilt = append(rebuilt[:2], rebuilt[3:]...)
+	// out of the time range of the official klines
+	rebuilt = append(rebuilt, newTestKline(start+2*day))
+
+	report, err := CompareKlines(official, rebuilt, DefaultKlineTolerance)
+	if err != nil {
+		t.Fatalf("CompareKlines failed: %v", err)
+	}
+	if len(report.Days) != 2 {
+		t.Fatalf("Expected 2 days, got %d", len(report.Days))
+	}
+	first := report.Days[0]
+	if first.Compared != 2 || first.OfficialOnly != 1 || first.RebuiltOnly != 0 || len(first.Mismatches) != 2 {
+		t.Errorf("Unexpected first day %+v", first)
+	}
+	if diffs := first.Mismatches[0].Diffs; len(diffs) != 1 || diffs[0].Field != KlineFieldHigh || diffs[0].Rebuilt != 102 {
+		t.Errorf("Unexpected diffs %+v", diffs)
+	}
+	if first.Mismatches[1].Rebuilt != nil || first.Mismatches[1].OpenTime != start+120000 {
+		t.Errorf("Expected the kline only in the official klines, got %+v", first.Mismatches[1])
+	}
+	if second := report.Days[1]; second.Compared != 2 || second.Fields[KlineFieldTradesNumber] != 1 {
+		t.Errorf("Unexpected second day %+v", second)
+	}
+
+	report, err = CompareKlines(official, rebuilt, KlineTolerance{Price: 1, Volume: 1e-8, TradesNumber: 1})
+	if err != nil {
+		t.Fatalf("CompareKlines failed: %v", err)
+	}
+	if mismatched := report.Mismatched(); len(mismatched) != 1 || len(mismatched[0].Mismatches) != 1 {
+		t.Errorf("Expected only the missing kline to mismatch, got %+v", mismatched)
+	}
+
+	lines := strings.Split(strings.TrimSpace(report.CSV()), "\n")
+	if len(lines) != 3 || lines[1] != "2021-01-01,2,1,0,1,0,0,0,0,0,0,0,0,0" {
+		t.Errorf("Unexpected csv %q", lines)
+	}
+
+	if _, err := CompareKlines(official[:1], official[1:], DefaultKlineTolerance); !errors.Is(err, ErrKlinesNotOverlapped) {
+		t.Errorf("Expected ErrKlinesNotOverlapped, got %v", err)
+	}
+	if _, err := CompareKlines(official, nil, DefaultKlineTolerance); !errors.Is(err, ErrKlinesNotOverlapped) {
+		t.Errorf("Expected ErrKlinesNotOverlapped for empty rebuilt klines, got %v", err)
+	}
+}
+
+func TestCompareOneDirKlines(t *testing.T) {
+	klinesDir := t.TempDir()
+	aggTradesDir := t.TempDir()
+	start := int64(1609459200000) // 2021-01-01
+
+	var rows []string
+	for i := int64(0); i < 4; i++ {
+		// newer spot agg trades are in microseconds
+		aggTrade := bnc.AggTrades{Id: i + 1, Price: 10, Qty: 1, FirstTradeId: i + 1, LastTradeId: i + 1, Time: (start + i*30000) * 1000}
+		rows = append(rows, aggTrade.CSVRow())
+	}
+	writeTestRows(t, filepath.Join(aggTradesDir, "BTCUSDT-aggTrades-2021-01-01.csv"), rows)
+
+	kline := bnc.Kline{
+		OpenTime: start, CloseTime: start + 59999,
+		OpenPrice: 10, HighPrice: 10, LowPrice: 10, ClosePrice: 10,
+		Volume: 2, QuoteAssetVolume: 20, TradesNumber: 2,
+		TakerBuyBaseAssetVolume: 2, TakerBuyQuoteAssetVolume: 20,
+	}
+	next := kline
+	next.OpenTime += 60000
+	next.CloseTime += 60000
+	next.TradesNumber = 3
+	// official times in microseconds
+	micro := next
+	micro.OpenTime *= 1000
+	micro.CloseTime = micro.CloseTime*1000 + 999
+	writeTestRows(t, filepath.Join(klinesDir, "BTCUSDT-1m-2021-01-01.csv"), []string{BncKlineToCSVRaw(kline), BncKlineToCSVRaw(micro)})
+
+	report, err := CompareOneDirKlines(klinesDir, aggTradesDir, time.Minute, DefaultKlineTolerance, 2)
+	if err != nil {
+		t.Fatalf("CompareOneDirKlines failed: %v", err)
+	}
+	if len(report.Days) != 1 || report.Days[0].Compared != 2 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	mismatches := report.Days[0].Mismatches
+	if len(mismatches) != 1 || mismatches[0].OpenTime != next.OpenTime || len(mismatches[0].Diffs) != 1 || mismatches[0].Diffs[0].Field != KlineFieldTradesNumber {
+		t.Errorf("Unexpected mismatches %+v", mismatches)
+	}
+}
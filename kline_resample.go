@@ -0,0 +1,159 @@
+package bncvision
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+	"github.com/dwdwow/mathy"
+)
+
+// weekOffsetMilli is the offset of the first monday, 1970-01-05, from the unix epoch, which is a thursday.
+// Weekly klines of binance open on mondays.
+var weekOffsetMilli = 4 * KlineIntervalToMilli[Kline1d]
+
+// ResampledKline is a kline aggregated from finer klines.
+type ResampledKline struct {
+	bnc.Kline
+	// KlinesNumber is the number of source klines in the bucket.
+	KlinesNumber int64
+	// ExpectedKlinesNumber is the number of source klines of a complete bucket.
+	ExpectedKlinesNumber int64
+}
+
+// Partial reports whether some source klines of the bucket are missing,
+// such as the edge buckets of klines which do not start or end on bucket boundaries,
+// or buckets with missing klines inside.
+func (k ResampledKline) Partial() bool {
+	return k.KlinesNumber < k.ExpectedKlinesNumber
+}
+
+// klineBucketFunc returns the bucket [start, end) of openTime, in milliseconds.
+type klineBucketFunc func(openTime int64) (start, end int64)
+
+// fixedKlineBuckets returns buckets of milli milliseconds aligned to the unix epoch,
+// or aligned to mondays if milli is a multiple of a week.
+func fixedKlineBuckets(milli int64) klineBucketFunc {
+	if milli%KlineIntervalToMilli[Kline1w] == 0 {
+		return anchoredKlineBuckets(milli, weekOffsetMilli)
+	}
+	return anchoredKlineBuckets(milli, 0)
+}
+
+// anchoredKlineBuckets returns buckets of milli milliseconds, one of which opens at anchor.
+func anchoredKlineBuckets(milli, anchor int64) klineBucketFunc {
+	return func(openTime int64) (int64, int64) {
+		n := openTime - anchor
+		start := n - n%milli
+		if n%milli < 0 {
+			start -= milli
+		}
+		return start + anchor, start + anchor + milli
+	}
+}
+
+// monthKlineBuckets returns buckets of calendar months in UTC.
+func monthKlineBuckets(openTime int64) (int64, int64) {
+	start := truncateMonth(time.UnixMilli(openTime))
+	return start.UnixMilli(), start.AddDate(0, 1, 0).UnixMilli()
+}
+
+// ResampleKlines aggregates klines, which are sorted by OpenTime and of the same interval, to a coarser interval.
+// Buckets are aligned to the unix epoch, except that Kline1w is aligned to mondays and Kline1mo to the first days of months, all in UTC.
+// The interval must be a multiple of the interval of klines, and Kline1mo needs klines of one day or less.
+// Buckets without any kline are not returned, and buckets with missing klines are Partial.
+// Klines in microseconds, such as spot klines since 2025, are resampled to klines in milliseconds.
+func ResampleKlines(klines []bnc.Kline, interval KlineInterval) ([]ResampledKline, error) {
+	if interval == Kline1mo {
+		return resampleKlines(klines, KlineIntervalToMilli[Kline1d], monthKlineBuckets)
+	}
+	milli, ok := KlineIntervalToMilli[interval]
+	if !ok {
+		return nil, ErrKlineIntervalNotSupported
+	}
+	return resampleKlines(klines, milli, fixedKlineBuckets(milli))
+}
+
+// ResampleKlinesByDuration is the same as ResampleKlines, with any duration, such as 7m.
+// Durations of whole weeks are aligned to mondays, and others are aligned to the unix epoch.
+func ResampleKlinesByDuration(klines []bnc.Kline, du time.Duration) ([]ResampledKline, error) {
+	milli := du.Milliseconds()
+	if milli <= 0 {
+		return nil, fmt.Errorf("duration %s is not positive", du)
+	}
+	return resampleKlines(klines, milli, fixedKlineBuckets(milli))
+}
+
+// klineTimesToMilli returns kline with OpenTime and CloseTime in milliseconds,
+// newer spot klines are in microseconds.
+func klineTimesToMilli(kline bnc.Kline) bnc.Kline {
+	kline.OpenTime = rowTimeToMilli(kline.OpenTime)
+	kline.CloseTime = rowTimeToMilli(kline.CloseTime)
+	return kline
+}
+
+// resampleKlines aggregates klines into the buckets of bucketOf.
+// Times of klines may be in microseconds, the resampled klines are in milliseconds.
+// step is a length which every bucket is a multiple of, so it must be a multiple of the interval of klines.
+func resampleKlines(klines []bnc.Kline, step int64, bucketOf klineBucketFunc) ([]ResampledKline, error) {
+	if len(klines) == 0 {
+		return nil, nil
+	}
+
+	interval, err := CalKlineInterval(klineTimesToMilli(klines[0]))
+	if err != nil {
+		return nil, err
+	}
+	if interval == Kline1mo {
+		return nil, fmt.Errorf("can not resample klines of %s: %w", interval, ErrKlineIntervalNotSupported)
+	}
+	milli := KlineIntervalToMilli[interval]
+	if step%milli != 0 {
+		return nil, fmt.Errorf("can not resample klines of %s to buckets of %dms", interval, step)
+	}
+
+	var resampled []ResampledKline
+	var kline *ResampledKline
+	var bucketEnd int64
+
+	for i, k := range klines {
+		k = klineTimesToMilli(k)
+		if k.CloseTime+1-k.OpenTime != milli {
+			return nil, fmt.Errorf("kline of open time %d is not %s", k.OpenTime, interval)
+		}
+		if i > 0 && k.OpenTime <= rowTimeToMilli(klines[i-1].OpenTime) {
+			return nil, fmt.Errorf("klines are not sorted by open time at %d", k.OpenTime)
+		}
+
+		if kline == nil || k.OpenTime >= bucketEnd {
+			if kline != nil {
+				resampled = append(resampled, *kline)
+			}
+			var bucketStart int64
+			bucketStart, bucketEnd = bucketOf(k.OpenTime)
+			kline = &ResampledKline{
+				Kline: bnc.Kline{
+					OpenTime:  bucketStart,
+					CloseTime: bucketEnd - 1,
+					OpenPrice: k.OpenPrice,
+					HighPrice: k.HighPrice,
+					LowPrice:  k.LowPrice,
+				},
+				ExpectedKlinesNumber: (bucketEnd - bucketStart) / milli,
+			}
+		}
+
+		kline.HighPrice = math.Max(kline.HighPrice, k.HighPrice)
+		kline.LowPrice = math.Min(kline.LowPrice, k.LowPrice)
+		kline.ClosePrice = k.ClosePrice
+		kline.Volume = mathy.BN(kline.Volume).Add(mathy.BN(k.Volume)).Round(8).Float64()
+		kline.QuoteAssetVolume = mathy.BN(kline.QuoteAssetVolume).Add(mathy.BN(k.QuoteAssetVolume)).Round(8).Float64()
+		kline.TakerBuyBaseAssetVolume = mathy.BN(kline.TakerBuyBaseAssetVolume).Add(mathy.BN(k.TakerBuyBaseAssetVolume)).Round(8).Float64()
+		kline.TakerBuyQuoteAssetVolume = mathy.BN(kline.TakerBuyQuoteAssetVolume).Add(mathy.BN(k.TakerBuyQuoteAssetVolume)).Round(8).Float64()
+		kline.TradesNumber += k.TradesNumber
+		kline.KlinesNumber++
+	}
+
+	return append(resampled, *kline), nil
+}
@@ -0,0 +1,119 @@
+package bncvision
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+func TestResampleKlines(t *testing.T) {
+	start := int64(1609459200000) // 2021-01-01
+	var klines []bnc.Kline
+	for i := int64(0); i < 7; i++ {
+		if i == 4 {
+			continue
+		}
+		kline := newTestKline(start + i*60000)
+		kline.OpenPrice = float64(100 + i)
+		kline.ClosePrice = float64(100 + i)
+		kline.HighPrice = float64(101 + i)
+		kline.LowPrice = float64(99 + i)
+		kline.QuoteAssetVolume = 100
+		kline.TakerBuyBaseAssetVolume = 0.1
+		kline.TakerBuyQuoteAssetVolume = 10
+		kline.TradesNumber = 2
+		klines = append(klines, kline)
+	}
+
+	resampled, err := ResampleKlines(klines, Kline3m)
+	if err != nil {
+		t.Fatalf("ResampleKlines failed: %v", err)
+	}
+	if len(resampled) != 3 {
+		t.Fatalf("Expected 3 klines, got %d", len(resampled))
+	}
+	first := resampled[0]
+	if first.OpenTime != start || first.CloseTime != start+3*60000-1 || first.OpenPrice != 100 || first.HighPrice != 103 ||
+		first.LowPrice != 99 || first.ClosePrice != 102 || first.Volume != 3 || first.QuoteAssetVolume != 300 ||
+		first.TakerBuyBaseAssetVolume != 0.3 || first.TakerBuyQuoteAssetVolume != 30 || first.TradesNumber != 6 {
+		t.Errorf("Unexpected first kline %+v", first)
+	}
+	if first.Partial() {
+		t.Errorf("Expected the first kline to be complete")
+	}
+	if !resampled[1].Partial() || resampled[1].KlinesNumber != 2 || resampled[1].ExpectedKlinesNumber != 3 {
+		t.Errorf("Expected the second kline to miss one kline, got %+v", resampled[1])
+	}
+	if !resampled[2].Partial() || resampled[2].KlinesNumber != 1 {
+		t.Errorf("Expected the last kline to be partial, got %+v", resampled[2])
+	}
+
+	resampled, err = ResampleKlinesByDuration(klines, 7*time.Minute)
+	if err != nil {
+		t.Fatalf("ResampleKlinesByDuration failed: %v", err)
+	}
+	// 2021-01-01 00:00 is 5m after a 7m boundary of the epoch
+	if len(resampled) != 2 || resampled[0].OpenTime != start-5*60000 || resampled[0].KlinesNumber != 2 ||
+		resampled[1].OpenTime != start+2*60000 || resampled[1].KlinesNumber != 4 {
+		t.Errorf("Unexpected 7m klines %+v", resampled)
+	}
+
+	// microsecond open times, as published since 2025
+	var micros []bnc.Kline
+	for _, kline := range klines {
+		kline.OpenTime *= 1000
+		kline.CloseTime = kline.CloseTime*1000 + 999
+		micros = append(micros, kline)
+	}
+	fromMicros, err := ResampleKlines(micros, Kline3m)
+	if err != nil {
+		t.Fatalf("ResampleKlines of microsecond klines failed: %v", err)
+	}
+	if len(fromMicros) != 3 || fromMicros[0].Kline != first.Kline || fromMicros[1].KlinesNumber != 2 {
+		t.Errorf("Unexpected klines resampled from microsecond klines %+v", fromMicros)
+	}
+
+	if _, err := ResampleKlinesByDuration(klines, 90*time.Second); err == nil {
+		t.Errorf("Expected error for a duration which is not a multiple of 1m")
+	}
+	if _, err := ResampleKlines([]bnc.Kline{klines[1], klines[0]}, Kline3m); err == nil {
+		t.Errorf("Expected error for unsorted klines")
+	}
+}
+
+func TestResampleKlinesCalendar(t *testing.T) {
+	day := KlineIntervalToMilli[Kline1d]
+	start := time.Date(2024, 1, 29, 0, 0, 0, 0, time.UTC).UnixMilli() // monday
+	var klines []bnc.Kline
+	for i := int64(0); i < 10; i++ {
+		kline := newTestKline(start + i*day)
+		kline.CloseTime = kline.OpenTime + day - 1
+		klines = append(klines, kline)
+	}
+
+	weeks, err := ResampleKlines(klines, Kline1w)
+	if err != nil {
+		t.Fatalf("ResampleKlines failed: %v", err)
+	}
+	if len(weeks) != 2 || weeks[0].OpenTime != start || weeks[0].Partial() || weeks[1].OpenTime != start+7*day || !weeks[1].Partial() {
+		t.Errorf("Unexpected weekly klines %+v", weeks)
+	}
+
+	months, err := ResampleKlines(klines, Kline1mo)
+	if err != nil {
+		t.Fatalf("ResampleKlines failed: %v", err)
+	}
+	february := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	if len(months) != 2 || months[0].KlinesNumber != 3 || months[0].ExpectedKlinesNumber != 31 {
+		t.Fatalf("Unexpected monthly klines %+v", months)
+	}
+	if months[1].OpenTime != february || months[1].CloseTime != march-1 || months[1].ExpectedKlinesNumber != 29 {
+		t.Errorf("Unexpected february kline %+v", months[1])
+	}
+
+	if _, err := ResampleKlines(klines, Kline4h); err == nil {
+		t.Errorf("Expected error for an interval finer than klines")
+	}
+}
@@ -0,0 +1,152 @@
+package bncvision
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+// KlineSession describes the open times of klines, such as daily klines opening at 08:00 in UTC+8.
+// Sessions of one day or more open at Start after the local midnight of their first days,
+// Kline3d is aligned to 1970-01-01, Kline1w to mondays and Kline1mo to the first days of months.
+// Intra day klines are every interval from the open time of their sessions.
+type KlineSession struct {
+	// Interval is the interval of klines.
+	Interval KlineInterval
+	// Location is the timezone of sessions, UTC if nil.
+	// Use time.FixedZone for offsets without daylight saving time, such as time.FixedZone("UTC+8", 8*60*60).
+	// With daylight saving time, sessions are of calendar days, so they may be 23 or 25 hours.
+	Location *time.Location
+	// Start is the open time of sessions after the local midnight, from 0 to 24h.
+	Start time.Duration
+}
+
+// Validate returns an error if Interval is not supported or Start is not within one day.
+func (s KlineSession) Validate() error {
+	if _, ok := KlineIntervalToMilli[s.Interval]; !ok && s.Interval != Kline1mo {
+		return fmt.Errorf("kline session interval %q: %w", s.Interval, ErrKlineIntervalNotSupported)
+	}
+	if s.Start < 0 || s.Start >= 24*time.Hour {
+		return fmt.Errorf("kline session start %s is not within one day", s.Start)
+	}
+	return nil
+}
+
+func (s KlineSession) location() *time.Location {
+	if s.Location == nil {
+		return time.UTC
+	}
+	return s.Location
+}
+
+// open returns the open time of the session of the local date, which is normalized as time.Date.
+func (s KlineSession) open(year int, month time.Month, day int) int64 {
+	return time.Date(year, month, day, 0, 0, 0, 0, s.location()).Add(s.Start).UnixMilli()
+}
+
+// day returns the local date of the daily session of ts.
+func (s KlineSession) day(ts int64) (int, time.Month, int) {
+	year, month, day := time.UnixMilli(ts).In(s.location()).Date()
+	if ts < s.open(year, month, day) {
+		year, month, day = time.Date(year, month, day-1, 0, 0, 0, 0, time.UTC).Date()
+	}
+	return year, month, day
+}
+
+// bucketOf returns the kline [start, end) of ts, it's a klineBucketFunc.
+func (s KlineSession) bucketOf(ts int64) (int64, int64) {
+	year, month, day := s.day(ts)
+	if s.Interval == Kline1mo {
+		return s.open(year, month, 1), s.open(year, month+1, 1)
+	}
+
+	milli := KlineIntervalToMilli[s.Interval]
+	dayMilli := KlineIntervalToMilli[Kline1d]
+
+	if milli >= dayMilli {
+		days := milli / dayMilli
+		var offset int64
+		if days%7 == 0 {
+			offset = weekOffsetMilli / dayMilli
+		}
+		epochDays := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).UnixMilli() / dayMilli
+		k := (epochDays - offset) % days
+		if k < 0 {
+			k += days
+		}
+		return s.open(year, month, day-int(k)), s.open(year, month, day-int(k)+int(days))
+	}
+
+	dayOpen := s.open(year, month, day)
+	start := dayOpen + (ts-dayOpen)/milli*milli
+	return start, min(start+milli, s.open(year, month, day+1))
+}
+
+func newAggTradesSessionKlineMerger(session KlineSession) (*aggTradesKlineMerger, error) {
+	if err := session.Validate(); err != nil {
+		return nil, err
+	}
+	return &aggTradesKlineMerger{bucketOf: session.bucketOf}, nil
+}
+
+// AggTradesToSessionKlines merges agg trades, sorted by time, into klines of session.
+// The first and the last klines may only cover part of their sessions.
+func AggTradesToSessionKlines(aggTrades []bnc.AggTrades, session KlineSession) ([]*bnc.Kline, error) {
+	merger, err := newAggTradesSessionKlineMerger(session)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, aggTrade := range aggTrades {
+		merger.add(aggTrade)
+	}
+
+	return merger.mergedKlines(), nil
+}
+
+// OneDirAggTradesToSessionKlines merges the agg trades csv files of dir,
+// whose dates are not before the day of startTime, into klines of session.
+// Files are streamed one after another into one merger,
+// so klines across files, such as daily klines opening at 08:00 in UTC+8 or weekly klines, are merged as a whole.
+// The first and the last klines may only cover part of their sessions.
+func OneDirAggTradesToSessionKlines(dir string, session KlineSession, startTime time.Time) ([]*bnc.Kline, error) {
+	merger, err := newAggTradesSessionKlineMerger(session)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := tidyDirFiles(dir, startTime)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		gLogger.Info("Streaming Lines To Structs", "file", file)
+		stream, err := StreamLinesToStructs(context.Background(), filepath.Join(dir, file), AggTradeLineToStruct)
+		if err != nil {
+			gLogger.Error("Stream Lines To Structs", "file", file, "error", err)
+			return nil, err
+		}
+		var n int
+		for stream.Next() {
+			aggTrade := stream.Struct()
+			if !AggTradesReadFilter(aggTrade) {
+				continue
+			}
+			merger.add(aggTrade)
+			n++
+		}
+		err = stream.Err()
+		stream.Close()
+		if err != nil {
+			gLogger.Error("Merging Agg Trades To Klines", "file", file, "error", err)
+			return nil, err
+		}
+		gLogger.Info("Merged Agg Trades To Klines", "file", file, "aggTrades", n)
+	}
+
+	return merger.mergedKlines(), nil
+}
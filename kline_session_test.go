@@ -0,0 +1,111 @@
+package bncvision
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+func TestKlineSessionBuckets(t *testing.T) {
+	utc8 := time.FixedZone("UTC+8", 8*60*60)
+	ms := func(year int, month time.Month, day, hour, minute int) int64 {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC).UnixMilli()
+	}
+
+	tests := []struct {
+		name       string
+		session    KlineSession
+		ts         int64
+		start, end int64
+	}{
+		{"utc daily", KlineSession{Interval: Kline1d}, ms(2024, 1, 31, 12, 0), ms(2024, 1, 31, 0, 0), ms(2024, 2, 1, 0, 0)},
+		{"utc+8 daily", KlineSession{Interval: Kline1d, Location: utc8}, ms(2024, 1, 31, 16, 0), ms(2024, 1, 31, 16, 0), ms(2024, 2, 1, 16, 0)},
+		{"utc+8 daily before open", KlineSession{Interval: Kline1d, Location: utc8}, ms(2024, 1, 31, 15, 59), ms(2024, 1, 30, 16, 0), ms(2024, 1, 31, 16, 0)},
+		{"08:00 utc+8 daily", KlineSession{Interval: Kline1d, Location: utc8, Start: 8 * time.Hour}, ms(2024, 1, 31, 23, 59), ms(2024, 1, 31, 0, 0), ms(2024, 2, 1, 0, 0)},
+		{"utc+8 4h", KlineSession{Interval: Kline4h, Location: utc8}, ms(2024, 1, 31, 1, 0), ms(2024, 1, 31, 0, 0), ms(2024, 1, 31, 4, 0)},
+		{"utc 3d", KlineSession{Interval: Kline3d}, ms(2024, 1, 31, 12, 0), ms(2024, 1, 30, 0, 0), ms(2024, 2, 2, 0, 0)},
+		{"utc weekly", KlineSession{Interval: Kline1w}, ms(2024, 1, 31, 12, 0), ms(2024, 1, 29, 0, 0), ms(2024, 2, 5, 0, 0)},
+		{"utc+8 weekly", KlineSession{Interval: Kline1w, Location: utc8}, ms(2024, 1, 28, 16, 0), ms(2024, 1, 28, 16, 0), ms(2024, 2, 4, 16, 0)},
+		{"utc monthly", KlineSession{Interval: Kline1mo}, ms(2024, 2, 29, 23, 59), ms(2024, 2, 1, 0, 0), ms(2024, 3, 1, 0, 0)},
+		{"utc+8 monthly", KlineSession{Interval: Kline1mo, Location: utc8}, ms(2024, 1, 31, 16, 30), ms(2024, 1, 31, 16, 0), ms(2024, 2, 29, 16, 0)},
+	}
+
+	for _, tt := range tests {
+		if err := tt.session.Validate(); err != nil {
+			t.Fatalf("%s: Validate failed: %v", tt.name, err)
+		}
+		start, end := tt.session.bucketOf(tt.ts)
+		if start != tt.start || end != tt.end {
+			t.Errorf("%s: expected [%s, %s), got [%s, %s)", tt.name,
+				time.UnixMilli(tt.start).UTC(), time.UnixMilli(tt.end).UTC(), time.UnixMilli(start).UTC(), time.UnixMilli(end).UTC())
+		}
+	}
+
+	if err := (KlineSession{Interval: Kline1d, Start: 24 * time.Hour}).Validate(); err == nil {
+		t.Errorf("Expected error for a start of one day")
+	}
+	if err := (KlineSession{Interval: "2d"}).Validate(); err == nil {
+		t.Errorf("Expected error for an unsupported interval")
+	}
+}
+
+func TestAggTradesToSessionKlines(t *testing.T) {
+	monday := time.Date(2024, 1, 29, 0, 0, 0, 0, time.UTC).UnixMilli()
+	day := KlineIntervalToMilli[Kline1d]
+	aggTrades := []bnc.AggTrades{
+		{Id: 1, Price: 10, Qty: 1, FirstTradeId: 1, LastTradeId: 1, Time: monday + 2*day},
+		{Id: 2, Price: 12, Qty: 1, FirstTradeId: 2, LastTradeId: 3, Time: monday + 6*day},
+		{Id: 3, Price: 11, Qty: 2, FirstTradeId: 4, LastTradeId: 4, Time: monday + 21*day},
+	}
+	klines, err := AggTradesToSessionKlines(aggTrades, KlineSession{Interval: Kline1w})
+	if err != nil {
+		t.Fatalf("AggTradesToSessionKlines failed: %v", err)
+	}
+	if len(klines) != 4 {
+		t.Fatalf("Expected 4 weekly klines, got %d", len(klines))
+	}
+	if klines[0].OpenTime != monday || klines[0].CloseTime != monday+7*day-1 || klines[0].HighPrice != 12 || klines[0].TradesNumber != 3 {
+		t.Errorf("Unexpected first kline %+v", klines[0])
+	}
+	// the weeks without agg trades are filled with the previous close price
+	for _, kline := range klines[1:3] {
+		if kline.OpenPrice != 12 || kline.Volume != 0 {
+			t.Errorf("Unexpected filled kline %+v", kline)
+		}
+	}
+	if klines[3].OpenTime != monday+21*day || klines[3].Volume != 2 {
+		t.Errorf("Unexpected last kline %+v", klines[3])
+	}
+}
+
+func TestOneDirAggTradesToSessionKlinesAcrossFiles(t *testing.T) {
+	dir := t.TempDir()
+	// 2021-01-02 00:00 in UTC+8
+	sessionOpen := time.Date(2021, 1, 1, 16, 0, 0, 0, time.UTC).UnixMilli()
+	row := func(id int64, price float64, time int64) string {
+		aggTrade := bnc.AggTrades{Id: id, Price: price, Qty: 1, FirstTradeId: id, LastTradeId: id, Time: time}
+		return aggTrade.CSVRow()
+	}
+	writeTestRows(t, filepath.Join(dir, "BTCUSDT-aggTrades-2021-01-01.csv"), []string{row(1, 10, sessionOpen-1), row(2, 11, sessionOpen)})
+	// newer spot agg trades are in microseconds
+	writeTestRows(t, filepath.Join(dir, "BTCUSDT-aggTrades-2021-01-02.csv"), []string{row(3, 13, sessionOpen+time.Hour.Milliseconds()), row(4, 9, (sessionOpen+KlineIntervalToMilli[Kline1d])*1000)})
+
+	session := KlineSession{Interval: Kline1d, Location: time.FixedZone("UTC+8", 8*60*60)}
+	klines, err := OneDirAggTradesToSessionKlines(dir, session, time.Time{})
+	if err != nil {
+		t.Fatalf("OneDirAggTradesToSessionKlines failed: %v", err)
+	}
+	if len(klines) != 3 {
+		t.Fatalf("Expected 3 klines, got %d", len(klines))
+	}
+	// the second kline merges the agg trades of both files
+	second := klines[1]
+	if second.OpenTime != sessionOpen || second.OpenPrice != 11 || second.HighPrice != 13 || second.ClosePrice != 13 || second.Volume != 2 || second.TradesNumber != 2 {
+		t.Errorf("Unexpected kline across files %+v", second)
+	}
+	if klines[2].OpenTime != sessionOpen+KlineIntervalToMilli[Kline1d] || klines[2].ClosePrice != 9 {
+		t.Errorf("Unexpected last kline %+v", klines[2])
+	}
+}
@@ -0,0 +1,78 @@
+package bncvision
+
+import (
+	"testing"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+func newTestKline(openTime int64) bnc.Kline {
+	return bnc.Kline{
+		OpenTime:   openTime,
+		CloseTime:  openTime + 59999,
+		OpenPrice:  100,
+		HighPrice:  101,
+		LowPrice:   99,
+		ClosePrice: 100,
+		Volume:     1,
+	}
+}
+
+func TestVerifyKlines(t *testing.T) {
+	start := int64(1609459200000)
+	var klines []bnc.Kline
+	for i := int64(0); i < 100; i++ {
+		if i == 10 || i == 11 || i == 50 {
+			continue
+		}
+		klines = append(klines, newTestKline(start+i*60000))
+	}
+
+	invalid := newTestKline(start + 60*60000)
+	invalid.HighPrice = 98
+	klines[57] = invalid
+
+	for _, maxCpus := range []int{1, 3, 200} {
+		result, err := VerifyKlines(klines, maxCpus)
+		if err != nil {
+			t.Fatalf("VerifyKlines failed: %v", err)
+		}
+		if result.Interval != Kline1m {
+			t.Errorf("Expected interval %s, got %s", Kline1m, result.Interval)
+		}
+		expectedMissingTs := []int64{start + 10*60000, start + 11*60000, start + 50*60000}
+		if len(result.MissingTs) != len(expectedMissingTs) {
+			t.Fatalf("maxCpus %d: Expected missing ts %v, got %v", maxCpus, expectedMissingTs, result.MissingTs)
+		}
+		for i, ts := range expectedMissingTs {
+			if result.MissingTs[i] != ts {
+				t.Errorf("maxCpus %d: Expected missing ts %d, got %d", maxCpus, ts, result.MissingTs[i])
+			}
+		}
+		if len(result.InvalidKlines) != 1 || result.InvalidKlines[0].OpenTime != invalid.OpenTime {
+			t.Errorf("maxCpus %d: Expected invalid kline %d, got %v", maxCpus, invalid.OpenTime, result.InvalidKlines)
+		}
+		if result.OK {
+			t.Errorf("maxCpus %d: Expected result not OK", maxCpus)
+		}
+	}
+}
+
+func TestIsKlineValid(t *testing.T) {
+	k := newTestKline(1609459200000)
+	if !IsKlineValid(k, Kline1m) {
+		t.Errorf("Expected kline to be valid")
+	}
+	if IsKlineValid(k, Kline5m) {
+		t.Errorf("Expected kline with wrong interval to be invalid")
+	}
+	k.Volume = -1
+	if IsKlineValid(k, Kline1m) {
+		t.Errorf("Expected kline with negative volume to be invalid")
+	}
+	k = newTestKline(1609459200000)
+	k.ClosePrice = 102
+	if IsKlineValid(k, Kline1m) {
+		t.Errorf("Expected kline with close above high to be invalid")
+	}
+}
@@ -0,0 +1,154 @@
+package bncvision
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Layout is the set of local data roots used by the pipeline.
+// Every root can be on a different disk, such as archives on a NAS and tidy files on a local SSD,
+// and several Layouts can be used in one process.
+type Layout struct {
+	// Raw is the root of the downloaded archives, a mirror of the bucket, such as <root>/data.binance.vision.
+	Raw string `json:"raw"`
+	// Unzip is the root of the unzipped csv files.
+	Unzip string `json:"unzip"`
+	// Missing is the root of the missing data downloaded from the api.
+	Missing string `json:"missing"`
+	// Tidy is the root of the tidied csv files.
+	Tidy string `json:"tidy"`
+	// Struct is the root of the struct files, such as json and columnar files.
+	Struct string `json:"struct"`
+	// Work is the root of temporary and working files.
+	Work string `json:"work"`
+}
+
+// NewLayout returns the Layout of all roots under root,
+// such as <root>/data.binance.vision and <root>/tidy.binance.vision.
+func NewLayout(root string) Layout {
+	return Layout{
+		Raw:     filepath.Join(root, DATA_BINANCE_VISION),
+		Unzip:   filepath.Join(root, UNZIP_BINANCE_VISION),
+		Missing: filepath.Join(root, MISSING_BINANCE_VISION),
+		Tidy:    filepath.Join(root, TIDY_BINANCE_VISION),
+		Struct:  filepath.Join(root, STRUCT_BINANCE_VISION),
+		Work:    filepath.Join(root, WORK_BINANCE_VISION),
+	}
+}
+
+// HomeLayout returns the Layout under the home directory, which is the layout used by default.
+func HomeLayout() (Layout, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return Layout{}, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return NewLayout(homeDir), nil
+}
+
+// Validate returns an error if any root is empty.
+func (l Layout) Validate() error {
+	roots := []struct{ name, dir string }{
+		{"raw", l.Raw}, {"unzip", l.Unzip}, {"missing", l.Missing},
+		{"tidy", l.Tidy}, {"struct", l.Struct}, {"work", l.Work},
+	}
+	for _, root := range roots {
+		if root.dir == "" {
+			return fmt.Errorf("layout has no %s root", root.name)
+		}
+	}
+	return nil
+}
+
+// override replaces the roots of l with the non empty roots of o.
+func (l Layout) override(o Layout) Layout {
+	for _, p := range []struct {
+		dst *string
+		src string
+	}{
+		{&l.Raw, o.Raw}, {&l.Unzip, o.Unzip}, {&l.Missing, o.Missing},
+		{&l.Tidy, o.Tidy}, {&l.Struct, o.Struct}, {&l.Work, o.Work},
+	} {
+		if p.src != "" {
+			*p.dst = p.src
+		}
+	}
+	return l
+}
+
+// layoutFile is the json file of a Layout, roots which are not set are under Root.
+type layoutFile struct {
+	Root string `json:"root"`
+	Layout
+}
+
+// LoadLayoutFile reads a Layout from a json file, such as
+//
+//	{"root": "/mnt/nas", "tidy": "/mnt/ssd/tidy.binance.vision"}
+//
+// Roots which are not set are under root, see NewLayout, or under the home directory if root is not set either.
+func LoadLayoutFile(filePath string) (Layout, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return Layout{}, err
+	}
+	var file layoutFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Layout{}, fmt.Errorf("failed to unmarshal layout file %s: %w", filePath, err)
+	}
+	return layoutUnder(file.Root, file.Layout)
+}
+
+// Environment variables read by LayoutFromEnv.
+const (
+	// ENV_LAYOUT_FILE is the path of a layout file, see LoadLayoutFile.
+	ENV_LAYOUT_FILE  = "BNCVISION_LAYOUT_FILE"
+	ENV_ROOT         = "BNCVISION_ROOT"
+	ENV_RAW_ROOT     = "BNCVISION_RAW_ROOT"
+	ENV_UNZIP_ROOT   = "BNCVISION_UNZIP_ROOT"
+	ENV_MISSING_ROOT = "BNCVISION_MISSING_ROOT"
+	ENV_TIDY_ROOT    = "BNCVISION_TIDY_ROOT"
+	ENV_STRUCT_ROOT  = "BNCVISION_STRUCT_ROOT"
+	ENV_WORK_ROOT    = "BNCVISION_WORK_ROOT"
+)
+
+// LayoutFromEnv returns the Layout of the environment variables.
+// The roots are under ENV_ROOT if it's set, or read from ENV_LAYOUT_FILE if it's set,
+// or under the home directory otherwise. ENV_RAW_ROOT and the others override single roots.
+func LayoutFromEnv() (Layout, error) {
+	env := Layout{
+		Raw:     os.Getenv(ENV_RAW_ROOT),
+		Unzip:   os.Getenv(ENV_UNZIP_ROOT),
+		Missing: os.Getenv(ENV_MISSING_ROOT),
+		Tidy:    os.Getenv(ENV_TIDY_ROOT),
+		Struct:  os.Getenv(ENV_STRUCT_ROOT),
+		Work:    os.Getenv(ENV_WORK_ROOT),
+	}
+	if root := os.Getenv(ENV_ROOT); root != "" {
+		return NewLayout(root).override(env), nil
+	}
+	if filePath := os.Getenv(ENV_LAYOUT_FILE); filePath != "" {
+		layout, err := LoadLayoutFile(filePath)
+		if err != nil {
+			return Layout{}, err
+		}
+		return layout.override(env), nil
+	}
+	return layoutUnder("", env)
+}
+
+// layoutUnder returns roots, whose empty roots are under root, or under the home directory if root is empty.
+func layoutUnder(root string, roots Layout) (Layout, error) {
+	if roots.Validate() == nil {
+		return roots, nil
+	}
+	if root != "" {
+		return NewLayout(root).override(roots), nil
+	}
+	layout, err := HomeLayout()
+	if err != nil {
+		return Layout{}, err
+	}
+	return layout.override(roots), nil
+}
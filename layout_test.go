@@ -0,0 +1,68 @@
+package bncvision
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadLayoutFile(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "layout.json")
+	if err := os.WriteFile(filePath, []byte(`{"root": "/mnt/nas", "tidy": "/mnt/ssd/tidy"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	layout, err := LoadLayoutFile(filePath)
+	if err != nil {
+		t.Fatalf("LoadLayoutFile failed: %v", err)
+	}
+	expected := NewLayout("/mnt/nas")
+	expected.Tidy = "/mnt/ssd/tidy"
+	if layout != expected {
+		t.Errorf("Expected %+v, got %+v", expected, layout)
+	}
+	if err := layout.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+	if err := (Layout{Raw: "/raw"}).Validate(); err == nil {
+		t.Errorf("Expected error for a layout without unzip root")
+	}
+
+	spec := DatasetSpec{Market: MarketSpot, Frequency: FrequencyDaily, DataType: DataTypeAggTrades, Symbol: "BTCUSDT"}
+	if d := spec.TidyDir(layout); d != filepath.Join("/mnt/ssd/tidy", "data", "spot", "daily", "aggTrades", "BTCUSDT") {
+		t.Errorf("Unexpected tidy dir %s", d)
+	}
+	if d := spec.UnzipDir(layout); d != filepath.Join("/mnt/nas", UNZIP_BINANCE_VISION, "data", "spot", "daily", "aggTrades", "BTCUSDT") {
+		t.Errorf("Unexpected unzip dir %s", d)
+	}
+}
+
+func TestLayoutFromEnv(t *testing.T) {
+	t.Setenv(ENV_ROOT, "/mnt/nas")
+	t.Setenv(ENV_MISSING_ROOT, "/mnt/ssd/missing")
+	layout, err := LayoutFromEnv()
+	if err != nil {
+		t.Fatalf("LayoutFromEnv failed: %v", err)
+	}
+	expected := NewLayout("/mnt/nas")
+	expected.Missing = "/mnt/ssd/missing"
+	if layout != expected {
+		t.Errorf("Expected %+v, got %+v", expected, layout)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "layout.json")
+	if err := os.WriteFile(filePath, []byte(`{"root": "/mnt/other"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(ENV_ROOT, "")
+	t.Setenv(ENV_LAYOUT_FILE, filePath)
+	layout, err = LayoutFromEnv()
+	if err != nil {
+		t.Fatalf("LayoutFromEnv failed: %v", err)
+	}
+	expected = NewLayout("/mnt/other")
+	expected.Missing = "/mnt/ssd/missing"
+	if layout != expected {
+		t.Errorf("Expected %+v, got %+v", expected, layout)
+	}
+}
@@ -0,0 +1,289 @@
+package bncvision
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"unsafe"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+// LineToStructFunc converts a single CSV line to a struct of type T.
+// The line is only valid during the call, so it must not be retained.
+type LineToStructFunc[T any] func(line []byte) (T, error)
+
+var ErrLineFieldsNotEnough = errors.New("line fields are not enough")
+
+// AggTradeLineToStruct is the byte level version of AggTradeRawToStruct.
+// It parses Binance Vision aggTrades line without allocating strings for the fields.
+func AggTradeLineToStruct(line []byte) (bnc.AggTrades, error) {
+	trade := bnc.AggTrades{}
+	var field []byte
+	var err error
+	p := lineFieldParser{line: line}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.Id, err = parseLineInt(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.Price, err = parseLineFloat(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.Qty, err = parseLineFloat(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.FirstTradeId, err = parseLineInt(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.LastTradeId, err = parseLineInt(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.Time, err = parseLineInt(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.IsBuyerMaker, err = parseLineBool(field); err != nil {
+		return trade, err
+	}
+	if p.done() {
+		return trade, nil
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.IsBestMatch, err = parseLineBool(field); err != nil {
+		return trade, err
+	}
+	return trade, nil
+}
+
+// SpotTradeLineToStruct is the byte level version of SpotTradeRawToStruct.
+func SpotTradeLineToStruct(line []byte) (bnc.SpotTrade, error) {
+	trade := bnc.SpotTrade{}
+	var field []byte
+	var err error
+	p := lineFieldParser{line: line}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.Id, err = parseLineInt(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.Price, err = parseLineFloat(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.Qty, err = parseLineFloat(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.QuoteQty, err = parseLineFloat(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.Time, err = parseLineInt(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.IsBuyerMaker, err = parseLineBool(field); err != nil {
+		return trade, err
+	}
+	if field, err = p.next(); err != nil {
+		return trade, err
+	}
+	if trade.IsBestMatch, err = parseLineBool(field); err != nil {
+		return trade, err
+	}
+	return trade, nil
+}
+
+// KlineLineToStruct is the byte level version of KlineRawToStruct.
+func KlineLineToStruct(line []byte) (bnc.Kline, error) {
+	kline := bnc.Kline{}
+	var field []byte
+	var err error
+	p := lineFieldParser{line: line}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.OpenTime, err = parseLineInt(field); err != nil {
+		return kline, err
+	}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.OpenPrice, err = parseLineFloat(field); err != nil {
+		return kline, err
+	}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.HighPrice, err = parseLineFloat(field); err != nil {
+		return kline, err
+	}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.LowPrice, err = parseLineFloat(field); err != nil {
+		return kline, err
+	}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.ClosePrice, err = parseLineFloat(field); err != nil {
+		return kline, err
+	}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.Volume, err = parseLineFloat(field); err != nil {
+		return kline, err
+	}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.CloseTime, err = parseLineInt(field); err != nil {
+		return kline, err
+	}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.QuoteAssetVolume, err = parseLineFloat(field); err != nil {
+		return kline, err
+	}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.TradesNumber, err = parseLineInt(field); err != nil {
+		return kline, err
+	}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.TakerBuyBaseAssetVolume, err = parseLineFloat(field); err != nil {
+		return kline, err
+	}
+	if field, err = p.next(); err != nil {
+		return kline, err
+	}
+	if kline.TakerBuyQuoteAssetVolume, err = parseLineFloat(field); err != nil {
+		return kline, err
+	}
+	// unused
+	if _, err = p.next(); err != nil {
+		return kline, err
+	}
+	return kline, nil
+}
+
+// FundingRateLineToStruct is the byte level version of FundingRateRawToStruct.
+func FundingRateLineToStruct(line []byte) (bnc.FuturesFundingRateHistory, error) {
+	fundingRate := bnc.FuturesFundingRateHistory{}
+	var field []byte
+	var err error
+	p := lineFieldParser{line: line}
+	if field, err = p.next(); err != nil {
+		return fundingRate, err
+	}
+	if fundingRate.FundingTime, err = parseLineInt(field); err != nil {
+		return fundingRate, err
+	}
+	// funding interval hours
+	if _, err = p.next(); err != nil {
+		return fundingRate, err
+	}
+	if field, err = p.next(); err != nil {
+		return fundingRate, err
+	}
+	if fundingRate.FundingRate, err = parseLineFloat(field); err != nil {
+		return fundingRate, err
+	}
+	return fundingRate, nil
+}
+
+// lineFieldParser splits a CSV line by comma.
+// Binance Vision csv fields are never quoted, so quotes are not handled.
+type lineFieldParser struct {
+	line []byte
+	pos  int
+}
+
+func (p *lineFieldParser) next() ([]byte, error) {
+	if p.pos > len(p.line) {
+		return nil, ErrLineFieldsNotEnough
+	}
+	rest := p.line[p.pos:]
+	i := bytes.IndexByte(rest, ',')
+	if i < 0 {
+		p.pos = len(p.line) + 1
+		return rest, nil
+	}
+	p.pos += i + 1
+	return rest[:i], nil
+}
+
+func (p *lineFieldParser) done() bool {
+	return p.pos > len(p.line)
+}
+
+// unsafeString returns a string sharing the memory of b.
+// strconv clones the string into the returned errors, so it's safe to pass to strconv.
+func unsafeString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
+
+func parseLineInt(b []byte) (int64, error) {
+	// fast path, no sign and no overflow
+	if len(b) > 0 && len(b) < 19 {
+		var n int64
+		for _, c := range b {
+			if c < '0' || c > '9' {
+				return strconv.ParseInt(unsafeString(b), 10, 64)
+			}
+			n = n*10 + int64(c-'0')
+		}
+		return n, nil
+	}
+	return strconv.ParseInt(unsafeString(b), 10, 64)
+}
+
+func parseLineFloat(b []byte) (float64, error) {
+	return strconv.ParseFloat(unsafeString(b), 64)
+}
+
+func parseLineBool(b []byte) (bool, error) {
+	return strconv.ParseBool(unsafeString(b))
+}
@@ -0,0 +1,180 @@
+package bncvision
+
+import (
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+var testAggTradeLines = []string{
+	"3183542474,64012.01000000,0.00100000,3949434637,3949434637,1727740800043,true,true",
+	"3183542475,64012.00000000,0.04670000,3949434638,3949434640,1727740800043,false,true",
+	"1,0.1,1e-8,-1,-1,1727740800043,True,False",
+	// futures layout, no is_best_match column
+	"2316917452,63316.80,0.002,5390566960,5390566960,1727740800000,false",
+}
+
+var testSpotTradeLines = []string{
+	"3949434637,64012.01000000,0.00100000,64.01201000,1727740800043,true,true",
+	"3949434638,64012.00000000,0.04670000,2989.36080000,1727740800043,false,true",
+}
+
+var testKlineLines = []string{
+	"1727740800000,63328.00000000,63328.01000000,63328.00000000,63328.01000000,0.44812000,1727740800999,28378.56213320,14,0.40140000,25419.86321400,0",
+	"1727740801000,63328.01000000,63328.01000000,63328.00000000,63328.00000000,0.00000000,1727740801999,0.00000000,0,0.00000000,0.00000000,0",
+}
+
+var testFundingRateLines = []string{
+	"1727740800000,8,0.00010000",
+	"1727769600000,8,-0.00004356",
+}
+
+func splitTestLine(line string) []string {
+	record, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil {
+		panic(err)
+	}
+	return record
+}
+
+func TestAggTradeLineToStruct(t *testing.T) {
+	for _, line := range testAggTradeLines {
+		expected, err := AggTradeRawToStruct(splitTestLine(line))
+		if err != nil {
+			t.Fatalf("AggTradeRawToStruct failed: %v", err)
+		}
+		got, err := AggTradeLineToStruct([]byte(line))
+		if err != nil {
+			t.Fatalf("AggTradeLineToStruct failed: %v", err)
+		}
+		if got != expected {
+			t.Errorf("Line %s: Expected %v, got %v", line, expected, got)
+		}
+	}
+	for _, line := range []string{
+		"agg_trade_id,price,quantity,first_trade_id,last_trade_id,transact_time,is_buyer_maker",
+		"1,0.1,1,1,1,1727740800043",
+		"1,0.1,1,1,1,1727740800043,yes",
+		"99999999999999999999,0.1,1,1,1,1727740800043,true",
+	} {
+		if _, err := AggTradeLineToStruct([]byte(line)); err == nil {
+			t.Errorf("Line %s: Expected error", line)
+		}
+	}
+}
+
+func TestSpotTradeLineToStruct(t *testing.T) {
+	for _, line := range testSpotTradeLines {
+		expected, err := SpotTradeRawToStruct(splitTestLine(line))
+		if err != nil {
+			t.Fatalf("SpotTradeRawToStruct failed: %v", err)
+		}
+		got, err := SpotTradeLineToStruct([]byte(line))
+		if err != nil {
+			t.Fatalf("SpotTradeLineToStruct failed: %v", err)
+		}
+		if got != expected {
+			t.Errorf("Line %s: Expected %v, got %v", line, expected, got)
+		}
+	}
+}
+
+func TestKlineLineToStruct(t *testing.T) {
+	for _, line := range testKlineLines {
+		expected, err := KlineRawToStruct(splitTestLine(line))
+		if err != nil {
+			t.Fatalf("KlineRawToStruct failed: %v", err)
+		}
+		got, err := KlineLineToStruct([]byte(line))
+		if err != nil {
+			t.Fatalf("KlineLineToStruct failed: %v", err)
+		}
+		if got != expected {
+			t.Errorf("Line %s: Expected %v, got %v", line, expected, got)
+		}
+	}
+	if _, err := KlineLineToStruct([]byte("1727740800000,63328.00000000")); err == nil {
+		t.Errorf("Expected error for short kline line")
+	}
+}
+
+func TestFundingRateLineToStruct(t *testing.T) {
+	for _, line := range testFundingRateLines {
+		expected, err := FundingRateRawToStruct(splitTestLine(line))
+		if err != nil {
+			t.Fatalf("FundingRateRawToStruct failed: %v", err)
+		}
+		got, err := FundingRateLineToStruct([]byte(line))
+		if err != nil {
+			t.Fatalf("FundingRateLineToStruct failed: %v", err)
+		}
+		if got != expected {
+			t.Errorf("Line %s: Expected %v, got %v", line, expected, got)
+		}
+	}
+}
+
+func TestLineToStructAllocs(t *testing.T) {
+	line := []byte(testAggTradeLines[0])
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := AggTradeLineToStruct(line); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Expected 0 allocs, got %v", allocs)
+	}
+}
+
+func benchmarkRawToStruct[T any](b *testing.B, line string, convertFunc RawToStructFunc[T]) {
+	data := strings.Repeat(line+"\n", 1000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		stream := NewCSVStream(context.Background(), strings.NewReader(data), convertFunc)
+		for stream.Next() {
+		}
+		if err := stream.Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkLineToStruct[T any](b *testing.B, line string, convertFunc LineToStructFunc[T]) {
+	data := strings.Repeat(line+"\n", 1000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		stream := NewLineStream(context.Background(), strings.NewReader(data), convertFunc)
+		for stream.Next() {
+		}
+		if err := stream.Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAggTradeRawToStruct(b *testing.B) {
+	benchmarkRawToStruct(b, testAggTradeLines[1], AggTradeRawToStruct)
+}
+
+func BenchmarkAggTradeLineToStruct(b *testing.B) {
+	benchmarkLineToStruct(b, testAggTradeLines[1], AggTradeLineToStruct)
+}
+
+func BenchmarkSpotTradeRawToStruct(b *testing.B) {
+	benchmarkRawToStruct(b, testSpotTradeLines[1], SpotTradeRawToStruct)
+}
+
+func BenchmarkSpotTradeLineToStruct(b *testing.B) {
+	benchmarkLineToStruct(b, testSpotTradeLines[1], SpotTradeLineToStruct)
+}
+
+func BenchmarkKlineRawToStruct(b *testing.B) {
+	benchmarkRawToStruct(b, testKlineLines[0], KlineRawToStruct)
+}
+
+func BenchmarkKlineLineToStruct(b *testing.B) {
+	benchmarkLineToStruct(b, testKlineLines[0], KlineLineToStruct)
+}
@@ -0,0 +1,190 @@
+package bncvision
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MISSING_COVERAGE_VERSION is the version of the missing coverage layout.
+const MISSING_COVERAGE_VERSION = 1
+
+// MISSING_COVERAGE_FILE_NAME is the name of the sidecar file in the missing directory of a dataset,
+// which records the key ranges of every missing file.
+const MISSING_COVERAGE_FILE_NAME = ".coverage.json"
+
+// KeyRange is a continuous range of keys, StartId and EndId are both inclusive.
+type KeyRange struct {
+	StartId int64 `json:"startId"`
+	EndId   int64 `json:"endId"`
+}
+
+// MissingCoverage records the continuous key ranges of the items in every file of a missing directory.
+type MissingCoverage struct {
+	Version int `json:"version"`
+	// Files maps file names to their key ranges sorted by StartId.
+	Files map[string][]KeyRange `json:"files"`
+}
+
+// LoadMissingCoverage reads the coverage sidecar in dir.
+// An empty coverage is returned if the sidecar does not exist.
+func LoadMissingCoverage(dir string) (*MissingCoverage, error) {
+	coverage := &MissingCoverage{Version: MISSING_COVERAGE_VERSION, Files: map[string][]KeyRange{}}
+	data, err := os.ReadFile(filepath.Join(dir, MISSING_COVERAGE_FILE_NAME))
+	if os.IsNotExist(err) {
+		return coverage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, coverage); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal missing coverage: %w", err)
+	}
+	if coverage.Version != MISSING_COVERAGE_VERSION {
+		return nil, fmt.Errorf("missing coverage in %s has version %d, expected %d", dir, coverage.Version, MISSING_COVERAGE_VERSION)
+	}
+	if coverage.Files == nil {
+		coverage.Files = map[string][]KeyRange{}
+	}
+	return coverage, nil
+}
+
+// Save writes the coverage sidecar to dir.
+func (c *MissingCoverage) Save(dir string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, MISSING_COVERAGE_FILE_NAME), data)
+}
+
+// Covers returns true if key is in any range of file.
+func (c *MissingCoverage) Covers(file string, key int64) bool {
+	ranges := c.Files[file]
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].EndId >= key })
+	return i < len(ranges) && ranges[i].StartId <= key
+}
+
+// keyRangesOf returns the continuous key ranges of items sorted by key.
+func keyRangesOf[T any](kind TidyKind[T], items []T) []KeyRange {
+	var ranges []KeyRange
+	for i, item := range items {
+		key := kind.Key(item)
+		if i > 0 && kind.Key(items[i-1])+kind.Step(items[i-1]) == key {
+			ranges[len(ranges)-1].EndId = key
+			continue
+		}
+		ranges = append(ranges, KeyRange{StartId: key, EndId: key})
+	}
+	return ranges
+}
+
+// uncoveredKeyRanges returns the sub-ranges of missing which are not covered by the keys of items.
+// A back-filler may return fewer items than missing, such as when the api returns an empty page.
+func uncoveredKeyRanges[T any](kind TidyKind[T], missing MissingRange, items []T) []KeyRange {
+	if len(items) == 0 {
+		return []KeyRange{{StartId: missing.StartId, EndId: missing.EndId}}
+	}
+	sorted := slices.Clone(items)
+	sort.Slice(sorted, func(i, j int) bool {
+		return kind.Key(sorted[i]) < kind.Key(sorted[j])
+	})
+	step := kind.Step(sorted[0])
+	var uncovered []KeyRange
+	next := missing.StartId
+	for _, r := range keyRangesOf(kind, sorted) {
+		if r.EndId < next {
+			continue
+		}
+		if r.StartId > missing.EndId {
+			break
+		}
+		if r.StartId > next {
+			uncovered = append(uncovered, KeyRange{StartId: next, EndId: r.StartId - step})
+		}
+		next = r.EndId + step
+	}
+	if next <= missing.EndId {
+		uncovered = append(uncovered, KeyRange{StartId: next, EndId: missing.EndId})
+	}
+	return uncovered
+}
+
+// SaveMissingItems saves back-filled items of kind to the missing directory dir.
+// Items are split into the csv files of the days of their times, and merged with the items already in the files,
+// so several gaps of one day and gaps across midnight are all kept.
+// Items are sorted and deduplicated by key, the items already saved win.
+// The key ranges of the written files are recorded in the coverage sidecar of dir.
+func SaveMissingItems[T any](dir string, kind TidyKind[T], items []T) error {
+	if len(items) == 0 {
+		return nil
+	}
+	byFile := map[string][]T{}
+	for _, item := range items {
+		fileName := kind.FileName(time.UnixMilli(kind.Time(item)))
+		byFile[fileName] = append(byFile[fileName], item)
+	}
+	fileNames := make([]string, 0, len(byFile))
+	for fileName := range byFile {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	coverage, err := LoadMissingCoverage(dir)
+	if err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		merged, err := mergeMissingFile(dir, kind, fileName, byFile[fileName])
+		if err != nil {
+			return err
+		}
+		coverage.Files[fileName] = keyRangesOf(kind, merged)
+		gLogger.Info("Saved Missing Items", "kind", kind.Name, "file", fileName, "new", len(byFile[fileName]), "len", len(merged))
+	}
+	return coverage.Save(dir)
+}
+
+// mergeMissingFile merges items into the missing file fileName in dir, and returns all items of the file.
+func mergeMissingFile[T any](dir string, kind TidyKind[T], fileName string, items []T) ([]T, error) {
+	filePath := filepath.Join(dir, fileName)
+	exists, err := FileExists(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var merged []T
+	if exists {
+		merged, err = ReadLinesToStructs(filePath, kind.LineToStruct)
+		if err != nil {
+			return nil, err
+		}
+	}
+	merged = append(merged, items...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		return kind.Key(merged[i]) < kind.Key(merged[j])
+	})
+	deduped := merged[:0]
+	for _, item := range merged {
+		if len(deduped) > 0 && kind.Key(item) == kind.Key(deduped[len(deduped)-1]) {
+			continue
+		}
+		deduped = append(deduped, item)
+	}
+
+	var csvRows []string
+	if kind.Header != "" {
+		csvRows = append(csvRows, kind.Header)
+	}
+	for _, item := range deduped {
+		csvRows = append(csvRows, kind.CSVRow(item))
+	}
+	if err := writeFileAtomic(filePath, []byte(strings.Join(csvRows, "\n"))); err != nil {
+		return nil, err
+	}
+	return deduped, nil
+}
@@ -0,0 +1,63 @@
+package bncvision
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+func TestSaveMissingItems(t *testing.T) {
+	dir := t.TempDir()
+	midnight := int64(1609545600000) // 2021-01-02
+	aggTrade := func(id, time int64) bnc.AggTrades {
+		return bnc.AggTrades{Id: id, Price: 1, Qty: 1, FirstTradeId: id, LastTradeId: id, Time: time}
+	}
+	kind := AggTradesTidyKind("BTCUSDT", bnc.AggTradesTypeSpot)
+
+	// two gaps of one day
+	if err := SaveMissingItems(dir, kind, []bnc.AggTrades{aggTrade(3, midnight-100), aggTrade(4, midnight-90)}); err != nil {
+		t.Fatalf("SaveMissingItems failed: %v", err)
+	}
+	if err := SaveMissingItems(dir, kind, []bnc.AggTrades{aggTrade(8, midnight-50), aggTrade(4, midnight-90)}); err != nil {
+		t.Fatalf("SaveMissingItems failed: %v", err)
+	}
+	// a gap across midnight
+	if err := SaveMissingItems(dir, kind, []bnc.AggTrades{aggTrade(9, midnight-1), aggTrade(10, midnight), aggTrade(11, midnight+1)}); err != nil {
+		t.Fatalf("SaveMissingItems failed: %v", err)
+	}
+
+	day1, err := ReadLinesToStructs(filepath.Join(dir, "BTCUSDT-aggTrades-2021-01-01.csv"), AggTradeLineToStruct)
+	if err != nil {
+		t.Fatalf("Failed to read missing file: %v", err)
+	}
+	var ids []int64
+	for _, tr := range day1 {
+		ids = append(ids, tr.Id)
+	}
+	if len(ids) != 4 || ids[0] != 3 || ids[1] != 4 || ids[2] != 8 || ids[3] != 9 {
+		t.Errorf("Expected ids 3 4 8 9 on 2021-01-01, got %v", ids)
+	}
+	day2, err := ReadLinesToStructs(filepath.Join(dir, "BTCUSDT-aggTrades-2021-01-02.csv"), AggTradeLineToStruct)
+	if err != nil {
+		t.Fatalf("Failed to read missing file: %v", err)
+	}
+	if len(day2) != 2 || day2[0].Id != 10 || day2[1].Id != 11 {
+		t.Errorf("Expected ids 10 11 on 2021-01-02, got %v", day2)
+	}
+
+	coverage, err := LoadMissingCoverage(dir)
+	if err != nil {
+		t.Fatalf("LoadMissingCoverage failed: %v", err)
+	}
+	ranges := coverage.Files["BTCUSDT-aggTrades-2021-01-01.csv"]
+	if len(ranges) != 2 || ranges[0] != (KeyRange{3, 4}) || ranges[1] != (KeyRange{8, 9}) {
+		t.Errorf("Unexpected ranges of 2021-01-01 %v", ranges)
+	}
+	if ranges := coverage.Files["BTCUSDT-aggTrades-2021-01-02.csv"]; len(ranges) != 1 || ranges[0] != (KeyRange{10, 11}) {
+		t.Errorf("Unexpected ranges of 2021-01-02 %v", ranges)
+	}
+	if !coverage.Covers("BTCUSDT-aggTrades-2021-01-01.csv", 8) || coverage.Covers("BTCUSDT-aggTrades-2021-01-01.csv", 5) {
+		t.Errorf("Unexpected Covers result")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package bncvision
+
+import "os"
+
+// mmapFile reads a whole file into memory on platforms without mmap support.
+func mmapFile(filePath string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,301 @@
+package bncvision
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/dwdwow/cex/bnc"
+	"github.com/parquet-go/parquet-go"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	PARQUET_FILE_EXT = ".parquet"
+
+	// parquetRowGroupSize is the max rows of a row group, min/max statistics are kept per row group
+	parquetRowGroupSize = 1 << 20
+	parquetWriteBatch   = 1 << 12
+)
+
+// AggTradeParquetRow is the parquet row of bnc.AggTrades, columns are named as Binance Vision csv headers.
+type AggTradeParquetRow struct {
+	AggTradeId   int64   `parquet:"agg_trade_id"`
+	Price        float64 `parquet:"price"`
+	Quantity     float64 `parquet:"quantity"`
+	FirstTradeId int64   `parquet:"first_trade_id"`
+	LastTradeId  int64   `parquet:"last_trade_id"`
+	TransactTime int64   `parquet:"transact_time,timestamp(millisecond)"`
+	IsBuyerMaker bool    `parquet:"is_buyer_maker"`
+	IsBestMatch  bool    `parquet:"is_best_match"`
+}
+
+// SpotTradeParquetRow is the parquet row of bnc.SpotTrade.
+type SpotTradeParquetRow struct {
+	Id           int64   `parquet:"id"`
+	Price        float64 `parquet:"price"`
+	Qty          float64 `parquet:"qty"`
+	QuoteQty     float64 `parquet:"quote_qty"`
+	Time         int64   `parquet:"time,timestamp(millisecond)"`
+	IsBuyerMaker bool    `parquet:"is_buyer_maker"`
+	IsBestMatch  bool    `parquet:"is_best_match"`
+}
+
+// KlineParquetRow is the parquet row of bnc.Kline.
+type KlineParquetRow struct {
+	OpenTime            int64   `parquet:"open_time,timestamp(millisecond)"`
+	Open                float64 `parquet:"open"`
+	High                float64 `parquet:"high"`
+	Low                 float64 `parquet:"low"`
+	Close               float64 `parquet:"close"`
+	Volume              float64 `parquet:"volume"`
+	CloseTime           int64   `parquet:"close_time,timestamp(millisecond)"`
+	QuoteVolume         float64 `parquet:"quote_volume"`
+	Count               int64   `parquet:"count"`
+	TakerBuyVolume      float64 `parquet:"taker_buy_volume"`
+	TakerBuyQuoteVolume float64 `parquet:"taker_buy_quote_volume"`
+}
+
+// FundingRateParquetRow is the parquet row of bnc.FuturesFundingRateHistory.
+type FundingRateParquetRow struct {
+	CalcTime        int64   `parquet:"calc_time,timestamp(millisecond)"`
+	LastFundingRate float64 `parquet:"last_funding_rate"`
+}
+
+// ParquetStruct is the struct types which can be saved as parquet files.
+type ParquetStruct interface {
+	bnc.AggTrades | bnc.SpotTrade | bnc.Kline | bnc.FuturesFundingRateHistory
+}
+
+func aggTradeToParquetRow(t bnc.AggTrades) AggTradeParquetRow {
+	return AggTradeParquetRow{
+		AggTradeId:   t.Id,
+		Price:        t.Price,
+		Quantity:     t.Qty,
+		FirstTradeId: t.FirstTradeId,
+		LastTradeId:  t.LastTradeId,
+		TransactTime: t.Time,
+		IsBuyerMaker: t.IsBuyerMaker,
+		IsBestMatch:  t.IsBestMatch,
+	}
+}
+
+func spotTradeToParquetRow(t bnc.SpotTrade) SpotTradeParquetRow {
+	return SpotTradeParquetRow{
+		Id:           t.Id,
+		Price:        t.Price,
+		Qty:          t.Qty,
+		QuoteQty:     t.QuoteQty,
+		Time:         t.Time,
+		IsBuyerMaker: t.IsBuyerMaker,
+		IsBestMatch:  t.IsBestMatch,
+	}
+}
+
+func klineToParquetRow(k bnc.Kline) KlineParquetRow {
+	return KlineParquetRow{
+		OpenTime:            k.OpenTime,
+		Open:                k.OpenPrice,
+		High:                k.HighPrice,
+		Low:                 k.LowPrice,
+		Close:               k.ClosePrice,
+		Volume:              k.Volume,
+		CloseTime:           k.CloseTime,
+		QuoteVolume:         k.QuoteAssetVolume,
+		Count:               k.TradesNumber,
+		TakerBuyVolume:      k.TakerBuyBaseAssetVolume,
+		TakerBuyQuoteVolume: k.TakerBuyQuoteAssetVolume,
+	}
+}
+
+func fundingRateToParquetRow(f bnc.FuturesFundingRateHistory) FundingRateParquetRow {
+	return FundingRateParquetRow{
+		CalcTime:        f.FundingTime,
+		LastFundingRate: f.FundingRate,
+	}
+}
+
+// sliceStream is a StructStream of a slice.
+type sliceStream[T any] struct {
+	data []T
+	i    int
+}
+
+func (s *sliceStream[T]) Next() bool {
+	if s.i >= len(s.data) {
+		return false
+	}
+	s.i++
+	return true
+}
+
+func (s *sliceStream[T]) Struct() T {
+	return s.data[s.i-1]
+}
+
+func (s *sliceStream[T]) Err() error {
+	return nil
+}
+
+// WriteStructsToParquet writes all structs of a stream to w in parquet format.
+// Structs are converted to the parquet rows, such as AggTradeParquetRow, and compressed by zstd.
+//
+// Parameters:
+//   - stream: The stream of structs, such as a CSVStream or a LineStream.
+//   - w: The writer where the parquet data will be written.
+//
+// Returns:
+//   - The number of written rows.
+//   - An error if any step of the streaming or writing process fails, nil otherwise.
+func WriteStructsToParquet[T ParquetStruct](stream StructStream[T], w io.Writer) (int64, error) {
+	switch s := any(stream).(type) {
+	case StructStream[bnc.AggTrades]:
+		return writeParquet(s, aggTradeToParquetRow, w)
+	case StructStream[bnc.SpotTrade]:
+		return writeParquet(s, spotTradeToParquetRow, w)
+	case StructStream[bnc.Kline]:
+		return writeParquet(s, klineToParquetRow, w)
+	case StructStream[bnc.FuturesFundingRateHistory]:
+		return writeParquet(s, fundingRateToParquetRow, w)
+	}
+	panic("unreachable")
+}
+
+func writeParquet[T, R any](stream StructStream[T], toRow func(T) R, w io.Writer) (int64, error) {
+	writer := parquet.NewGenericWriter[R](w,
+		parquet.Compression(&parquet.Zstd),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+		parquet.CreatedBy("bncvision", "", ""),
+	)
+
+	var total int64
+	rows := make([]R, 0, parquetWriteBatch)
+	flush := func() error {
+		n, err := writer.Write(rows)
+		total += int64(n)
+		rows = rows[:0]
+		return err
+	}
+
+	for stream.Next() {
+		rows = append(rows, toRow(stream.Struct()))
+		if len(rows) == cap(rows) {
+			if err := flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return total, err
+	}
+	if err := flush(); err != nil {
+		return total, err
+	}
+
+	return total, writer.Close()
+}
+
+// SaveStructsToParquet saves a slice of structs to a parquet file.
+//
+// Parameters:
+//   - data: A slice of structs to be saved.
+//   - filePath: The path to the parquet file where the data will be saved.
+//
+// Returns:
+//   - An error if any step of the saving process fails, nil otherwise.
+func SaveStructsToParquet[T ParquetStruct](data []T, filePath string) error {
+	return saveStreamToParquet[T](&sliceStream[T]{data: data}, filePath)
+}
+
+func saveStreamToParquet[T ParquetStruct](stream StructStream[T], filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = WriteStructsToParquet(stream, file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(filePath)
+		return err
+	}
+
+	return nil
+}
+
+// ReadCSVToStructsAndSaveToParquet streams a CSV file, or a zip file containing exactly one CSV file,
+// converts its records to structs, and saves the structs to a parquet file.
+// The CSV file is never loaded into memory as a whole.
+//
+// Parameters:
+//   - csvFilePath: The path to the CSV or zip file to be read.
+//   - parquetFilePath: The path to the parquet file where the data will be saved.
+//   - convertFunc: A function that converts a single CSV row (string slice) to a struct of type T.
+//
+// Returns:
+//   - An error if any step of the reading, conversion, or saving process fails, nil otherwise.
+func ReadCSVToStructsAndSaveToParquet[T ParquetStruct](csvFilePath, parquetFilePath string, convertFunc RawToStructFunc[T]) error {
+	stream, err := StreamCSVToStructs(context.Background(), csvFilePath, convertFunc)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return saveStreamToParquet[T](stream, parquetFilePath)
+}
+
+// ReadAllCSVToStructsAndSaveToParquet reads all CSV files, or zipped CSV files, in a directory,
+// converts their contents to structs, and saves the structs to parquet files in a specified directory.
+//
+// Parameters:
+//   - csvFileDir: The directory containing the CSV files to be read.
+//   - parquetFileDir: The directory where the parquet files will be saved.
+//   - convertFunc: A function that converts a single CSV row (string slice) to a struct of type T.
+//
+// Returns:
+//   - An error if any step of the reading, conversion, or saving process fails, nil otherwise.
+func ReadAllCSVToStructsAndSaveToParquet[T ParquetStruct](csvFileDir, parquetFileDir string, convertFunc RawToStructFunc[T]) error {
+	if err := os.MkdirAll(parquetFileDir, 0o755); err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(csvFileDir)
+	if err != nil {
+		return err
+	}
+
+	maxWorkers := runtime.NumCPU() / 2
+	if maxWorkers == 0 {
+		maxWorkers = 1
+	}
+
+	wg := errgroup.Group{}
+	wg.SetLimit(maxWorkers)
+
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if ext != ".csv" && ext != ".zip" {
+			continue
+		}
+		file := file
+		wg.Go(func() error {
+			csvFilePath := filepath.Join(csvFileDir, file.Name())
+			parquetFilePath := filepath.Join(parquetFileDir, strings.TrimSuffix(file.Name(), ext)+PARQUET_FILE_EXT)
+			slog.Info("reading", "csvFilePath", csvFilePath, "parquetFilePath", parquetFilePath)
+			err := ReadCSVToStructsAndSaveToParquet(csvFilePath, parquetFilePath, convertFunc)
+			if err != nil {
+				slog.Error("read", "csvFilePath", csvFilePath, "parquetFilePath", parquetFilePath, "error", err)
+				return err
+			}
+			slog.Info("read", "csvFilePath", csvFilePath, "parquetFilePath", parquetFilePath)
+			return nil
+		})
+	}
+
+	return wg.Wait()
+}
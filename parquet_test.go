@@ -0,0 +1,78 @@
+package bncvision
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dwdwow/cex/bnc"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestSaveStructsToParquet(t *testing.T) {
+	var klines []bnc.Kline
+	for _, line := range testKlineLines {
+		kline, err := KlineLineToStruct([]byte(line))
+		if err != nil {
+			t.Fatalf("KlineLineToStruct failed: %v", err)
+		}
+		klines = append(klines, kline)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "BTCUSDT-1s-2024-10-01"+PARQUET_FILE_EXT)
+	if err := SaveStructsToParquet(klines, filePath); err != nil {
+		t.Fatalf("SaveStructsToParquet failed: %v", err)
+	}
+
+	rows, err := parquet.ReadFile[KlineParquetRow](filePath)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if len(rows) != len(klines) {
+		t.Fatalf("Expected %d rows, got %d", len(klines), len(rows))
+	}
+	for i, row := range rows {
+		if row != klineToParquetRow(klines[i]) {
+			t.Errorf("Expected %v, got %v", klineToParquetRow(klines[i]), row)
+		}
+	}
+}
+
+func TestReadAllCSVToStructsAndSaveToParquet(t *testing.T) {
+	csvDir := t.TempDir()
+	parquetDir := t.TempDir()
+	data := "agg_trade_id,price,quantity,first_trade_id,last_trade_id,transact_time,is_buyer_maker\n" +
+		"1,100.5,1,10,11,1609459200000,true\n" +
+		"2,101,2,12,12,1609459200001,false\n"
+	if err := os.WriteFile(filepath.Join(csvDir, "BTCUSDT-aggTrades-2021-01-01.csv"), []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write csv file: %v", err)
+	}
+	if err := ZipDataAndSave([]byte(data), "BTCUSDT-aggTrades-2021-01-02.csv", filepath.Join(csvDir, "BTCUSDT-aggTrades-2021-01-02.zip")); err != nil {
+		t.Fatalf("Failed to write zip file: %v", err)
+	}
+
+	if err := ReadAllCSVToStructsAndSaveToParquet(csvDir, parquetDir, AggTradeRawToStruct); err != nil {
+		t.Fatalf("ReadAllCSVToStructsAndSaveToParquet failed: %v", err)
+	}
+
+	for _, name := range []string{"BTCUSDT-aggTrades-2021-01-01", "BTCUSDT-aggTrades-2021-01-02"} {
+		filePath := filepath.Join(parquetDir, name+PARQUET_FILE_EXT)
+		file, err := os.Open(filePath)
+		if err != nil {
+			t.Fatalf("Failed to open parquet file: %v", err)
+		}
+		info, _ := file.Stat()
+		pf, err := parquet.OpenFile(file, info.Size())
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		if pf.NumRows() != 2 {
+			t.Errorf("%s: Expected 2 rows, got %d", name, pf.NumRows())
+		}
+		column := pf.Metadata().RowGroups[0].Columns[0]
+		if len(column.MetaData.Statistics.MinValue) == 0 || len(column.MetaData.Statistics.MaxValue) == 0 {
+			t.Errorf("%s: Expected row group statistics", name)
+		}
+		file.Close()
+	}
+}
@@ -0,0 +1,190 @@
+package bncvision
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HTTPStatusError is returned when the server responds with an unexpected status code.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	// RetryAfter is the Retry-After header of 429 and 503 responses, 0 if not present.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d from %s", e.StatusCode, e.URL)
+}
+
+func newHTTPStatusError(url string, resp *http.Response) *HTTPStatusError {
+	err := &HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+	if seconds, e := strconv.Atoi(resp.Header.Get("Retry-After")); e == nil && seconds > 0 {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return err
+}
+
+// IsPermanentDownloadError returns true if retrying err can not succeed,
+// such as 404 of a key which does not exist.
+// Client errors are permanent except 408 and 429, server errors and network errors are transient.
+func IsPermanentDownloadError(err error) bool {
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	code := statusErr.StatusCode
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+// RetryPolicy is the exponential backoff with jitter between download attempts.
+// The zero RetryPolicy retries immediately.
+type RetryPolicy struct {
+	// BaseDelay is the delay after the first failed attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay, no cap if 0.
+	MaxDelay time.Duration
+	// Multiplier grows the delay after each failed attempt, 2 if less than 1.
+	Multiplier float64
+	// Jitter is the fraction of the delay randomized, 0.2 means the delay is in [0.8, 1.2] * delay.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the retry policy of NewClient.
+var DefaultRetryPolicy = RetryPolicy{
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns the delay after the failed attempt, attempt starts from 0.
+// The Retry-After of err is respected if it's longer than the backoff.
+func (p RetryPolicy) Delay(attempt int, err error) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(p.BaseDelay)
+	for i := 0; i < attempt; i++ {
+		delay *= multiplier
+		if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	d := time.Duration(delay)
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > d {
+		d = statusErr.RetryAfter
+	}
+	return d
+}
+
+// wait sleeps before the next attempt of the failed attempt.
+func (p RetryPolicy) wait(attempt int, err error) {
+	if d := p.Delay(attempt, err); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// retryQuery calls query at most tryCount times with retry between attempts, permanent errors are never retried.
+// msg and args are logged for every failed attempt which is retried.
+func retryQuery[T any](retry RetryPolicy, tryCount int, query func() (T, error), msg string, args ...any) (result T, err error) {
+	if tryCount <= 0 {
+		tryCount = 1
+	}
+	for i := 0; i < tryCount; i++ {
+		result, err = query()
+		if err == nil || IsPermanentDownloadError(err) || i == tryCount-1 {
+			return
+		}
+		gLogger.Error(msg, append(args, "attempt", i+1, "error", err)...)
+		retry.wait(i, err)
+	}
+	return
+}
+
+// RateLimiter limits requests and bytes per second with token buckets.
+// One RateLimiter is shared by all goroutines of a Client, so concurrent workers are limited together.
+// A nil RateLimiter does not limit anything.
+type RateLimiter struct {
+	requests *tokenBucket
+	bytes    *tokenBucket
+}
+
+// NewRateLimiter returns a RateLimiter, a limit less than or equal to 0 means unlimited.
+//
+// Parameters:
+//   - requestsPerSecond: The max number of requests per second, bursting up to one second of requests.
+//   - bytesPerSecond: The max number of downloaded bytes per second, bursting up to one second of bytes.
+func NewRateLimiter(requestsPerSecond, bytesPerSecond float64) *RateLimiter {
+	return &RateLimiter{
+		requests: newTokenBucket(requestsPerSecond),
+		bytes:    newTokenBucket(bytesPerSecond),
+	}
+}
+
+// WaitRequest blocks until a request is allowed.
+func (l *RateLimiter) WaitRequest() {
+	if l == nil {
+		return
+	}
+	l.requests.take(1)
+}
+
+// WaitBytes blocks until n downloaded bytes are allowed.
+func (l *RateLimiter) WaitBytes(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.bytes.take(float64(n))
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// take takes n tokens, and sleeps until the bucket is not in debt.
+// n may be larger than the burst, the debt is paid by the following sleep.
+func (b *tokenBucket) take(n float64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= n
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,202 @@
+package bncvision
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2}
+	expected := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, e := range expected {
+		if d := p.Delay(i, nil); d != e*time.Millisecond {
+			t.Errorf("Attempt %d: expected %v, got %v", i, e*time.Millisecond, d)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.Delay(1, nil); d < 100*time.Millisecond || d > 300*time.Millisecond {
+			t.Fatalf("Delay %v out of jitter range", d)
+		}
+	}
+
+	err := fmt.Errorf("wrapped: %w", &HTTPStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 5 * time.Second})
+	if d := p.Delay(0, err); d != 5*time.Second {
+		t.Errorf("Expected Retry-After 5s, got %v", d)
+	}
+
+	if d := (RetryPolicy{}).Delay(3, nil); d != 0 {
+		t.Errorf("Zero policy should not wait, got %v", d)
+	}
+}
+
+func TestIsPermanentDownloadError(t *testing.T) {
+	cases := map[int]bool{
+		http.StatusNotFound:            true,
+		http.StatusForbidden:           true,
+		http.StatusTooManyRequests:     false,
+		http.StatusRequestTimeout:      false,
+		http.StatusServiceUnavailable:  false,
+		http.StatusInternalServerError: false,
+	}
+	for code, permanent := range cases {
+		err := fmt.Errorf("wrapped: %w", &HTTPStatusError{StatusCode: code})
+		if IsPermanentDownloadError(err) != permanent {
+			t.Errorf("Status %d: expected permanent %v", code, permanent)
+		}
+	}
+	if IsPermanentDownloadError(errors.New("connection reset")) {
+		t.Errorf("Network errors should be transient")
+	}
+}
+
+func TestClientDownloadRetry(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/flaky":
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{
+		HTTPClient: server.Client(),
+		Retry:      RetryPolicy{BaseDelay: time.Millisecond},
+	}
+
+	_, statusCode, err := client.DownloadWithRetry(server.URL+"/missing", 5)
+	if err == nil || statusCode != http.StatusNotFound || !IsPermanentDownloadError(err) {
+		t.Errorf("Expected permanent 404, got %d %v", statusCode, err)
+	}
+	if requests != 1 {
+		t.Errorf("404 should not be retried, got %d requests", requests)
+	}
+
+	requests = 0
+	data, _, err := client.DownloadWithRetry(server.URL+"/flaky", 5)
+	if err != nil || string(data) != "ok" {
+		t.Errorf("Expected ok after retries, got %q %v", data, err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+
+	requests = 0
+	savePath := filepath.Join(t.TempDir(), "missing.zip")
+	err = client.DownloadSaveZipWithRetryAndValidate(savePath, server.URL+"/missing.zip", 5)
+	if !IsPermanentDownloadError(err) || requests != 1 {
+		t.Errorf("Expected 1 request and permanent error, got %d %v", requests, err)
+	}
+	if exists, _ := FileExists(savePath + DOWNLOAD_PART_SUFFIX); exists {
+		t.Errorf("Part file of a missing key should be removed")
+	}
+}
+
+// apiTransport sends the requests of the Binance apis to a test server.
+type apiTransport struct {
+	server *httptest.Server
+}
+
+func (t apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = strings.TrimPrefix(t.server.URL, "http://")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClientDownloadMissingRetry(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests%2 == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v3/historicalTrades":
+			w.Write([]byte(`[{"id":3,"price":"1","qty":"1","quoteQty":"1","time":1609459200003,"isBuyerMaker":true,"isBestMatch":true}]`))
+		case "/api/v3/klines":
+			w.Write([]byte(`[[1609459200000,"1","1","1","1","1",1609459259999,"1",1,"1","1","0"]]`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{
+		HTTPClient: &http.Client{Transport: apiTransport{server}},
+		Retry:      RetryPolicy{BaseDelay: time.Millisecond},
+	}
+
+	trades, err := client.DownloadMissingSpotTrades("BTCUSDT", MissingRange{StartId: 3, EndId: 3})
+	if err != nil || len(trades) != 1 || trades[0].Id != 3 {
+		t.Errorf("Expected trade 3 after a 429, got %v %v", trades, err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests)
+	}
+
+	requests = 0
+	missing := MissingRange{StartId: 1609459200000, EndId: 1609459200000}
+	klines, err := client.DownloadMissingKlines(MarketSpot, "BTCUSDT", Kline1m, missing)
+	if err != nil || len(klines) != 1 || klines[0].OpenTime != missing.StartId || klines[0].TradesNumber != 1 {
+		t.Errorf("Expected one kline after a 429, got %v %v", klines, err)
+	}
+
+	requests = 1
+	if _, err := client.DownloadMissingKlines(MarketFuturesUM, "BTCUSDT", Kline1m, missing); !IsPermanentDownloadError(err) || requests != 2 {
+		t.Errorf("Expected one request and permanent error, got %d %v", requests-1, err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	var nilLimiter *RateLimiter
+	nilLimiter.WaitRequest()
+	nilLimiter.WaitBytes(1 << 30)
+
+	limiter := NewRateLimiter(10, 0)
+	start := time.Now()
+	// 10 requests of burst, then 5 requests at 10 per second
+	for i := 0; i < 15; i++ {
+		limiter.WaitRequest()
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected about 500ms, got %v", elapsed)
+	}
+
+	root := t.TempDir()
+	data := make([]byte, 150_000)
+	if err := os.WriteFile(filepath.Join(root, "file"), data, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	fake := NewFakeDataVisionServer(root, 0)
+	defer fake.Close()
+	client := fake.Client()
+	client.Limiter = NewRateLimiter(0, 100_000)
+
+	start = time.Now()
+	n, err := client.DownloadToFile(fake.URL()+"/file", filepath.Join(t.TempDir(), "file"))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("DownloadToFile failed: %d %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected about 500ms, got %v", elapsed)
+	}
+}
@@ -0,0 +1,463 @@
+package bncvision
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// datasetTimeColumn returns the index of the time column used to split rows into days.
+func datasetTimeColumn(dataType DataType) (int, error) {
+	switch dataType {
+	case DataTypeAggTrades, DataTypeBookTicker:
+		return 5, nil
+	case DataTypeTrades:
+		return 4, nil
+	case DataTypeFundingRate:
+		return 0, nil
+	}
+	if dataType.HasInterval() {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%w: reconciling %s is not supported", ErrInvalidDatasetSpec, dataType)
+}
+
+// rowTimeToMilli converts Binance Vision timestamps to milliseconds,
+// newer spot files use microseconds.
+func rowTimeToMilli(ts int64) int64 {
+	if ts >= 1e15 {
+		return ts / 1000
+	}
+	return ts
+}
+
+// scanArchiveRows calls fn with the millisecond time of the time column and the line of every row of a csv or zip file.
+// The first line is skipped as a header if its time column can not be parsed. The line is only valid during the call.
+func scanArchiveRows(filePath string, timeColumn int, fn func(ts int64, line []byte) error) error {
+	r, closers, err := openCSVFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}()
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte{'\r'})
+		if len(line) == 0 {
+			continue
+		}
+		p := lineFieldParser{line: line}
+		var field []byte
+		for i := 0; i <= timeColumn; i++ {
+			field, err = p.next()
+			if err != nil {
+				break
+			}
+		}
+		var ts int64
+		if err == nil {
+			ts, err = parseLineInt(field)
+		}
+		if err != nil {
+			if lineNum == 1 {
+				// header
+				continue
+			}
+			return fmt.Errorf("%s line %d: %w", filePath, lineNum, err)
+		}
+		if err := fn(rowTimeToMilli(ts), line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ArchiveDaySummary summarizes the rows of one day in an archive.
+type ArchiveDaySummary struct {
+	Archive string
+	Rows    int
+	// Sha256 is the hex sha256 of the rows of the day, each followed by "\n", headers excluded.
+	Sha256 string
+}
+
+// DayCoverage is the coverage of one day across the monthly and daily archives.
+type DayCoverage struct {
+	Date    time.Time
+	Daily   *ArchiveDaySummary
+	Monthly *ArchiveDaySummary
+	// Conflict is true if both archives cover the day with different rows.
+	Conflict bool
+	// Canonical is the frequency of the archive chosen for the day.
+	// The archive with more rows is chosen, and the daily archive if they have the same rows.
+	Canonical Frequency
+}
+
+// CanonicalSummary returns the summary of the canonical archive of the day.
+func (d DayCoverage) CanonicalSummary() *ArchiveDaySummary {
+	if d.Canonical == FrequencyMonthly {
+		return d.Monthly
+	}
+	return d.Daily
+}
+
+// ReconcileReport is the day by day coverage of a dataset across its local monthly and daily archives.
+type ReconcileReport struct {
+	Spec DatasetSpec
+	// Days are sorted by date, days without any rows in any archive are not included.
+	Days []DayCoverage
+}
+
+// Conflicts returns the days covered by both archives with different rows.
+func (r ReconcileReport) Conflicts() []DayCoverage {
+	var conflicts []DayCoverage
+	for _, day := range r.Days {
+		if day.Conflict {
+			conflicts = append(conflicts, day)
+		}
+	}
+	return conflicts
+}
+
+type daySummaryBuilder struct {
+	rows int
+	hash hash.Hash
+}
+
+func (b *daySummaryBuilder) add(line []byte) {
+	b.rows++
+	b.hash.Write(line)
+	b.hash.Write([]byte{'\n'})
+}
+
+// summarizeArchiveDays summarizes the rows of an archive by day.
+// All rows of a daily archive belong to its date, rows of a monthly archive are split by their time.
+func summarizeArchiveDays(archive DatasetSpec, filePath string, timeColumn int) (map[time.Time]*ArchiveDaySummary, error) {
+	builders := map[time.Time]*daySummaryBuilder{}
+	err := scanArchiveRows(filePath, timeColumn, func(ts int64, line []byte) error {
+		day := truncateDay(archive.Date)
+		if archive.Frequency == FrequencyMonthly {
+			day = truncateDay(time.UnixMilli(ts))
+		}
+		b := builders[day]
+		if b == nil {
+			b = &daySummaryBuilder{hash: sha256.New()}
+			builders[day] = b
+		}
+		b.add(line)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	summaries := map[time.Time]*ArchiveDaySummary{}
+	for day, b := range builders {
+		summaries[day] = &ArchiveDaySummary{
+			Archive: filePath,
+			Rows:    b.rows,
+			Sha256:  hex.EncodeToString(b.hash.Sum(nil)),
+		}
+	}
+	if archive.Frequency == FrequencyDaily && len(summaries) == 0 {
+		summaries[truncateDay(archive.Date)] = &ArchiveDaySummary{Archive: filePath, Sha256: hex.EncodeToString(sha256.New().Sum(nil))}
+	}
+	return summaries, nil
+}
+
+// localDatasetArchives lists the local archives of spec with frequency in layout.
+func localDatasetArchives(spec DatasetSpec, frequency Frequency, layout Layout) ([]DatasetSpec, error) {
+	spec.Frequency = frequency
+	spec.Date = time.Time{}
+	dir := spec.LocalArchiveDir(layout)
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var archives []DatasetSpec
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".zip") {
+			continue
+		}
+		archive, err := ParseDatasetPath(filepath.Join(dir, file.Name()))
+		if err != nil {
+			gLogger.Warn("Unknown Archive", "file", file.Name(), "error", err)
+			continue
+		}
+		archives = append(archives, archive)
+	}
+	return archives, nil
+}
+
+// ReconcileDataset builds the day by day coverage of spec across its local monthly and daily archives in layout,
+// flags the days whose rows differ between the archives, and chooses one canonical archive for every day.
+//
+// Parameters:
+//   - spec: The market, data type, symbol and interval to be reconciled, Frequency and Date are ignored.
+//   - layout: The local data roots.
+//   - maxCpus: The max number of archives read at the same time.
+//
+// Returns:
+//   - The reconcile report.
+//   - An error if any archive can not be read, nil otherwise.
+func ReconcileDataset(spec DatasetSpec, layout Layout, maxCpus int) (ReconcileReport, error) {
+	spec.Frequency = FrequencyDaily
+	spec.Date = time.Time{}
+	report := ReconcileReport{Spec: spec}
+	if err := spec.Validate(); err != nil {
+		return report, err
+	}
+	timeColumn, err := datasetTimeColumn(spec.DataType)
+	if err != nil {
+		return report, err
+	}
+
+	var archives []DatasetSpec
+	for _, frequency := range []Frequency{FrequencyMonthly, FrequencyDaily} {
+		frequencyArchives, err := localDatasetArchives(spec, frequency, layout)
+		if err != nil {
+			return report, err
+		}
+		archives = append(archives, frequencyArchives...)
+	}
+
+	if maxCpus <= 0 {
+		maxCpus = 1
+	}
+
+	days := map[time.Time]*DayCoverage{}
+	wg := errgroup.Group{}
+	wg.SetLimit(maxCpus)
+	mu := sync.Mutex{}
+
+	for _, archive := range archives {
+		archive := archive
+		wg.Go(func() error {
+			summaries, err := summarizeArchiveDays(archive, archive.LocalArchivePath(layout), timeColumn)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for day, summary := range summaries {
+				coverage := days[day]
+				if coverage == nil {
+					coverage = &DayCoverage{Date: day}
+					days[day] = coverage
+				}
+				if archive.Frequency == FrequencyMonthly {
+					coverage.Monthly = summary
+				} else {
+					coverage.Daily = summary
+				}
+			}
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return report, err
+	}
+
+	// a monthly archive covers all days of its month, even the days it has no rows
+	monthlyArchives := map[time.Time]string{}
+	for _, archive := range archives {
+		if archive.Frequency == FrequencyMonthly {
+			monthlyArchives[truncateMonth(archive.Date)] = archive.LocalArchivePath(layout)
+		}
+	}
+
+	for _, coverage := range days {
+		if coverage.Monthly == nil {
+			if archive, ok := monthlyArchives[truncateMonth(coverage.Date)]; ok {
+				coverage.Monthly = &ArchiveDaySummary{Archive: archive, Sha256: hex.EncodeToString(sha256.New().Sum(nil))}
+			}
+		}
+		switch {
+		case coverage.Daily != nil && coverage.Monthly != nil:
+			coverage.Conflict = coverage.Daily.Rows != coverage.Monthly.Rows || coverage.Daily.Sha256 != coverage.Monthly.Sha256
+			coverage.Canonical = FrequencyDaily
+			if coverage.Monthly.Rows > coverage.Daily.Rows {
+				coverage.Canonical = FrequencyMonthly
+			}
+		case coverage.Daily != nil:
+			coverage.Canonical = FrequencyDaily
+		default:
+			coverage.Canonical = FrequencyMonthly
+		}
+		if coverage.Conflict {
+			gLogger.Warn("Conflicting Archives", "date", coverage.Date.Format(time.DateOnly),
+				"dailyRows", coverage.Daily.Rows, "monthlyRows", coverage.Monthly.Rows, "canonical", coverage.Canonical)
+		}
+		report.Days = append(report.Days, *coverage)
+	}
+
+	sort.Slice(report.Days, func(i, j int) bool {
+		return report.Days[i].Date.Before(report.Days[j].Date)
+	})
+
+	return report, nil
+}
+
+// WriteCanonicalDailyCSV writes one csv file per day of the report to the daily unzip directory of the spec in layout,
+// such as <layout.Unzip>/data/spot/daily/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2024-01-01.csv,
+// with the rows of the canonical archive of the day, so the tidy step never sees duplicated rows.
+// Headers are not written, existing files are replaced.
+//
+// Parameters:
+//   - report: The reconcile report of ReconcileDataset.
+//   - layout: The local data roots.
+//   - maxCpus: The max number of archives read at the same time.
+//
+// Returns:
+//   - An error if any archive can not be read or any csv file can not be written, nil otherwise.
+func WriteCanonicalDailyCSV(report ReconcileReport, layout Layout, maxCpus int) error {
+	timeColumn, err := datasetTimeColumn(report.Spec.DataType)
+	if err != nil {
+		return err
+	}
+
+	// days grouped by their canonical archives, so every archive is read once
+	archiveDays := map[string]map[time.Time]bool{}
+	archiveFrequencies := map[string]Frequency{}
+	for _, day := range report.Days {
+		summary := day.CanonicalSummary()
+		if archiveDays[summary.Archive] == nil {
+			archiveDays[summary.Archive] = map[time.Time]bool{}
+		}
+		archiveDays[summary.Archive][day.Date] = true
+		archiveFrequencies[summary.Archive] = day.Canonical
+	}
+
+	if maxCpus <= 0 {
+		maxCpus = 1
+	}
+
+	wg := errgroup.Group{}
+	wg.SetLimit(maxCpus)
+
+	for archive, days := range archiveDays {
+		archive, days := archive, days
+		frequency := archiveFrequencies[archive]
+		wg.Go(func() error {
+			return writeCanonicalDays(report.Spec, layout, archive, frequency, days, timeColumn)
+		})
+	}
+
+	return wg.Wait()
+}
+
+type canonicalDayFile struct {
+	file   *os.File
+	writer *bufio.Writer
+	rows   int
+}
+
+// writeCanonicalDays writes the rows of days in archive to their daily csv files.
+func writeCanonicalDays(spec DatasetSpec, layout Layout, archive string, frequency Frequency, days map[time.Time]bool, timeColumn int) (err error) {
+	daily := spec
+	daily.Frequency = FrequencyDaily
+
+	var dailyDate time.Time
+	if frequency == FrequencyDaily {
+		archiveSpec, err := ParseDatasetPath(archive)
+		if err != nil {
+			return err
+		}
+		dailyDate = truncateDay(archiveSpec.Date)
+	}
+
+	files := map[time.Time]*canonicalDayFile{}
+	defer func() {
+		for _, f := range files {
+			f.file.Close()
+			if err != nil {
+				os.Remove(f.file.Name())
+			}
+		}
+	}()
+
+	open := func(day time.Time) (*canonicalDayFile, error) {
+		daily.Date = day
+		filePath := daily.UnzipPath(layout)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return nil, err
+		}
+		file, err := os.Create(filePath + ".tmp")
+		if err != nil {
+			return nil, err
+		}
+		f := &canonicalDayFile{file: file, writer: bufio.NewWriter(file)}
+		files[day] = f
+		return f, nil
+	}
+
+	err = scanArchiveRows(archive, timeColumn, func(ts int64, line []byte) error {
+		day := dailyDate
+		if frequency == FrequencyMonthly {
+			day = truncateDay(time.UnixMilli(ts))
+		}
+		if !days[day] {
+			return nil
+		}
+		f := files[day]
+		if f == nil {
+			var err error
+			f, err = open(day)
+			if err != nil {
+				return err
+			}
+		}
+		if f.rows > 0 {
+			f.writer.WriteByte('\n')
+		}
+		f.rows++
+		_, err := f.writer.Write(line)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// days without rows still get empty files
+	for day := range days {
+		if files[day] == nil {
+			if _, err = open(day); err != nil {
+				return err
+			}
+		}
+	}
+
+	for day, f := range files {
+		if err = f.writer.Flush(); err != nil {
+			return err
+		}
+		if err = f.file.Close(); err != nil {
+			return err
+		}
+		daily.Date = day
+		if err = os.Rename(f.file.Name(), daily.UnzipPath(layout)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package bncvision
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconcileDataset(t *testing.T) {
+	layout := NewLayout(t.TempDir())
+	spec := DatasetSpec{Market: MarketSpot, DataType: DataTypeAggTrades, Symbol: "BTCUSDT"}
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	row := func(id, d int) string {
+		ts := day(d).UnixMilli() + int64(id)
+		return strings.Join([]string{itoa(id), "100", "1", itoa(id), itoa(id), itoa64(ts), "true", "true"}, ",")
+	}
+	archive := func(frequency Frequency, d int, rows ...string) {
+		s := spec
+		s.Frequency = frequency
+		s.Date = day(d)
+		writeTestDatasetZip(t, layout.Raw, s, []byte(strings.Join(rows, "\n")))
+	}
+
+	monthlyRows := []string{row(1, 1), row(2, 1), row(3, 2), row(4, 2), row(5, 3)}
+	archive(FrequencyMonthly, 1, monthlyRows...)
+	// the same as the monthly archive, with a header
+	archive(FrequencyDaily, 1, "agg_trade_id,price,quantity,first_trade_id,last_trade_id,transact_time,is_buyer_maker,is_best_match", row(1, 1), row(2, 1))
+	// the same row count, different content
+	archive(FrequencyDaily, 2, row(3, 2), strings.Replace(row(4, 2), ",100,", ",101,", 1))
+	// not in the monthly archive, in microseconds
+	archive(FrequencyDaily, 4, strings.Replace(row(6, 4), itoa64(day(4).UnixMilli()+6), itoa64((day(4).UnixMilli()+6)*1000), 1))
+
+	report, err := ReconcileDataset(spec, layout, 2)
+	if err != nil {
+		t.Fatalf("ReconcileDataset failed: %v", err)
+	}
+	if len(report.Days) != 4 {
+		t.Fatalf("Expected 4 days, got %d", len(report.Days))
+	}
+
+	expected := []struct {
+		date      time.Time
+		conflict  bool
+		canonical Frequency
+		rows      int
+	}{
+		{day(1), false, FrequencyDaily, 2},
+		{day(2), true, FrequencyDaily, 2},
+		{day(3), false, FrequencyMonthly, 1},
+		{day(4), true, FrequencyDaily, 1},
+	}
+	for i, e := range expected {
+		d := report.Days[i]
+		if !d.Date.Equal(e.date) || d.Conflict != e.conflict || d.Canonical != e.canonical || d.CanonicalSummary().Rows != e.rows {
+			t.Errorf("Day %d: expected %+v, got date %v conflict %v canonical %s rows %d", i, e, d.Date, d.Conflict, d.Canonical, d.CanonicalSummary().Rows)
+		}
+	}
+	if conflicts := report.Conflicts(); len(conflicts) != 2 {
+		t.Errorf("Expected 2 conflicts, got %d", len(conflicts))
+	}
+	if report.Days[3].Monthly == nil || report.Days[3].Monthly.Rows != 0 {
+		t.Errorf("Monthly archive should cover day 4 with 0 rows")
+	}
+
+	if err := WriteCanonicalDailyCSV(report, layout, 2); err != nil {
+		t.Fatalf("WriteCanonicalDailyCSV failed: %v", err)
+	}
+	daily := spec
+	daily.Frequency = FrequencyDaily
+	for i, e := range []string{
+		row(1, 1) + "\n" + row(2, 1),
+		row(3, 2) + "\n" + strings.Replace(row(4, 2), ",100,", ",101,", 1),
+		row(5, 3),
+	} {
+		daily.Date = day(i + 1)
+		data, err := os.ReadFile(daily.UnzipPath(layout))
+		if err != nil {
+			t.Fatalf("Failed to read canonical file: %v", err)
+		}
+		if string(data) != e {
+			t.Errorf("Day %d: expected %q, got %q", i+1, e, data)
+		}
+	}
+
+	trades, err := ReadLinesToStructs(daily.UnzipPath(layout), AggTradeLineToStruct)
+	if err != nil || len(trades) != 1 || trades[0].Id != 5 {
+		t.Errorf("Canonical file should be readable, got %v %v", trades, err)
+	}
+}
+
+func itoa(i int) string {
+	return itoa64(int64(i))
+}
+
+func itoa64(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
@@ -0,0 +1,252 @@
+package bncvision
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// SYNC_MANIFEST_FILE_NAME is the name of the manifest file in the local directory of a prefix.
+const SYNC_MANIFEST_FILE_NAME = ".manifest.json"
+
+// SyncManifestEntry records the listing metadata of a downloaded key.
+type SyncManifestEntry struct {
+	Key          string `json:"key"`
+	ETag         string `json:"etag"`
+	Size         int64  `json:"size"`
+	LastModified string `json:"lastModified"`
+	// SyncedAt is the unix milliseconds when the key was downloaded or adopted.
+	SyncedAt int64 `json:"syncedAt"`
+}
+
+// SyncManifest records all downloaded keys directly under a prefix.
+type SyncManifest struct {
+	Prefix  string                       `json:"prefix"`
+	Entries map[string]SyncManifestEntry `json:"entries"`
+}
+
+// NewSyncManifest returns an empty manifest of prefix.
+func NewSyncManifest(prefix string) *SyncManifest {
+	return &SyncManifest{
+		Prefix:  strings.Trim(prefix, "/") + "/",
+		Entries: map[string]SyncManifestEntry{},
+	}
+}
+
+// SyncManifestPath returns the manifest file path of prefix under layout.Raw,
+// such as <layout.Raw>/data/spot/daily/aggTrades/BTCUSDT/.manifest.json.
+func SyncManifestPath(layout Layout, prefix string) string {
+	return filepath.Join(layout.Raw, filepath.FromSlash(strings.Trim(prefix, "/")), SYNC_MANIFEST_FILE_NAME)
+}
+
+// LoadSyncManifest reads the manifest file of prefix under layout.Raw.
+// An empty manifest is returned if the manifest file does not exist.
+func LoadSyncManifest(layout Layout, prefix string) (*SyncManifest, error) {
+	manifest := NewSyncManifest(prefix)
+	data, err := os.ReadFile(SyncManifestPath(layout, prefix))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sync manifest: %w", err)
+	}
+	if manifest.Entries == nil {
+		manifest.Entries = map[string]SyncManifestEntry{}
+	}
+	return manifest, nil
+}
+
+// Save writes the manifest to its file under layout.Raw.
+// It writes a temporary file first and renames it, so a crash never leaves a broken manifest.
+func (m *SyncManifest) Save(layout Layout) error {
+	filePath := SyncManifestPath(layout, m.Prefix)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+// Changed returns true if content is not in the manifest,
+// or its ETag, Size or LastModified differs from the manifest entry.
+func (m *SyncManifest) Changed(content DataVisionXMLContent) bool {
+	entry, ok := m.Entries[content.Key]
+	return !ok ||
+		entry.ETag != content.ETag ||
+		entry.Size != content.Size ||
+		entry.LastModified != content.LastModified
+}
+
+func (m *SyncManifest) set(content DataVisionXMLContent) {
+	m.Entries[content.Key] = SyncManifestEntry{
+		Key:          content.Key,
+		ETag:         content.ETag,
+		Size:         content.Size,
+		LastModified: content.LastModified,
+		SyncedAt:     time.Now().UnixMilli(),
+	}
+}
+
+// SyncResult reports what a sync changed, keys are sorted.
+type SyncResult struct {
+	// Added are the keys downloaded for the first time.
+	Added []string
+	// Changed are the keys republished since the last sync and downloaded again.
+	Changed []string
+	// Adopted are the keys which were already on disk with the listed size but not in the manifest.
+	Adopted []string
+	// Removed are the keys in the manifest which are not listed any more, local files are kept.
+	Removed []string
+	// Unchanged is the number of keys matching the manifest.
+	Unchanged int
+	// Failed are the contents failed to be downloaded, they will be retried by the next sync.
+	Failed []DataVisionXMLContent
+}
+
+// SyncPrefix incrementally syncs the zip files directly under prefix to layout.Raw.
+// It lists prefix once, diffs the listing against the manifest of prefix,
+// and only downloads new or changed zip files.
+// Files already on disk but not in the manifest, such as files downloaded by DownloadWithXMLContents,
+// are adopted without downloading if their sizes match the listing.
+//
+// Parameters:
+//   - prefix: The prefix to be synced, such as data/spot/daily/aggTrades/BTCUSDT/.
+//   - layout: The local data roots, the zip files are saved under layout.Raw, the same layout as the bucket.
+//   - maxDownloadingNum: The max number of files downloaded at the same time.
+//
+// Returns:
+//   - The sync result.
+//   - An error if listing, downloading or saving the manifest fails, nil otherwise.
+//     The manifest is saved even if some files fail to be downloaded.
+func (c *Client) SyncPrefix(prefix string, layout Layout, maxDownloadingNum int8) (result SyncResult, err error) {
+	manifest, err := LoadSyncManifest(layout, prefix)
+	if err != nil {
+		return
+	}
+
+	_, _, contents, err := c.QueryDataVisionXML(prefix, "")
+	if err != nil {
+		return
+	}
+
+	if maxDownloadingNum <= 0 {
+		maxDownloadingNum = 1
+	}
+
+	// the manifest is only read and written by this goroutine,
+	// workers report the synced contents, which are set to the manifest after all workers finish
+	listed := map[string]bool{}
+	type syncTask struct {
+		content    DataVisionXMLContent
+		inManifest bool
+	}
+	var tasks []syncTask
+
+	for _, content := range contents {
+		if !strings.HasSuffix(content.Key, ".zip") {
+			continue
+		}
+		listed[content.Key] = true
+		if !manifest.Changed(content) {
+			result.Unchanged++
+			continue
+		}
+
+		_, inManifest := manifest.Entries[content.Key]
+
+		if !inManifest {
+			fileLocation := filepath.Join(layout.Raw, filepath.FromSlash(content.Key))
+			info, err := os.Stat(fileLocation)
+			if err == nil && info.Size() == content.Size {
+				slog.Info("Adopting Existing File", "file", fileLocation)
+				manifest.set(content)
+				result.Adopted = append(result.Adopted, content.Key)
+				continue
+			}
+		}
+
+		tasks = append(tasks, syncTask{content: content, inManifest: inManifest})
+	}
+
+	wg := errgroup.Group{}
+	wg.SetLimit(int(maxDownloadingNum))
+	mu := sync.Mutex{}
+	var synced []DataVisionXMLContent
+
+	for _, task := range tasks {
+		task := task
+		wg.Go(func() error {
+			// a republished file is downloaded to a part file and renamed over the old file,
+			// so the old file is kept if the download fails
+			fileLocation := filepath.Join(layout.Raw, filepath.FromSlash(task.content.Key))
+			err := c.DownloadSaveZipWithRetryAndValidate(fileLocation, c.FileURL(task.content.Key), 3)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				gLogger.Error("Failed To Sync File", "key", task.content.Key, "error", err)
+				result.Failed = append(result.Failed, task.content)
+				return err
+			}
+			if task.inManifest {
+				result.Changed = append(result.Changed, task.content.Key)
+			} else {
+				result.Added = append(result.Added, task.content.Key)
+			}
+			synced = append(synced, task.content)
+			return nil
+		})
+	}
+
+	err = wg.Wait()
+
+	for _, content := range synced {
+		manifest.set(content)
+	}
+
+	for key := range manifest.Entries {
+		if !listed[key] {
+			result.Removed = append(result.Removed, key)
+		}
+	}
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Changed)
+	sort.Strings(result.Adopted)
+	sort.Strings(result.Removed)
+	sort.Slice(result.Failed, func(i, j int) bool {
+		return result.Failed[i].Key < result.Failed[j].Key
+	})
+
+	if saveErr := manifest.Save(layout); saveErr != nil && err == nil {
+		err = saveErr
+	}
+
+	gLogger.Info("Prefix Synced", "prefix", prefix,
+		"added", len(result.Added), "changed", len(result.Changed), "adopted", len(result.Adopted),
+		"removed", len(result.Removed), "unchanged", result.Unchanged, "failed", len(result.Failed))
+
+	return
+}
+
+// SyncPrefix syncs prefix with DefaultClient, see Client.SyncPrefix.
+func SyncPrefix(prefix string, layout Layout, maxDownloadingNum int8) (SyncResult, error) {
+	return DefaultClient.SyncPrefix(prefix, layout, maxDownloadingNum)
+}
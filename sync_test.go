@@ -0,0 +1,121 @@
+package bncvision
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+type countingTransport struct {
+	base  http.RoundTripper
+	count atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.count.Add(1)
+	return t.base.RoundTrip(req)
+}
+
+func TestClientSyncPrefix(t *testing.T) {
+	root, prefix := newTestDataVisionRoot(t, 3)
+	server := NewFakeDataVisionServer(root, 0)
+	defer server.Close()
+	client := server.Client()
+	transport := &countingTransport{base: client.HTTPClient.Transport}
+	client.HTTPClient = &http.Client{Transport: transport}
+
+	layout := NewLayout(t.TempDir())
+	localDir := layout.Raw
+
+	result, err := client.SyncPrefix(prefix, layout, 2)
+	if err != nil {
+		t.Fatalf("SyncPrefix failed: %v", err)
+	}
+	if len(result.Added) != 3 || len(result.Changed) != 0 || result.Unchanged != 0 {
+		t.Errorf("Unexpected first sync result %+v", result)
+	}
+
+	// nothing changed, only one listing request
+	transport.count.Store(0)
+	result, err = client.SyncPrefix(prefix, layout, 2)
+	if err != nil {
+		t.Fatalf("SyncPrefix failed: %v", err)
+	}
+	if result.Unchanged != 3 || len(result.Added)+len(result.Changed)+len(result.Removed) != 0 {
+		t.Errorf("Unexpected second sync result %+v", result)
+	}
+	if n := transport.count.Load(); n != 1 {
+		t.Errorf("Expected 1 request, got %d", n)
+	}
+
+	// republish a file, publish a new file and delete a file
+	serverDir := filepath.Join(root, filepath.FromSlash(prefix))
+	republished := filepath.Join(serverDir, "BTCUSDT-aggTrades-2024-01-02.zip")
+	if err := ZipDataAndSave([]byte("2,101,1,2,2,1704153600000,true,true"), "BTCUSDT-aggTrades-2024-01-02.csv", republished); err != nil {
+		t.Fatalf("Failed to republish zip: %v", err)
+	}
+	if err := ZipDataAndSave([]byte("4,100,1,4,4,1704326400000,true,true"), "BTCUSDT-aggTrades-2024-01-04.csv", filepath.Join(serverDir, "BTCUSDT-aggTrades-2024-01-04.zip")); err != nil {
+		t.Fatalf("Failed to publish zip: %v", err)
+	}
+	if err := os.Remove(filepath.Join(serverDir, "BTCUSDT-aggTrades-2024-01-03.zip")); err != nil {
+		t.Fatalf("Failed to remove zip: %v", err)
+	}
+
+	result, err = client.SyncPrefix(prefix, layout, 2)
+	if err != nil {
+		t.Fatalf("SyncPrefix failed: %v", err)
+	}
+	if len(result.Changed) != 1 || result.Changed[0] != prefix+"/BTCUSDT-aggTrades-2024-01-02.zip" {
+		t.Errorf("Unexpected changed %v", result.Changed)
+	}
+	if len(result.Added) != 1 || result.Added[0] != prefix+"/BTCUSDT-aggTrades-2024-01-04.zip" {
+		t.Errorf("Unexpected added %v", result.Added)
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != prefix+"/BTCUSDT-aggTrades-2024-01-03.zip" {
+		t.Errorf("Unexpected removed %v", result.Removed)
+	}
+	if result.Unchanged != 1 {
+		t.Errorf("Expected 1 unchanged, got %d", result.Unchanged)
+	}
+
+	expected, _ := os.ReadFile(republished)
+	got, err := os.ReadFile(filepath.Join(localDir, filepath.FromSlash(prefix), "BTCUSDT-aggTrades-2024-01-02.zip"))
+	if err != nil || !bytes.Equal(expected, got) {
+		t.Errorf("Republished file is not downloaded again: %v", err)
+	}
+}
+
+func TestClientSyncPrefixAdopt(t *testing.T) {
+	root, prefix := newTestDataVisionRoot(t, 2)
+	server := NewFakeDataVisionServer(root, 0)
+	defer server.Close()
+	client := server.Client()
+
+	layout := NewLayout(t.TempDir())
+	_, _, contents, err := client.QueryDataVisionXML(prefix, "")
+	if err != nil {
+		t.Fatalf("QueryDataVisionXML failed: %v", err)
+	}
+	if _, err := client.DownloadWithXMLContents(contents, layout, 2); err != nil {
+		t.Fatalf("DownloadWithXMLContents failed: %v", err)
+	}
+
+	result, err := client.SyncPrefix(prefix, layout, 2)
+	if err != nil {
+		t.Fatalf("SyncPrefix failed: %v", err)
+	}
+	if len(result.Adopted) != 2 || len(result.Added) != 0 {
+		t.Errorf("Unexpected sync result %+v", result)
+	}
+
+	manifest, err := LoadSyncManifest(layout, prefix)
+	if err != nil {
+		t.Fatalf("LoadSyncManifest failed: %v", err)
+	}
+	if len(manifest.Entries) != 2 {
+		t.Errorf("Expected 2 manifest entries, got %d", len(manifest.Entries))
+	}
+}
@@ -0,0 +1,640 @@
+package bncvision
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+	"golang.org/x/sync/errgroup"
+)
+
+// MissingRange is a gap in a continuous dataset, StartId and EndId are both inclusive.
+// They are ids for agg trades and trades, and open times for klines.
+// StartTime and EndTime are the times in milliseconds of the items around the gap.
+type MissingRange struct {
+	StartId   int64 `json:"startId"`
+	EndId     int64 `json:"endId"`
+	StartTime int64 `json:"startTime"`
+	EndTime   int64 `json:"endTime"`
+}
+
+// TidyKind tells the tidy engine how to scan, back-fill and merge one data type,
+// so agg trades, trades and klines share the same scan → fetch missing → merge workflow.
+type TidyKind[T any] struct {
+	// Name is the data type name used in logs, such as aggTrades.
+	Name string
+	// Symbol is the symbol of the items, recorded in gap reports.
+	Symbol string
+	// LineToStruct converts a csv line to an item.
+	LineToStruct LineToStructFunc[T]
+	// Key extracts the continuity key of an item, such as the id of trades or the open time of klines.
+	Key func(T) int64
+	// Step returns the difference between the key of an item and the key of the next item,
+	// such as 1 for ids or the interval for open times.
+	Step func(T) int64
+	// Time extracts the time in milliseconds of an item.
+	Time func(T) int64
+	// Backfill downloads the items of a missing range from the api, sorted by key.
+	Backfill func(missing MissingRange) ([]T, error)
+	// CSVRow serializes an item to a csv row without line break.
+	CSVRow func(T) string
+	// Align returns a back-filled item in the units of like, an item of the file it is merged into,
+	// such as klines in microseconds. Nil if items of all files are in the same units.
+	Align func(item, like T) T
+	// Header is the first line of the saved csv files, empty if the layout has no header.
+	Header string
+	// FileName returns the csv file name of the day, such as BTCUSDT-aggTrades-2024-01-01.csv.
+	FileName func(day time.Time) string
+}
+
+// tidyDirFiles returns the csv file names in dir whose dates are not before the day of startTime, sorted.
+func tidyDirFiles(dir string, startTime time.Time) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	start := truncateDay(startTime)
+	var validFiles []string
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".csv") {
+			continue
+		}
+		spec, err := ParseDatasetFileName(file.Name())
+		if err != nil {
+			continue
+		}
+		end := spec.Date.AddDate(0, 0, 1)
+		if spec.Frequency == FrequencyMonthly {
+			end = spec.Date.AddDate(0, 1, 0)
+		}
+		if !end.After(start) {
+			continue
+		}
+		validFiles = append(validFiles, file.Name())
+	}
+	sort.Strings(validFiles)
+	return validFiles, nil
+}
+
+// OneDirMissingRanges scans the csv files in dir and finds the gaps of kind, within and between files.
+//
+// Parameters:
+//   - dir: The directory of the csv files.
+//   - kind: The data type of the csv files.
+//   - maxCpus: The max number of files scanned at the same time.
+//   - startTime: Files before the day of startTime are skipped.
+//
+// Returns:
+//   - The missing ranges sorted by StartId.
+//   - An error if any file can not be read, nil otherwise.
+func OneDirMissingRanges[T any](dir string, kind TidyKind[T], maxCpus int, startTime time.Time) ([]MissingRange, error) {
+	validFiles, err := tidyDirFiles(dir, startTime)
+	if err != nil {
+		return nil, err
+	}
+	gaps, _, err := scanGaps(dir, validFiles, kind, maxCpus, nil)
+	if err != nil {
+		return nil, err
+	}
+	missings := make([]MissingRange, 0, len(gaps))
+	for _, gap := range gaps {
+		missings = append(missings, gap.MissingRange)
+	}
+	return missings, nil
+}
+
+// GapEdge is the last item of a scanned file, so the gap before the next file can be found without scanning it again.
+type GapEdge struct {
+	File string `json:"file"`
+	// NextId is the key expected right after the item.
+	NextId int64 `json:"nextId"`
+	Time   int64 `json:"time"`
+}
+
+func gapEdgeOf[T any](kind TidyKind[T], file string, item T) GapEdge {
+	return GapEdge{File: file, NextId: kind.Key(item) + kind.Step(item), Time: kind.Time(item)}
+}
+
+// Gap is a missing range with the files it's found in.
+type Gap struct {
+	MissingRange
+	// Files are the file of the gap, or the two files around the gap if it's between files.
+	Files []string `json:"files"`
+	// Size is the number of missing items.
+	Size int64 `json:"size"`
+	// Backfilled is true once the missing items are downloaded.
+	Backfilled bool `json:"backfilled"`
+	// Attempts is the number of back-fills which did not cover the gap.
+	Attempts int `json:"attempts"`
+	// Unavailable is true once Attempts reaches MAX_GAP_BACKFILL_ATTEMPTS,
+	// such as the gap of an exchange outage which the api can never fill, it's not back-filled again.
+	Unavailable bool `json:"unavailable"`
+}
+
+// gapAfter returns the gap between edge and the next item in file, ok is false if they are continuous.
+// Repeated or out of order items are not gaps.
+func gapAfter[T any](kind TidyKind[T], edge GapEdge, file string, next T) (gap Gap, ok bool) {
+	if kind.Key(next) <= edge.NextId {
+		return
+	}
+	gap = Gap{
+		MissingRange: MissingRange{
+			StartId:   edge.NextId,
+			EndId:     kind.Key(next) - kind.Step(next),
+			StartTime: edge.Time,
+			EndTime:   kind.Time(next),
+		},
+		Files: []string{file},
+	}
+	gap.Size = (gap.EndId-gap.StartId)/kind.Step(next) + 1
+	if edge.File != file {
+		gap.Files = []string{edge.File, file}
+	}
+	return gap, true
+}
+
+// scanGaps scans the sorted files in dir and finds the gaps within and between them.
+// If prev is not nil, it's the edge of the file scanned before files.
+//
+// Returns:
+//   - The gaps sorted by StartId.
+//   - The edge of the last file which has items, prev if no file has items.
+//   - An error if any file can not be read, nil otherwise.
+func scanGaps[T any](dir string, files []string, kind TidyKind[T], maxCpus int, prev *GapEdge) ([]Gap, *GapEdge, error) {
+	if maxCpus <= 0 {
+		maxCpus = 1
+	}
+
+	wg, ctx := errgroup.WithContext(context.Background())
+	wg.SetLimit(maxCpus)
+
+	firsts := make([]T, len(files))
+	lasts := make([]GapEdge, len(files))
+	hasItems := make([]bool, len(files))
+
+	var gaps []Gap
+	mu := sync.Mutex{}
+
+	for i, file := range files {
+		i, file := i, file
+		wg.Go(func() error {
+			filePath := filepath.Join(dir, file)
+			gLogger.Info("Streaming Lines To Structs", "file", file)
+			stream, err := StreamLinesToStructs(ctx, filePath, kind.LineToStruct)
+			if err != nil {
+				gLogger.Error("Stream Lines To Structs", "file", file, "error", err)
+				return err
+			}
+			defer stream.Close()
+			gLogger.Info("Verifying Continuity", "kind", kind.Name, "file", file)
+			var first, last T
+			var n int
+			for stream.Next() {
+				item := stream.Struct()
+				if n == 0 {
+					first = item
+				} else if gap, ok := gapAfter(kind, gapEdgeOf(kind, file, last), file, item); ok {
+					gLogger.Warn("Missing Items", "kind", kind.Name, "file", file, "from", gap.StartId, "to", gap.EndId)
+					mu.Lock()
+					gaps = append(gaps, gap)
+					mu.Unlock()
+				}
+				last = item
+				n++
+			}
+			if err := stream.Err(); err != nil {
+				gLogger.Error("Stream Lines To Structs", "file", file, "error", err)
+				return err
+			}
+			gLogger.Info("Streamed Lines To Structs", "file", file, "len", n)
+			if n == 0 {
+				return nil
+			}
+			gLogger.Info("Verified Continuity", "kind", kind.Name, "file", file)
+			firsts[i] = first
+			lasts[i] = gapEdgeOf(kind, file, last)
+			hasItems[i] = true
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	for i := range files {
+		if !hasItems[i] {
+			continue
+		}
+		if prev != nil {
+			if gap, ok := gapAfter(kind, *prev, files[i], firsts[i]); ok {
+				gLogger.Warn("Missing Items", "kind", kind.Name, "file", prev.File, "from", gap.StartId, "to", gap.EndId)
+				gaps = append(gaps, gap)
+			}
+		}
+		prev = &lasts[i]
+	}
+
+	sort.Slice(gaps, func(i, j int) bool {
+		return gaps[i].StartId < gaps[j].StartId
+	})
+
+	return gaps, prev, nil
+}
+
+// DownloadMissingAndSave back-fills a missing range of kind and saves the items to dir, see SaveMissingItems.
+func DownloadMissingAndSave[T any](dir string, kind TidyKind[T], missing MissingRange) (items []T, err error) {
+	if kind.Backfill == nil {
+		err = fmt.Errorf("%s has no back-filler", kind.Name)
+		return
+	}
+	items, err = kind.Backfill(missing)
+	if err != nil {
+		return
+	}
+	err = SaveMissingItems(dir, kind, items)
+	return
+}
+
+// ScanOneDirMissingsAndDownload finds the gaps of the csv files in rawDir with the gap report in saveDir,
+// see ScanOneDirGapReport, and back-fills the gaps which are not backfilled yet into saveDir.
+// A gap is only marked backfilled if the back-filled items cover all its keys,
+// otherwise the gap is replaced by its uncovered sub-ranges, which are back-filled again by the next call,
+// until they are Unavailable after MAX_GAP_BACKFILL_ATTEMPTS attempts.
+// The report is saved after every back-filled gap.
+func ScanOneDirMissingsAndDownload[T any](rawDir, saveDir string, kind TidyKind[T], maxCpus int, startTime time.Time) error {
+	err := os.MkdirAll(saveDir, 0777)
+	if err != nil {
+		return err
+	}
+	report, err := ScanOneDirGapReport(rawDir, saveDir, kind, maxCpus, startTime)
+	if err != nil {
+		return err
+	}
+	for _, i := range report.Pending() {
+		missing := report.Gaps[i].MissingRange
+		gLogger.Info("Downloading Missing Items", "kind", kind.Name, "start", time.UnixMilli(missing.StartTime).Format(time.RFC3339Nano), "end", time.UnixMilli(missing.EndTime).Format(time.RFC3339Nano), "from", missing.StartId, "to", missing.EndId)
+		items, err := DownloadMissingAndSave(saveDir, kind, missing)
+		if err != nil {
+			return err
+		}
+		gLogger.Info("Downloaded Missing Items", "kind", kind.Name, "start", time.UnixMilli(missing.StartTime).Format(time.RFC3339Nano), "end", time.UnixMilli(missing.EndTime).Format(time.RFC3339Nano), "from", missing.StartId, "to", missing.EndId, "len", len(items))
+		uncovered := uncoveredKeyRanges(kind, missing, items)
+		if len(uncovered) == 0 {
+			report.Gaps[i].Backfilled = true
+		} else {
+			gLogger.Warn("Missing Items Partially Backfilled", "kind", kind.Name, "from", missing.StartId, "to", missing.EndId, "uncovered", len(uncovered))
+			// pending indexes stay valid, the extra sub-ranges are appended and sorted after the loop
+			original := report.Gaps[i]
+			original.Attempts++
+			if original.Attempts >= MAX_GAP_BACKFILL_ATTEMPTS {
+				original.Unavailable = true
+				gLogger.Warn("Missing Items Unavailable", "kind", kind.Name, "from", missing.StartId, "to", missing.EndId, "attempts", original.Attempts)
+			}
+			for j, r := range uncovered {
+				gap := original
+				if r.StartId != gap.StartId || r.EndId != gap.EndId {
+					// some items are back-filled, so items is not empty
+					gap.StartId, gap.EndId = r.StartId, r.EndId
+					gap.Size = (r.EndId-r.StartId)/kind.Step(items[0]) + 1
+				}
+				if j == 0 {
+					report.Gaps[i] = gap
+				} else {
+					report.Gaps = append(report.Gaps, gap)
+				}
+			}
+		}
+		if err = report.Save(saveDir); err != nil {
+			return err
+		}
+	}
+	sort.Slice(report.Gaps, func(i, j int) bool {
+		return report.Gaps[i].StartId < report.Gaps[j].StartId
+	})
+	return report.Save(saveDir)
+}
+
+type TidyOneDirParams struct {
+	RawDir              string
+	MissingDir          string
+	TidyDir             string
+	MaxCpus             int
+	CheckTidyFileExists bool
+}
+
+// TidyOneDir merges every csv file in p.RawDir with the csv file of the same name in p.MissingDir by key,
+// and saves it to p.TidyDir. Raw files without missing files are copied as they are.
+func TidyOneDir[T any](kind TidyKind[T], p TidyOneDirParams) error {
+	err := os.MkdirAll(p.TidyDir, 0777)
+	if err != nil {
+		return err
+	}
+	files, err := os.ReadDir(p.RawDir)
+	if err != nil {
+		return err
+	}
+
+	if p.MaxCpus <= 0 {
+		p.MaxCpus = 1
+	}
+
+	wg, ctx := errgroup.WithContext(context.Background())
+	wg.SetLimit(p.MaxCpus)
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".csv") {
+			continue
+		}
+		file := file
+		wg.Go(func() error {
+			tidyFilePath := filepath.Join(p.TidyDir, file.Name())
+			if p.CheckTidyFileExists {
+				tidyFileExists, err := FileExists(tidyFilePath)
+				if err != nil {
+					return err
+				}
+				if tidyFileExists {
+					return nil
+				}
+			}
+			missingFilePath := filepath.Join(p.MissingDir, file.Name())
+			missingFileExists, err := FileExists(missingFilePath)
+			if err != nil {
+				return err
+			}
+			rawFilePath := filepath.Join(p.RawDir, file.Name())
+			if !missingFileExists {
+				gLogger.Info("Copying Raw Items", "kind", kind.Name, "file", file.Name())
+				if err := copyFile(rawFilePath, tidyFilePath); err != nil {
+					return err
+				}
+				gLogger.Info("Copied Raw Items", "kind", kind.Name, "file", file.Name())
+				return nil
+			}
+			gLogger.Info("Merging Raw And Missing Items", "kind", kind.Name, "file", file.Name())
+			missingItems, err := ReadLinesToStructs(missingFilePath, kind.LineToStruct)
+			if err != nil {
+				return err
+			}
+			sort.Slice(missingItems, func(i, j int) bool {
+				return kind.Key(missingItems[i]) < kind.Key(missingItems[j])
+			})
+			n, err := mergeAndSave(ctx, kind, rawFilePath, missingItems, tidyFilePath)
+			if err != nil {
+				return err
+			}
+			gLogger.Info("Saved Tidy Items", "kind", kind.Name, "file", file.Name(), "len", n)
+			return nil
+		})
+	}
+
+	return wg.Wait()
+}
+
+func copyFile(srcPath, dstPath string) (err error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.Copy(dst, src)
+	return
+}
+
+// mergeAndSave streams the raw file, merges the sorted missing items into it by key,
+// and writes the result to tidyFilePath with kind.Header, returning the number of written items.
+// Missing items whose keys are already in the raw file are dropped.
+func mergeAndSave[T any](ctx context.Context, kind TidyKind[T], rawFilePath string, missingItems []T, tidyFilePath string) (n int, err error) {
+	stream, err := StreamLinesToStructs(ctx, rawFilePath, kind.LineToStruct)
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+
+	dst, err := os.Create(tidyFilePath)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	w := bufio.NewWriter(dst)
+	if kind.Header != "" {
+		if _, err = w.WriteString(kind.Header); err != nil {
+			return
+		}
+	}
+	write := func(item T) error {
+		if n > 0 || kind.Header != "" {
+			if err := w.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		n++
+		_, err := w.WriteString(kind.CSVRow(item))
+		return err
+	}
+
+	var like *T
+	writeMissing := func(item T) error {
+		if kind.Align != nil && like != nil {
+			item = kind.Align(item, *like)
+		}
+		return write(item)
+	}
+
+	for stream.Next() {
+		item := stream.Struct()
+		like = &item
+		key := kind.Key(item)
+		for len(missingItems) > 0 && kind.Key(missingItems[0]) <= key {
+			if kind.Key(missingItems[0]) < key {
+				if err = writeMissing(missingItems[0]); err != nil {
+					return
+				}
+			}
+			missingItems = missingItems[1:]
+		}
+		if err = write(item); err != nil {
+			return
+		}
+	}
+	if err = stream.Err(); err != nil {
+		return
+	}
+	for _, item := range missingItems {
+		if err = writeMissing(item); err != nil {
+			return
+		}
+	}
+
+	err = w.Flush()
+	return
+}
+
+func dailyFileNameFunc(symbol string, dataType DataType, interval KlineInterval) func(day time.Time) string {
+	return func(day time.Time) string {
+		spec := DatasetSpec{Frequency: FrequencyDaily, DataType: dataType, Symbol: symbol, Interval: interval, Date: truncateDay(day)}
+		return spec.CSVFileName()
+	}
+}
+
+// AggTradesTidyKind returns the TidyKind of agg trades, whose key is Id.
+// Missing agg trades are downloaded by DownloadMissingAggTrades.
+// Files of futures are saved in the futures layout, with header and without is_best_match column.
+func AggTradesTidyKind(symbol string, tradesType bnc.AggTradesType) TidyKind[bnc.AggTrades] {
+	kind := TidyKind[bnc.AggTrades]{
+		Name:         string(DataTypeAggTrades),
+		Symbol:       symbol,
+		LineToStruct: AggTradeLineToStruct,
+		Key:          func(t bnc.AggTrades) int64 { return t.Id },
+		Step:         func(bnc.AggTrades) int64 { return 1 },
+		Time:         func(t bnc.AggTrades) int64 { return rowTimeToMilli(t.Time) },
+		Backfill: func(missing MissingRange) ([]bnc.AggTrades, error) {
+			return DownloadMissingAggTrades(symbol, tradesType, missing)
+		},
+		CSVRow:   func(t bnc.AggTrades) string { return t.CSVRow() },
+		FileName: dailyFileNameFunc(symbol, DataTypeAggTrades, ""),
+	}
+	if IsFuturesAggTradesType(tradesType) {
+		kind.CSVRow = FuturesAggTradeCSVRow
+		kind.Header = FUTURES_AGG_TRADES_CSV_HEADER
+	}
+	return kind
+}
+
+// SpotTradesTidyKind returns the TidyKind of spot trades, whose key is Id.
+// Missing trades are downloaded by DownloadMissingSpotTrades.
+func SpotTradesTidyKind(symbol string) TidyKind[bnc.SpotTrade] {
+	return TidyKind[bnc.SpotTrade]{
+		Name:         string(DataTypeTrades),
+		Symbol:       symbol,
+		LineToStruct: SpotTradeLineToStruct,
+		Key:          func(t bnc.SpotTrade) int64 { return t.Id },
+		Step:         func(bnc.SpotTrade) int64 { return 1 },
+		Time:         func(t bnc.SpotTrade) int64 { return rowTimeToMilli(t.Time) },
+		Backfill: func(missing MissingRange) ([]bnc.SpotTrade, error) {
+			return DownloadMissingSpotTrades(symbol, missing)
+		},
+		CSVRow:   SpotTradeCSVRow,
+		FileName: dailyFileNameFunc(symbol, DataTypeTrades, ""),
+	}
+}
+
+// KlinesTidyKind returns the TidyKind of klines of a fixed interval, whose key is OpenTime in milliseconds.
+// Open times in microseconds are supported, so gaps are continuous across files of both units,
+// such as spot klines which are in microseconds since 2025-01-01.
+// Missing klines are downloaded by DownloadMissingKlines, and converted to microseconds when merged into files in microseconds.
+func KlinesTidyKind(market Market, symbol string, interval KlineInterval) (TidyKind[bnc.Kline], error) {
+	step, ok := KlineIntervalToMilli[interval]
+	if !ok {
+		return TidyKind[bnc.Kline]{}, fmt.Errorf("%w: interval %q has no fixed length", ErrInvalidDatasetSpec, interval)
+	}
+	return TidyKind[bnc.Kline]{
+		Name:         string(DataTypeKlines),
+		Symbol:       symbol,
+		LineToStruct: KlineLineToStruct,
+		Key:          func(k bnc.Kline) int64 { return rowTimeToMilli(k.OpenTime) },
+		Step:         func(bnc.Kline) int64 { return step },
+		Time:         func(k bnc.Kline) int64 { return rowTimeToMilli(k.OpenTime) },
+		Backfill: func(missing MissingRange) ([]bnc.Kline, error) {
+			return DownloadMissingKlines(market, symbol, interval, missing)
+		},
+		CSVRow: func(k bnc.Kline) string { return k.CSVRow() },
+		Align: func(k, like bnc.Kline) bnc.Kline {
+			k = klineTimesToMilli(k)
+			if like.OpenTime >= 1e15 {
+				k.OpenTime *= 1000
+				k.CloseTime = k.CloseTime*1000 + 999
+			}
+			return k
+		},
+		FileName: dailyFileNameFunc(symbol, DataTypeKlines, interval),
+	}, nil
+}
+
+// ScanDatasetMissingsAndDownload scans the unzipped files of spec in layout,
+// and downloads the missing items to the missing directory of spec.
+// Agg trades, trades of spot and klines are supported.
+func ScanDatasetMissingsAndDownload(spec DatasetSpec, layout Layout, maxCpus int, startTime time.Time) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	rawDir, saveDir := spec.UnzipDir(layout), spec.MissingDir(layout)
+	switch spec.DataType {
+	case DataTypeAggTrades:
+		tradesType, err := spec.AggTradesType()
+		if err != nil {
+			return err
+		}
+		return ScanOneDirMissingsAndDownload(rawDir, saveDir, AggTradesTidyKind(spec.Symbol, tradesType), maxCpus, startTime)
+	case DataTypeTrades:
+		if spec.Market != MarketSpot {
+			return fmt.Errorf("%w: trades of market %q are not supported", ErrInvalidDatasetSpec, spec.Market)
+		}
+		return ScanOneDirMissingsAndDownload(rawDir, saveDir, SpotTradesTidyKind(spec.Symbol), maxCpus, startTime)
+	case DataTypeKlines:
+		kind, err := KlinesTidyKind(spec.Market, spec.Symbol, spec.Interval)
+		if err != nil {
+			return err
+		}
+		return ScanOneDirMissingsAndDownload(rawDir, saveDir, kind, maxCpus, startTime)
+	}
+	return fmt.Errorf("%w: tidying %s is not supported", ErrInvalidDatasetSpec, spec.DataType)
+}
+
+// TidyDataset merges the unzipped and the missing files of spec in layout into the tidy directory of spec.
+// Agg trades, trades of spot and klines are supported.
+func TidyDataset(spec DatasetSpec, layout Layout, maxCpus int, checkTidyFileExists bool) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	p := TidyOneDirParams{
+		RawDir:              spec.UnzipDir(layout),
+		MissingDir:          spec.MissingDir(layout),
+		TidyDir:             spec.TidyDir(layout),
+		MaxCpus:             maxCpus,
+		CheckTidyFileExists: checkTidyFileExists,
+	}
+	switch spec.DataType {
+	case DataTypeAggTrades:
+		tradesType, err := spec.AggTradesType()
+		if err != nil {
+			return err
+		}
+		return TidyOneDir(AggTradesTidyKind(spec.Symbol, tradesType), p)
+	case DataTypeTrades:
+		if spec.Market != MarketSpot {
+			return fmt.Errorf("%w: trades of market %q are not supported", ErrInvalidDatasetSpec, spec.Market)
+		}
+		return TidyOneDir(SpotTradesTidyKind(spec.Symbol), p)
+	case DataTypeKlines:
+		kind, err := KlinesTidyKind(spec.Market, spec.Symbol, spec.Interval)
+		if err != nil {
+			return err
+		}
+		return TidyOneDir(kind, p)
+	}
+	return fmt.Errorf("%w: tidying %s is not supported", ErrInvalidDatasetSpec, spec.DataType)
+}
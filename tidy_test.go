@@ -0,0 +1,217 @@
+package bncvision
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+func writeTestRows(t *testing.T, filePath string, rows []string) {
+	t.Helper()
+	if err := os.WriteFile(filePath, []byte(strings.Join(rows, "\n")), 0644); err != nil {
+		t.Fatalf("Failed to write csv file: %v", err)
+	}
+}
+
+func TestTidyOneDirSpotTrades(t *testing.T) {
+	root := t.TempDir()
+	rawDir := filepath.Join(root, "raw")
+	missingDir := filepath.Join(root, "missing")
+	tidyDir := filepath.Join(root, "tidy")
+	if err := os.MkdirAll(rawDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	trade := func(id int64) bnc.SpotTrade {
+		return bnc.SpotTrade{Id: id, Price: 100.5, Qty: 0.1, QuoteQty: 10.05, Time: 1609459200000 + id, IsBestMatch: true}
+	}
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-trades-2021-01-01.csv"), []string{
+		SpotTradeCSVRow(trade(1)),
+		SpotTradeCSVRow(trade(2)),
+		SpotTradeCSVRow(trade(5)),
+	})
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-trades-2021-01-02.csv"), []string{
+		SpotTradeCSVRow(trade(6)),
+	})
+	// skipped by startTime
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSDT-trades-2020-12-31.csv"), []string{
+		SpotTradeCSVRow(trade(-10)),
+	})
+
+	kind := SpotTradesTidyKind("BTCUSDT")
+	var backfilled []MissingRange
+	kind.Backfill = func(missing MissingRange) ([]bnc.SpotTrade, error) {
+		backfilled = append(backfilled, missing)
+		var trades []bnc.SpotTrade
+		for id := missing.StartId; id <= missing.EndId; id++ {
+			trades = append(trades, trade(id))
+		}
+		return trades, nil
+	}
+
+	err := ScanOneDirMissingsAndDownload(rawDir, missingDir, kind, 2, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("ScanOneDirMissingsAndDownload failed: %v", err)
+	}
+	if len(backfilled) != 1 || backfilled[0].StartId != 3 || backfilled[0].EndId != 4 {
+		t.Fatalf("Expected one missing range from 3 to 4, got %v", backfilled)
+	}
+
+	err = TidyOneDir(kind, TidyOneDirParams{RawDir: rawDir, MissingDir: missingDir, TidyDir: tidyDir, MaxCpus: 2})
+	if err != nil {
+		t.Fatalf("TidyOneDir failed: %v", err)
+	}
+	trades, err := ReadLinesToStructs(filepath.Join(tidyDir, "BTCUSDT-trades-2021-01-01.csv"), SpotTradeLineToStruct)
+	if err != nil {
+		t.Fatalf("Failed to read tidy file: %v", err)
+	}
+	if len(trades) != 5 {
+		t.Fatalf("Expected 5 trades, got %d", len(trades))
+	}
+	for i, tr := range trades {
+		if tr != trade(int64(i+1)) {
+			t.Errorf("Expected %v, got %v", trade(int64(i+1)), tr)
+		}
+	}
+	if exists, _ := FileExists(filepath.Join(tidyDir, "BTCUSDT-trades-2021-01-02.csv")); !exists {
+		t.Errorf("Expected the raw file without missing trades to be copied")
+	}
+}
+
+func TestKlinesTidyKindMissingRanges(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "raw")
+	missingDir := filepath.Join(root, "missing")
+	tidyDir := filepath.Join(root, "tidy")
+	for _, d := range []string{dir, missingDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	minute := time.Minute.Milliseconds()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	milliKline := func(openTime int64) bnc.Kline {
+		return bnc.Kline{OpenTime: openTime, CloseTime: openTime + minute - 1, OpenPrice: 1, HighPrice: 1, LowPrice: 1, ClosePrice: 1}
+	}
+	kline := func(openTime int64) string {
+		k := bnc.Kline{OpenTime: openTime * 1000, CloseTime: (openTime+minute)*1000 - 1, OpenPrice: 1, HighPrice: 1, LowPrice: 1, ClosePrice: 1}
+		return k.CSVRow()
+	}
+	// millisecond open times before 2025, continuous with the next file
+	last := milliKline(start - minute)
+	writeTestRows(t, filepath.Join(dir, "BTCUSDT-1m-2024-12-31.csv"), []string{last.CSVRow()})
+	// microsecond open times, as published since 2025
+	writeTestRows(t, filepath.Join(dir, "BTCUSDT-1m-2025-01-01.csv"), []string{
+		kline(start),
+		kline(start + minute),
+		kline(start + 4*minute),
+	})
+
+	kind, err := KlinesTidyKind(MarketSpot, "BTCUSDT", Kline1m)
+	if err != nil {
+		t.Fatalf("KlinesTidyKind failed: %v", err)
+	}
+	missings, err := OneDirMissingRanges(dir, kind, 1, time.Time{})
+	if err != nil {
+		t.Fatalf("OneDirMissingRanges failed: %v", err)
+	}
+	expected := MissingRange{
+		StartId:   start + 2*minute,
+		EndId:     start + 3*minute,
+		StartTime: start + minute,
+		EndTime:   start + 4*minute,
+	}
+	if len(missings) != 1 || missings[0] != expected {
+		t.Fatalf("Expected %v, got %v", expected, missings)
+	}
+	if name := kind.FileName(time.UnixMilli(expected.StartTime)); name != "BTCUSDT-1m-2025-01-01.csv" {
+		t.Errorf("Expected BTCUSDT-1m-2025-01-01.csv, got %s", name)
+	}
+
+	// back-filled klines in milliseconds are merged into the file in microseconds
+	if err := SaveMissingItems(missingDir, kind, []bnc.Kline{milliKline(start + 2*minute), milliKline(start + 3*minute)}); err != nil {
+		t.Fatalf("SaveMissingItems failed: %v", err)
+	}
+	if err := TidyOneDir(kind, TidyOneDirParams{RawDir: dir, MissingDir: missingDir, TidyDir: tidyDir, MaxCpus: 1}); err != nil {
+		t.Fatalf("TidyOneDir failed: %v", err)
+	}
+	klines, err := ReadLinesToStructs(filepath.Join(tidyDir, "BTCUSDT-1m-2025-01-01.csv"), KlineLineToStruct)
+	if err != nil {
+		t.Fatalf("Failed to read tidy file: %v", err)
+	}
+	if len(klines) != 5 {
+		t.Fatalf("Expected 5 klines, got %d", len(klines))
+	}
+	for i, k := range klines {
+		openTime := start + int64(i)*minute
+		if k.OpenTime != openTime*1000 || k.CloseTime != (openTime+minute)*1000-1 {
+			t.Errorf("Expected kline of open time %d in microseconds, got %+v", openTime, k)
+		}
+	}
+
+	if _, err := KlinesTidyKind(MarketSpot, "BTCUSDT", Kline1mo); err == nil {
+		t.Errorf("Expected error for 1mo klines")
+	}
+}
+
+func TestTidyOneDirFuturesAggTrades(t *testing.T) {
+	layout := NewLayout(t.TempDir())
+	spec := DatasetSpec{Market: MarketFuturesCM, Frequency: FrequencyDaily, DataType: DataTypeAggTrades, Symbol: "BTCUSD_PERP"}
+	tradesType, err := spec.AggTradesType()
+	if err != nil || tradesType != AggTradesTypeCmFutures {
+		t.Fatalf("Expected %s, got %s, %v", AggTradesTypeCmFutures, tradesType, err)
+	}
+	rawDir, missingDir, tidyDir := spec.UnzipDir(layout), spec.MissingDir(layout), spec.TidyDir(layout)
+	if err := os.MkdirAll(rawDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	aggTrade := func(id int64) bnc.AggTrades {
+		return bnc.AggTrades{Id: id, Price: 100, Qty: 2, FirstTradeId: id * 10, LastTradeId: id*10 + 9, Time: 1609459200000 + id, IsBuyerMaker: id%2 == 0}
+	}
+	writeTestRows(t, filepath.Join(rawDir, "BTCUSD_PERP-aggTrades-2021-01-01.csv"), []string{
+		FUTURES_AGG_TRADES_CSV_HEADER,
+		FuturesAggTradeCSVRow(aggTrade(1)),
+		FuturesAggTradeCSVRow(aggTrade(4)),
+	})
+
+	kind := AggTradesTidyKind(spec.Symbol, tradesType)
+	kind.Backfill = func(missing MissingRange) ([]bnc.AggTrades, error) {
+		var aggTrades []bnc.AggTrades
+		for id := missing.StartId; id <= missing.EndId; id++ {
+			aggTrades = append(aggTrades, aggTrade(id))
+		}
+		return aggTrades, nil
+	}
+	if err := ScanOneDirMissingsAndDownload(rawDir, missingDir, kind, 1, time.Time{}); err != nil {
+		t.Fatalf("ScanOneDirMissingsAndDownload failed: %v", err)
+	}
+	if err := TidyOneDir(kind, TidyOneDirParams{RawDir: rawDir, MissingDir: missingDir, TidyDir: tidyDir}); err != nil {
+		t.Fatalf("TidyOneDir failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tidyDir, "BTCUSD_PERP-aggTrades-2021-01-01.csv"))
+	if err != nil {
+		t.Fatalf("Failed to read tidy file: %v", err)
+	}
+	lines := strings.Split(string(data), "\n")
+	if len(lines) != 5 || lines[0] != FUTURES_AGG_TRADES_CSV_HEADER {
+		t.Fatalf("Expected header and 4 rows, got %q", lines)
+	}
+	for i, line := range lines[1:] {
+		if strings.Count(line, ",") != 6 {
+			t.Errorf("Expected 7 columns, got %q", line)
+		}
+		got, err := AggTradeLineToStruct([]byte(line))
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", line, err)
+		}
+		if got != aggTrade(int64(i+1)) {
+			t.Errorf("Expected %v, got %v", aggTrade(int64(i+1)), got)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package bncvision
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+// TradeSource queries agg trades by id, it's what the agg trades gap filler depends on,
+// so back-filling can be tested without Binance.
+type TradeSource interface {
+	// AggTrades returns at most params.Limit agg trades of params.Symbol from params.FromId, sorted by id.
+	AggTrades(params bnc.AggTradesParams) ([]bnc.AggTrades, error)
+}
+
+// aggTradesAPIPaths are the agg trades api paths of the Binance REST apis.
+var aggTradesAPIPaths = map[bnc.AggTradesType]string{
+	bnc.AggTradesTypeSpot:      "/api/v3/aggTrades",
+	bnc.AggTradesTypeUmFutures: "/fapi/v1/aggTrades",
+	AggTradesTypeCmFutures:     "/dapi/v1/aggTrades",
+}
+
+// aggTradesAPIBaseURLs are the base urls of the Binance REST apis of agg trades.
+var aggTradesAPIBaseURLs = map[bnc.AggTradesType]string{
+	bnc.AggTradesTypeSpot:      "https://api.binance.com",
+	bnc.AggTradesTypeUmFutures: "https://fapi.binance.com",
+	AggTradesTypeCmFutures:     "https://dapi.binance.com",
+}
+
+// RESTTradeSource queries agg trades from the Binance REST api of TradesType.
+type RESTTradeSource struct {
+	TradesType bnc.AggTradesType
+	// BaseURL is the base url of the api, such as the url of a FakeAggTradesServer.
+	// If empty, the Binance api of TradesType, such as https://api.binance.com for spot.
+	BaseURL string
+	// Client sends the requests, DefaultClient if nil.
+	Client *Client
+}
+
+func (s RESTTradeSource) client() *Client {
+	if s.Client == nil {
+		return DefaultClient
+	}
+	return s.Client
+}
+
+func (s RESTTradeSource) AggTrades(params bnc.AggTradesParams) ([]bnc.AggTrades, error) {
+	path, ok := aggTradesAPIPaths[s.TradesType]
+	if !ok {
+		return nil, fmt.Errorf("invalid agg trades type %q", s.TradesType)
+	}
+	baseURL := s.BaseURL
+	if baseURL == "" {
+		baseURL = aggTradesAPIBaseURLs[s.TradesType]
+	}
+	return s.client().queryAggTrades(baseURL+path, params)
+}
+
+// TradeSourceFault is a scripted result of one query of a LocalTradeSource.
+type TradeSourceFault struct {
+	// Err is returned instead of trades if not nil.
+	// Use an HTTPStatusError of 429 to simulate rate limits.
+	Err error
+	// Empty returns an empty page instead of trades.
+	Empty bool
+}
+
+// RateLimitFault returns a fault of a 429 response with Retry-After.
+// Retry-After is sent in whole seconds by a FakeAggTradesServer.
+func RateLimitFault(retryAfter time.Duration) TradeSourceFault {
+	return TradeSourceFault{Err: &HTTPStatusError{URL: "local", StatusCode: http.StatusTooManyRequests, RetryAfter: retryAfter}}
+}
+
+// LocalTradeSource serves agg trades from memory, such as the agg trades of fixture csv files.
+// Injected faults are consumed one per query before any trade is served.
+// It's safe for concurrent use.
+type LocalTradeSource struct {
+	mu      sync.Mutex
+	trades  []bnc.AggTrades
+	faults  []TradeSourceFault
+	queries int
+}
+
+// NewLocalTradeSource returns a LocalTradeSource serving a sorted copy of trades.
+func NewLocalTradeSource(trades []bnc.AggTrades) *LocalTradeSource {
+	trades = append([]bnc.AggTrades(nil), trades...)
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].Id < trades[j].Id
+	})
+	return &LocalTradeSource{trades: trades}
+}
+
+// LoadLocalTradeSource returns a LocalTradeSource serving the agg trades of csv files in the spot or futures layout.
+func LoadLocalTradeSource(filePaths ...string) (*LocalTradeSource, error) {
+	var trades []bnc.AggTrades
+	for _, filePath := range filePaths {
+		ts, err := ReadLinesToStructs(filePath, AggTradeLineToStruct)
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, ts...)
+	}
+	return NewLocalTradeSource(trades), nil
+}
+
+// InjectFaults queues faults, which are returned by the following queries in order.
+func (s *LocalTradeSource) InjectFaults(faults ...TradeSourceFault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, faults...)
+}
+
+// Queries returns the number of queries, including the failed ones.
+func (s *LocalTradeSource) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+// AggTrades returns at most params.Limit agg trades from params.FromId, 500 if Limit is not positive like Binance.
+// Symbol is not checked.
+func (s *LocalTradeSource) AggTrades(params bnc.AggTradesParams) ([]bnc.AggTrades, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries++
+	if len(s.faults) > 0 {
+		fault := s.faults[0]
+		s.faults = s.faults[1:]
+		if fault.Err != nil {
+			return nil, fault.Err
+		}
+		if fault.Empty {
+			return []bnc.AggTrades{}, nil
+		}
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = 500
+	}
+	i := sort.Search(len(s.trades), func(i int) bool { return s.trades[i].Id >= params.FromId })
+	end := min(i+limit, len(s.trades))
+	return append([]bnc.AggTrades{}, s.trades[i:end]...), nil
+}
+
+// FakeAggTradesServer is an in-process server speaking the agg trades protocol of the Binance REST apis,
+// /api/v3/aggTrades, /fapi/v1/aggTrades and /dapi/v1/aggTrades, backed by a LocalTradeSource.
+// Errors of the source are served as their HTTPStatusError status codes with Retry-After, or 500.
+type FakeAggTradesServer struct {
+	Source *LocalTradeSource
+
+	server *httptest.Server
+}
+
+// NewFakeAggTradesServer starts a FakeAggTradesServer serving source.
+// The caller must Close the server.
+func NewFakeAggTradesServer(source *LocalTradeSource) *FakeAggTradesServer {
+	s := &FakeAggTradesServer{Source: source}
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+// URL returns the base url of the server.
+func (s *FakeAggTradesServer) URL() string {
+	return s.server.URL
+}
+
+// Close shuts down the server.
+func (s *FakeAggTradesServer) Close() {
+	s.server.Close()
+}
+
+// TradeSource returns a RESTTradeSource of tradesType querying the server.
+func (s *FakeAggTradesServer) TradeSource(tradesType bnc.AggTradesType) RESTTradeSource {
+	return RESTTradeSource{
+		TradesType: tradesType,
+		BaseURL:    s.server.URL,
+		Client:     &Client{HTTPClient: s.server.Client()},
+	}
+}
+
+func (s *FakeAggTradesServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	known := false
+	for _, path := range aggTradesAPIPaths {
+		known = known || r.URL.Path == path
+	}
+	if !known {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	params := bnc.AggTradesParams{Symbol: query.Get("symbol")}
+	if params.Symbol == "" {
+		writeFakeAPIError(w, http.StatusBadRequest, -1102, "Mandatory parameter 'symbol' was not sent")
+		return
+	}
+	var err error
+	if fromId := query.Get("fromId"); fromId != "" {
+		if params.FromId, err = strconv.ParseInt(fromId, 10, 64); err != nil {
+			writeFakeAPIError(w, http.StatusBadRequest, -1100, "Illegal characters found in parameter 'fromId'")
+			return
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if params.Limit, err = strconv.Atoi(limit); err != nil || params.Limit > 1000 {
+			writeFakeAPIError(w, http.StatusBadRequest, -1100, "Illegal characters found in parameter 'limit'")
+			return
+		}
+	}
+
+	trades, err := s.Source.AggTrades(params)
+	if err != nil {
+		var statusErr *HTTPStatusError
+		if !errors.As(err, &statusErr) {
+			writeFakeAPIError(w, http.StatusInternalServerError, -1000, err.Error())
+			return
+		}
+		if statusErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(statusErr.RetryAfter.Seconds())))
+		}
+		writeFakeAPIError(w, statusErr.StatusCode, -1003, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trades)
+}
+
+func writeFakeAPIError(w http.ResponseWriter, statusCode, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]any{"code": code, "msg": msg})
+}
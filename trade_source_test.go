@@ -0,0 +1,98 @@
+package bncvision
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+func testTradeSourceAggTrades(n int64) []bnc.AggTrades {
+	var aggTrades []bnc.AggTrades
+	for id := int64(1); id <= n; id++ {
+		aggTrades = append(aggTrades, bnc.AggTrades{Id: id, Price: 1, Qty: 1, FirstTradeId: id, LastTradeId: id, Time: 1609459200000 + id})
+	}
+	return aggTrades
+}
+
+func TestDownloadMissingAggTradesFromLocalTradeSource(t *testing.T) {
+	source := NewLocalTradeSource(testTradeSourceAggTrades(2500))
+	source.InjectFaults(RateLimitFault(0), TradeSourceFault{Err: errors.New("connection reset")})
+
+	missing := MissingRange{StartId: 10, EndId: 2100}
+	trades, err := DownloadMissingAggTradesFrom(source, "BTCUSDT", missing, RetryPolicy{}, 3)
+	if err != nil {
+		t.Fatalf("DownloadMissingAggTradesFrom failed: %v", err)
+	}
+	if len(trades) != 2091 || trades[0].Id != 10 || trades[len(trades)-1].Id != 2100 {
+		t.Fatalf("Expected trades from 10 to 2100, got %d trades", len(trades))
+	}
+	// 2 faults and 3 pages
+	if source.Queries() != 5 {
+		t.Errorf("Expected 5 queries, got %d", source.Queries())
+	}
+
+	source.InjectFaults(RateLimitFault(0), RateLimitFault(0))
+	if _, err := DownloadMissingAggTradesFrom(source, "BTCUSDT", missing, RetryPolicy{}, 2); err == nil {
+		t.Errorf("Expected error after all attempts are rate limited")
+	}
+
+	source.InjectFaults(TradeSourceFault{Empty: true})
+	trades, err = DownloadMissingAggTradesFrom(source, "BTCUSDT", missing, RetryPolicy{}, 1)
+	if err != nil || len(trades) != 0 {
+		t.Errorf("Expected no trades after an empty page, got %d, %v", len(trades), err)
+	}
+}
+
+func TestFakeAggTradesServer(t *testing.T) {
+	local := NewLocalTradeSource(testTradeSourceAggTrades(1500))
+	server := NewFakeAggTradesServer(local)
+	defer server.Close()
+
+	for _, tradesType := range []bnc.AggTradesType{bnc.AggTradesTypeSpot, bnc.AggTradesTypeUmFutures, AggTradesTypeCmFutures} {
+		source := server.TradeSource(tradesType)
+		trades, err := source.AggTrades(bnc.AggTradesParams{Symbol: "BTCUSDT", FromId: 1400, Limit: 1000})
+		if err != nil {
+			t.Fatalf("%s: AggTrades failed: %v", tradesType, err)
+		}
+		if len(trades) != 101 || trades[0] != testTradeSourceAggTrades(1400)[1399] {
+			t.Errorf("%s: Unexpected trades %v", tradesType, trades)
+		}
+	}
+
+	source := server.TradeSource(bnc.AggTradesTypeSpot)
+	local.InjectFaults(RateLimitFault(0))
+	_, err := source.AggTrades(bnc.AggTradesParams{Symbol: "BTCUSDT", Limit: 1000})
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests || IsPermanentDownloadError(err) {
+		t.Errorf("Expected transient 429, got %v", err)
+	}
+	if _, err := source.AggTrades(bnc.AggTradesParams{Limit: 1000}); !IsPermanentDownloadError(err) {
+		t.Errorf("Expected permanent error without symbol, got %v", err)
+	}
+
+	local.InjectFaults(TradeSourceFault{Err: errors.New("internal")}, TradeSourceFault{Empty: true})
+	trades, err := DownloadMissingAggTradesFrom(source, "BTCUSDT", MissingRange{StartId: 1, EndId: 1200}, RetryPolicy{}, 2)
+	if err != nil || len(trades) != 0 {
+		t.Errorf("Expected no trades after a 500 and an empty page, got %d, %v", len(trades), err)
+	}
+	trades, err = DownloadMissingAggTradesFrom(source, "BTCUSDT", MissingRange{StartId: 1, EndId: 1200}, RetryPolicy{}, 1)
+	if err != nil || len(trades) != 1200 {
+		t.Errorf("Expected 1200 trades, got %d, %v", len(trades), err)
+	}
+}
+
+func TestRESTTradeSourceDefaultBaseURLs(t *testing.T) {
+	for tradesType, path := range aggTradesAPIPaths {
+		if _, ok := aggTradesAPIBaseURLs[tradesType]; !ok {
+			t.Errorf("Expected the base url of %s", tradesType)
+		}
+		if tradesType == AggTradesTypeCmFutures && aggTradesAPIBaseURLs[tradesType]+path != CM_FUTURES_AGG_TRADES_URL {
+			t.Errorf("Expected %s, got %s", CM_FUTURES_AGG_TRADES_URL, aggTradesAPIBaseURLs[tradesType]+path)
+		}
+	}
+	if _, err := (RESTTradeSource{TradesType: "options"}).AggTrades(bnc.AggTradesParams{Symbol: "BTCUSDT"}); err == nil {
+		t.Errorf("Expected error for an invalid agg trades type")
+	}
+}
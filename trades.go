@@ -0,0 +1,86 @@
+package bncvision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dwdwow/cex/bnc"
+)
+
+// SPOT_HISTORICAL_TRADES_URL is the spot api of old trades queried by id.
+const SPOT_HISTORICAL_TRADES_URL = "https://api.binance.com/api/v3/historicalTrades"
+
+// SpotTradeCSVRow serializes a spot trade in the column order of the trades files of data.binance.vision.
+func SpotTradeCSVRow(trade bnc.SpotTrade) string {
+	cells := []string{
+		strconv.FormatInt(trade.Id, 10),
+		strconv.FormatFloat(trade.Price, 'f', -1, 64),
+		strconv.FormatFloat(trade.Qty, 'f', -1, 64),
+		strconv.FormatFloat(trade.QuoteQty, 'f', -1, 64),
+		strconv.FormatInt(trade.Time, 10),
+		strconv.FormatBool(trade.IsBuyerMaker),
+		strconv.FormatBool(trade.IsBestMatch),
+	}
+	return strings.Join(cells, ",")
+}
+
+// querySpotHistoricalTrades queries at most limit spot trades from fromId.
+func (c *Client) querySpotHistoricalTrades(symbol string, fromId int64, limit int) ([]bnc.SpotTrade, error) {
+	query := url.Values{}
+	query.Set("symbol", symbol)
+	query.Set("fromId", strconv.FormatInt(fromId, 10))
+	query.Set("limit", strconv.Itoa(limit))
+	reqURL := SPOT_HISTORICAL_TRADES_URL + "?" + query.Encode()
+	resp, err := c.get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPStatusError(reqURL, resp)
+	}
+	var trades []bnc.SpotTrade
+	if err := json.NewDecoder(resp.Body).Decode(&trades); err != nil {
+		return nil, err
+	}
+	return trades, nil
+}
+
+// DownloadMissingSpotTrades downloads the spot trades from missing.StartId to missing.EndId by DefaultClient, sorted by id.
+func DownloadMissingSpotTrades(symbol string, missing MissingRange) (trades []bnc.SpotTrade, err error) {
+	return DefaultClient.DownloadMissingSpotTrades(symbol, missing)
+}
+
+// DownloadMissingSpotTrades downloads the spot trades from missing.StartId to missing.EndId, sorted by id.
+// Every page is attempted at most MISSING_AGG_TRADES_TRY_COUNT times with c.Retry between attempts,
+// permanent errors are never retried.
+func (c *Client) DownloadMissingSpotTrades(symbol string, missing MissingRange) (trades []bnc.SpotTrade, err error) {
+	fromId := missing.StartId
+	for fromId <= missing.EndId {
+		var ts []bnc.SpotTrade
+		ts, err = retryQuery(c.Retry, MISSING_AGG_TRADES_TRY_COUNT, func() ([]bnc.SpotTrade, error) {
+			return c.querySpotHistoricalTrades(symbol, fromId, 1000)
+		}, "Query spot trades attempt failed, retrying", "symbol", symbol, "fromId", fromId)
+		if err != nil {
+			return
+		}
+		if len(ts) == 0 {
+			break
+		}
+		for _, t := range ts {
+			if t.Id > missing.EndId {
+				break
+			}
+			trades = append(trades, t)
+		}
+		fromId = ts[len(ts)-1].Id + 1
+	}
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].Id < trades[j].Id
+	})
+	return
+}
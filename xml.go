@@ -6,6 +6,8 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strings"
 	"sync"
 
@@ -38,49 +40,54 @@ type DataVisionXMLContent struct {
 	StorageClass string `xml:"StorageClass"`
 }
 
-func QueryDataVisionXML(prefix, marker string) (xmls []DataVisionXML, prefixes []DataVisionXMLCommonPrefixes, contents []DataVisionXMLContent, err error) {
+// QueryDataVisionXML lists all common prefixes and contents directly under prefix,
+// following NextMarker until the listing is not truncated.
+func (c *Client) QueryDataVisionXML(prefix, marker string) (xmls []DataVisionXML, prefixes []DataVisionXMLCommonPrefixes, contents []DataVisionXMLContent, err error) {
 	prefix = strings.Trim(prefix, "/") + "/"
-	url := "https://s3-ap-northeast-1.amazonaws.com/data.binance.vision?delimiter=/&prefix=" + prefix
+	for {
+		var x DataVisionXML
+		x, err = c.queryDataVisionXMLPage(prefix, marker)
+		if err != nil {
+			return
+		}
+		xmls = append(xmls, x)
+		prefixes = append(prefixes, x.CommonPrefixes...)
+		contents = append(contents, x.Contents...)
+
+		if x.NextMarker == "" {
+			return
+		}
+		marker = x.NextMarker
+	}
+}
+
+func (c *Client) queryDataVisionXMLPage(prefix, marker string) (x DataVisionXML, err error) {
+	query := url.Values{}
+	query.Set("delimiter", "/")
+	query.Set("prefix", prefix)
 	if marker != "" {
-		url += "&marker=" + marker
+		query.Set("marker", marker)
 	}
-	resp, err := http.Get(url)
+	resp, err := c.get(c.ListURL + "?" + query.Encode())
 	if err != nil {
 		return
 	}
-	defer func() {
-		err = resp.Body.Close()
-	}()
+	defer resp.Body.Close()
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return
 	}
-	x := DataVisionXML{}
-	err = xml.Unmarshal(data, &x)
-	if err != nil {
-		return
-	}
-	xmls = append(xmls, x)
-	prefixes = append(prefixes, x.CommonPrefixes...)
-	contents = append(contents, x.Contents...)
-
-	nextMarker := x.NextMarker
-	if nextMarker == "" {
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("failed to query data vision xml: %w", newHTTPStatusError(c.ListURL, resp))
 		return
 	}
-
-	nextMarker = strings.ReplaceAll(nextMarker, "/", "%2F")
-
-	_xmls, _prefixs, _contents, err := QueryDataVisionXML(prefix, nextMarker)
-
-	xmls = append(xmls, _xmls...)
-	prefixes = append(prefixes, _prefixs...)
-	contents = append(contents, _contents...)
-
+	err = xml.Unmarshal(data, &x)
 	return
 }
 
-func DownloadWithXMLContents(contents []DataVisionXMLContent, localParentDir string, maxDownloadingNum int8) (undownloadContents []DataVisionXMLContent, err error) {
+// DownloadWithXMLContents downloads the zip files of contents to layout.Raw, existing files are skipped.
+// All workers share c.Limiter, so maxDownloadingNum does not raise the request or bytes rate.
+func (c *Client) DownloadWithXMLContents(contents []DataVisionXMLContent, layout Layout, maxDownloadingNum int8) (undownloadContents []DataVisionXMLContent, err error) {
 	wg := errgroup.Group{}
 	wg.SetLimit(int(maxDownloadingNum))
 	mu := sync.Mutex{}
@@ -91,9 +98,9 @@ func DownloadWithXMLContents(contents []DataVisionXMLContent, localParentDir str
 		}
 		content := content
 		wg.Go(func() error {
-			fileUrl := DATA_VISION_URL + "/" + fileRelativePath
+			fileUrl := c.FileURL(fileRelativePath)
 			gLogger.Info("prepare to download file", "url", fileUrl)
-			fileLocation := localParentDir + "/" + fileRelativePath
+			fileLocation := filepath.Join(layout.Raw, filepath.FromSlash(fileRelativePath))
 			fileExists, err := FileExists(fileLocation)
 			if err != nil {
 				mu.Lock()
@@ -105,7 +112,7 @@ func DownloadWithXMLContents(contents []DataVisionXMLContent, localParentDir str
 				slog.Info("file exists", "file", fileLocation)
 				return nil
 			}
-			err = DownloadSaveZipWithRetryAndValidate(fileLocation, fileUrl, 3)
+			err = c.DownloadSaveZipWithRetryAndValidate(fileLocation, fileUrl, 3)
 			if err != nil {
 				gLogger.Error("downloading file", "err", err)
 				mu.Lock()
@@ -121,11 +128,27 @@ func DownloadWithXMLContents(contents []DataVisionXMLContent, localParentDir str
 	return
 }
 
-func DownloadAllUnderPath(prefix string, maxDownloadingNum int8) (undownloadContents []DataVisionXMLContent, err error) {
-	_, _, contents, err := QueryDataVisionXML(prefix, "")
+// DownloadAllUnderPath downloads all zip files under prefix to layout.Raw.
+func (c *Client) DownloadAllUnderPath(prefix string, layout Layout, maxDownloadingNum int8) (undownloadContents []DataVisionXMLContent, err error) {
+	_, _, contents, err := c.QueryDataVisionXML(prefix, "")
 	if err != nil {
 		return
 	}
-	undownloadContents, err = DownloadWithXMLContents(contents, homeDir+"/"+DATA_BINANCE_VISION, maxDownloadingNum)
+	undownloadContents, err = c.DownloadWithXMLContents(contents, layout, maxDownloadingNum)
 	return
 }
+
+// QueryDataVisionXML lists prefix with DefaultClient, see Client.QueryDataVisionXML.
+func QueryDataVisionXML(prefix, marker string) (xmls []DataVisionXML, prefixes []DataVisionXMLCommonPrefixes, contents []DataVisionXMLContent, err error) {
+	return DefaultClient.QueryDataVisionXML(prefix, marker)
+}
+
+// DownloadWithXMLContents downloads contents with DefaultClient, see Client.DownloadWithXMLContents.
+func DownloadWithXMLContents(contents []DataVisionXMLContent, layout Layout, maxDownloadingNum int8) (undownloadContents []DataVisionXMLContent, err error) {
+	return DefaultClient.DownloadWithXMLContents(contents, layout, maxDownloadingNum)
+}
+
+// DownloadAllUnderPath downloads all zip files under prefix with DefaultClient, see Client.DownloadAllUnderPath.
+func DownloadAllUnderPath(prefix string, layout Layout, maxDownloadingNum int8) (undownloadContents []DataVisionXMLContent, err error) {
+	return DefaultClient.DownloadAllUnderPath(prefix, layout, maxDownloadingNum)
+}
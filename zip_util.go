@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -28,6 +29,10 @@ func UnzipAllAndSaveInDir(zipDir string, destDir string) error {
 	wg.SetLimit(maxWorkers)
 
 	for _, file := range files {
+		// checksum and partially downloaded files are kept next to the zip files
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".zip") {
+			continue
+		}
 		zipFilePath := filepath.Join(zipDir, file.Name())
 		wg.Go(func() error {
 			slog.Info("unzipping", "file", zipFilePath)
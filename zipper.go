@@ -251,6 +251,12 @@ func IsZippedFileValid(filePath string) error {
 	if !strings.HasSuffix(filePath, ".zip") {
 		return fmt.Errorf("file is not a zip file")
 	}
+	return isZipFileValid(filePath)
+}
+
+// isZipFileValid is IsZippedFileValid without the file extension check,
+// so partially downloaded files can be checked before they are renamed.
+func isZipFileValid(filePath string) error {
 	// Open the file
 	file, err := os.Open(filePath)
 	if err != nil {